@@ -0,0 +1,169 @@
+package amurl
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Kind is what a link points to.
+type Kind string
+
+const (
+	Album        Kind = "album"
+	Song         Kind = "song"
+	Playlist     Kind = "playlist"
+	MusicVideo   Kind = "music-video"
+	Artist       Kind = "artist"
+	Curator      Kind = "curator"
+	AppleCurator Kind = "apple-curator"
+	RecordLabel  Kind = "record-label"
+	Room         Kind = "room"
+	MultiRoom    Kind = "multi-room"
+	Library      Kind = "library" // an album (l.), playlist (p.) or song (i.) of the user's library
+	ISRC         Kind = "isrc"
+	UPC          Kind = "upc"
+)
+
+// Ref is a parsed link or identifier.
+type Ref struct {
+	Kind       Kind
+	Storefront string // "" when the link has none, e.g. library links
+	ID         string
+	TrackID    string // the ?i= song of an album link
+}
+
+var (
+	hosts = map[string]bool{
+		"music.apple.com":       true,
+		"beta.music.apple.com":  true,
+		"geo.music.apple.com":   true,
+		"embed.music.apple.com": true,
+		"itunes.apple.com":      true,
+	}
+	storefront = regexp.MustCompile(`^[a-z]{2}$`)
+	catalogId  = regexp.MustCompile(`^(?:id)?(\d+)$`)
+	playlistId = regexp.MustCompile(`^pl\.[\w-]+$`)
+	libraryId  = regexp.MustCompile(`^[lpi]\.[\w-]+$`)
+	number     = regexp.MustCompile(`^\d+$`)
+)
+
+// kinds maps the path segment of a link to its kind.
+var kinds = map[string]Kind{
+	"album":         Album,
+	"song":          Song,
+	"playlist":      Playlist,
+	"music-video":   MusicVideo,
+	"artist":        Artist,
+	"curator":       Curator,
+	"apple-curator": AppleCurator,
+	"label":         RecordLabel,
+	"record-label":  RecordLabel,
+	"room":          Room,
+	"multi-room":    MultiRoom,
+}
+
+// DefaultAPIBase is the Apple Music API.
+const DefaultAPIBase = "https://amp-api.music.apple.com"
+
+// APIBase is where catalog, library and search requests go, DefaultAPIBase
+// unless api-base-url says otherwise.
+var APIBase = DefaultAPIBase
+
+// API is the URL of an API path such as /v1/catalog/us/albums/1.
+func API(path string) string {
+	return strings.TrimSuffix(APIBase, "/") + path
+}
+
+// ErrUnsupported is returned for input that is not a link or identifier
+// this package knows.
+var ErrUnsupported = errors.New("unsupported link")
+
+// ErrStation is returned for station links: a station is a stream that
+// is picked as it plays, there is no list of tracks to download.
+var ErrStation = errors.New("stations cannot be downloaded")
+
+// Parse reads an Apple Music or iTunes link, a bare library ID, or an
+// isrc:/upc: identifier.
+//
+//	https://music.apple.com/us/album/name/1624945511?i=1624945512
+//	https://music.apple.com/us/album/1624945511
+//	https://geo.music.apple.com/us/album/_/1624945511?app=music&at=x
+//	https://itunes.apple.com/us/album/name/id1624945511
+//	https://music.apple.com/library/playlist/p.AbCd
+//	p.AbCd, isrc:USUM71703861, upc:00602557...
+func Parse(raw string) (Ref, error) {
+	raw = strings.TrimSpace(raw)
+	if prefix, value, ok := strings.Cut(raw, ":"); ok {
+		switch strings.ToLower(prefix) {
+		case "isrc":
+			return Ref{Kind: ISRC, ID: strings.ToUpper(strings.TrimSpace(value))}, nil
+		case "upc":
+			return Ref{Kind: UPC, ID: strings.TrimSpace(value)}, nil
+		}
+	}
+	if libraryId.MatchString(raw) {
+		return Ref{Kind: Library, ID: raw}, nil
+	}
+	if !strings.Contains(raw, "://") && strings.Contains(raw, "apple.com/") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Ref{}, err
+	}
+	if !hosts[strings.ToLower(u.Host)] {
+		return Ref{}, ErrUnsupported
+	}
+	var segments []string
+	for _, s := range strings.Split(u.Path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	var ref Ref
+	if len(segments) > 0 && storefront.MatchString(segments[0]) {
+		ref.Storefront = segments[0]
+		segments = segments[1:]
+	}
+	if len(segments) < 2 {
+		return Ref{}, ErrUnsupported
+	}
+	// the ID is the last segment, any slug in between is ignored
+	id := segments[len(segments)-1]
+	if segments[0] == "library" {
+		if !libraryId.MatchString(id) {
+			return Ref{}, ErrUnsupported
+		}
+		ref.Kind, ref.ID = Library, id
+		return ref, nil
+	}
+	if segments[0] == "station" {
+		return Ref{}, ErrStation
+	}
+	kind, ok := kinds[segments[0]]
+	if !ok {
+		return Ref{}, ErrUnsupported
+	}
+	ref.Kind = kind
+	switch kind {
+	case Playlist:
+		if !playlistId.MatchString(id) {
+			return Ref{}, ErrUnsupported
+		}
+		ref.ID = id
+	default:
+		m := catalogId.FindStringSubmatch(id)
+		if m == nil {
+			return Ref{}, ErrUnsupported
+		}
+		ref.ID = m[1]
+	}
+	if kind == Album {
+		if i := u.Query().Get("i"); number.MatchString(i) {
+			ref.TrackID = i
+		}
+	}
+	return ref, nil
+}
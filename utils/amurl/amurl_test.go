@@ -0,0 +1,103 @@
+package amurl
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want Ref
+	}{
+		// hosts
+		{"https://music.apple.com/us/album/1989/1713845538", Ref{Kind: Album, Storefront: "us", ID: "1713845538"}},
+		{"https://beta.music.apple.com/us/album/1989/1713845538", Ref{Kind: Album, Storefront: "us", ID: "1713845538"}},
+		{"https://geo.music.apple.com/gb/album/_/1713845538?app=music&at=1000lHKX", Ref{Kind: Album, Storefront: "gb", ID: "1713845538"}},
+		{"https://embed.music.apple.com/jp/album/1989/1713845538", Ref{Kind: Album, Storefront: "jp", ID: "1713845538"}},
+		{"https://itunes.apple.com/us/album/1989/id1713845538", Ref{Kind: Album, Storefront: "us", ID: "1713845538"}},
+		{"HTTPS://Music.Apple.com/us/album/1989/1713845538", Ref{Kind: Album, Storefront: "us", ID: "1713845538"}},
+		{"music.apple.com/us/album/1989/1713845538", Ref{Kind: Album, Storefront: "us", ID: "1713845538"}},
+		{"  https://music.apple.com/us/album/1989/1713845538\n", Ref{Kind: Album, Storefront: "us", ID: "1713845538"}},
+
+		// slug and storefront
+		{"https://music.apple.com/us/album/1713845538", Ref{Kind: Album, Storefront: "us", ID: "1713845538"}},
+		{"https://music.apple.com/album/1989/1713845538", Ref{Kind: Album, ID: "1713845538"}},
+		{"https://music.apple.com/album/1713845538", Ref{Kind: Album, ID: "1713845538"}},
+		{"https://music.apple.com/us/album/1989-taylors-version/1713845538/", Ref{Kind: Album, Storefront: "us", ID: "1713845538"}},
+
+		// ?i= track links and query junk
+		{"https://music.apple.com/us/album/1989/1713845538?i=1713845540", Ref{Kind: Album, Storefront: "us", ID: "1713845538", TrackID: "1713845540"}},
+		{"https://music.apple.com/us/album/1989/1713845538?l=en-GB&i=1713845540&ls", Ref{Kind: Album, Storefront: "us", ID: "1713845538", TrackID: "1713845540"}},
+		{"https://music.apple.com/us/album/1989/1713845538?i=abc", Ref{Kind: Album, Storefront: "us", ID: "1713845538"}},
+		{"https://music.apple.com/us/album/1989/1713845538?uo=4&at=x#top", Ref{Kind: Album, Storefront: "us", ID: "1713845538"}},
+		{"https://music.apple.com/us/song/style/1713845540?i=1", Ref{Kind: Song, Storefront: "us", ID: "1713845540"}},
+
+		// other kinds
+		{"https://music.apple.com/us/song/style/1713845540", Ref{Kind: Song, Storefront: "us", ID: "1713845540"}},
+		{"https://music.apple.com/us/playlist/todays-hits/pl.f4d106fed2bd41149aaacabb233eb5eb", Ref{Kind: Playlist, Storefront: "us", ID: "pl.f4d106fed2bd41149aaacabb233eb5eb"}},
+		{"https://music.apple.com/us/playlist/pl.u-8aAVZAVCrgb7Ej", Ref{Kind: Playlist, Storefront: "us", ID: "pl.u-8aAVZAVCrgb7Ej"}},
+		{"https://music.apple.com/us/music-video/anti-hero/1649434004", Ref{Kind: MusicVideo, Storefront: "us", ID: "1649434004"}},
+		{"https://music.apple.com/us/artist/taylor-swift/159260351", Ref{Kind: Artist, Storefront: "us", ID: "159260351"}},
+		{"https://music.apple.com/us/curator/apple-music-pop/976439548", Ref{Kind: Curator, Storefront: "us", ID: "976439548"}},
+		{"https://music.apple.com/us/apple-curator/apple-music-1/1526756058", Ref{Kind: AppleCurator, Storefront: "us", ID: "1526756058"}},
+		{"https://music.apple.com/us/label/republic-records/1543411840", Ref{Kind: RecordLabel, Storefront: "us", ID: "1543411840"}},
+		{"https://music.apple.com/gb/record-label/1543411840", Ref{Kind: RecordLabel, Storefront: "gb", ID: "1543411840"}},
+		{"https://music.apple.com/us/room/6451502539", Ref{Kind: Room, Storefront: "us", ID: "6451502539"}},
+		{"https://music.apple.com/us/multi-room/6451502530", Ref{Kind: MultiRoom, Storefront: "us", ID: "6451502530"}},
+
+		// library
+		{"https://music.apple.com/library/playlist/p.AbCd3fG", Ref{Kind: Library, ID: "p.AbCd3fG"}},
+		{"https://music.apple.com/library/albums/l.Qx9-z", Ref{Kind: Library, ID: "l.Qx9-z"}},
+		{"https://music.apple.com/us/library/songs/i.B2xYz", Ref{Kind: Library, Storefront: "us", ID: "i.B2xYz"}},
+		{"p.AbCd3fG", Ref{Kind: Library, ID: "p.AbCd3fG"}},
+		{"l.Qx9-z", Ref{Kind: Library, ID: "l.Qx9-z"}},
+		{"i.B2xYz", Ref{Kind: Library, ID: "i.B2xYz"}},
+
+		// identifiers
+		{"isrc:usum71703861", Ref{Kind: ISRC, ID: "USUM71703861"}},
+		{"ISRC: GBARL9300135 ", Ref{Kind: ISRC, ID: "GBARL9300135"}},
+		{"upc:00602445790258", Ref{Kind: UPC, ID: "00602445790258"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := Parse(tt.raw)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.raw, err)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUnsupported(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"hello",
+		"https://open.spotify.com/album/1713845538",
+		"https://music.apple.com/us/browse",
+		"https://music.apple.com/us/album/red",
+		"https://music.apple.com/us/album/1989/abc",
+		"https://music.apple.com/us/playlist/todays-hits/1713845538",
+		"https://music.apple.com/library/playlist/pl.f4d106fed2bd41149aaacabb233eb5eb",
+		"https://music.apple.com/us/podcast/some-show/id1200361736",
+		"https://music.apple.com/us/label/republic-records/abc",
+	} {
+		if got, err := Parse(raw); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", raw, got)
+		}
+	}
+}
+
+func TestParseStation(t *testing.T) {
+	for _, raw := range []string{
+		"https://music.apple.com/us/station/taylor-swift-station/ra.1028524485",
+		"https://music.apple.com/station/ra.978194965",
+	} {
+		if _, err := Parse(raw); !errors.Is(err, ErrStation) {
+			t.Errorf("Parse(%q) error = %v, want %v", raw, err, ErrStation)
+		}
+	}
+}
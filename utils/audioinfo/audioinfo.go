@@ -0,0 +1,156 @@
+package audioinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// Info describes the audio of a downloaded file as stored in its sample description.
+type Info struct {
+	Codec      string // sample entry type: alac, ec-3, ac-3 or mp4a
+	Channels   int    // full-range channels
+	LFE        int    // low-frequency effects channels
+	Bitrate    int    // kbps, 0 when the file does not say
+	SampleRate int    // Hz
+	BitDepth   int    // only set for alac
+	Atmos      bool   // ec-3 carrying Joint Object Coding
+	Objects    int    // complexity_index_type_a, only set for Atmos
+}
+
+// Read parses the sample description of the first track in path.
+// Media data is not loaded.
+func Read(path string) (*Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	parsed, err := mp4.DecodeFile(f, mp4.WithDecodeMode(mp4.DecModeLazyMdat))
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Moov == nil || parsed.Moov.Trak == nil {
+		return nil, errors.New("no track found")
+	}
+	stsd := parsed.Moov.Trak.Mdia.Minf.Stbl.Stsd
+	switch {
+	case stsd.EC3 != nil && stsd.EC3.Dec3 != nil:
+		return fromDec3(stsd.EC3.Dec3), nil
+	case stsd.AC3 != nil && stsd.AC3.Dac3 != nil:
+		return fromDac3(stsd.AC3.Dac3), nil
+	case stsd.Mp4a != nil:
+		return fromMp4a(stsd.Mp4a), nil
+	}
+	for _, child := range stsd.Children {
+		if child.Type() == "alac" {
+			return fromAlac(child)
+		}
+	}
+	return nil, errors.New("no supported audio sample entry")
+}
+
+// splitLFE separates the LFE channels from a chanmap built by mp4ff.
+func splitLFE(nrChannels int, chanmap uint16) (int, int) {
+	lfe := bits.OnesCount16(chanmap & (mp4.CustomChannelMapLocations["LFE"] | mp4.CustomChannelMapLocations["LFE2"]))
+	return nrChannels - lfe, lfe
+}
+
+func fromDec3(b *mp4.Dec3Box) *Info {
+	info := &Info{Codec: "ec-3", Bitrate: int(b.DataRate)}
+	info.Channels, info.LFE = splitLFE(b.ChannelInfo())
+	if len(b.EC3Subs) > 0 && int(b.EC3Subs[0].FSCod) < len(mp4.AC3SampleRates) {
+		info.SampleRate = int(mp4.AC3SampleRates[b.EC3Subs[0].FSCod])
+	}
+	// ETSI TS 103 420: after the substreams come 7 reserved bits,
+	// flag_ec3_extension_type_a and, if set, complexity_index_type_a.
+	if len(b.Reserved) > 0 && b.Reserved[0]&0x01 == 1 {
+		info.Atmos = true
+		if len(b.Reserved) > 1 {
+			info.Objects = int(b.Reserved[1])
+		}
+	}
+	return info
+}
+
+func fromDac3(b *mp4.Dac3Box) *Info {
+	info := &Info{Codec: "ac-3", Bitrate: b.BitrateBps() / 1000, SampleRate: b.SamplingFrequency()}
+	info.Channels, info.LFE = splitLFE(b.ChannelInfo())
+	return info
+}
+
+func fromMp4a(b *mp4.AudioSampleEntryBox) *Info {
+	info := &Info{Codec: "mp4a", Channels: int(b.ChannelCount), SampleRate: int(b.SampleRate)}
+	if b.Esds != nil && b.Esds.DecConfigDescriptor != nil {
+		info.Bitrate = int(b.Esds.DecConfigDescriptor.AvgBitrate / 1000)
+	} else if b.Btrt != nil {
+		info.Bitrate = int(b.Btrt.AvgBitrate / 1000)
+	}
+	return info
+}
+
+// fromAlac reads the ALACSpecificConfig that follows the audio sample entry
+// fields. mp4ff does not decode alac, so the raw box is parsed by offset.
+func fromAlac(box mp4.Box) (*Info, error) {
+	var buf bytes.Buffer
+	if err := box.Encode(&buf); err != nil {
+		return nil, err
+	}
+	// 8 box header + 28 audio sample entry + 12 alac full box header
+	const cfg = 48
+	data := buf.Bytes()
+	if len(data) < cfg+24 {
+		return nil, fmt.Errorf("alac sample entry too short: %d bytes", len(data))
+	}
+	return &Info{
+		Codec:      "alac",
+		BitDepth:   int(data[cfg+5]),
+		Channels:   int(data[cfg+9]),
+		Bitrate:    int(binary.BigEndian.Uint32(data[cfg+16:]) / 1000),
+		SampleRate: int(binary.BigEndian.Uint32(data[cfg+20:])),
+	}, nil
+}
+
+// Layout returns the channel layout, e.g. "2.0" or "5.1".
+func (i *Info) Layout() string {
+	return fmt.Sprintf("%d.%d", i.Channels, i.LFE)
+}
+
+// ChannelsString is Layout with " Atmos" appended for object-based streams.
+func (i *Info) ChannelsString() string {
+	if i.Atmos {
+		return i.Layout() + " Atmos"
+	}
+	return i.Layout()
+}
+
+// Format names the audio format the way Apple Music labels it.
+func (i *Info) Format() string {
+	switch i.Codec {
+	case "ec-3":
+		if i.Atmos {
+			return "Dolby Atmos"
+		}
+		return "Dolby Digital Plus"
+	case "ac-3":
+		return "Dolby Audio"
+	case "alac":
+		return "ALAC"
+	case "mp4a":
+		return "AAC"
+	}
+	return i.Codec
+}
+
+// BitrateString returns the bitrate as "768kbps", or "" when unknown.
+func (i *Info) BitrateString() string {
+	if i.Bitrate == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dkbps", i.Bitrate)
+}
@@ -0,0 +1,51 @@
+package audioinfo
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// The testdata files hold only ftyp and a moov down to the stsd, with the
+// sample entry written byte by byte from ETSI TS 102 366 (dac3, dec3),
+// ETSI TS 103 420 (the JOC extension of dec3) and the ALAC magic cookie.
+func TestRead(t *testing.T) {
+	tests := []struct {
+		file     string
+		want     Info
+		format   string
+		channels string
+		bitrate  string
+	}{
+		{"atmos.m4a", Info{Codec: "ec-3", Channels: 5, LFE: 1, Bitrate: 768, SampleRate: 48000, Atmos: true, Objects: 16}, "Dolby Atmos", "5.1 Atmos", "768kbps"},
+		{"ec3-51.m4a", Info{Codec: "ec-3", Channels: 5, LFE: 1, Bitrate: 640, SampleRate: 48000}, "Dolby Digital Plus", "5.1", "640kbps"},
+		{"ac3-51.m4a", Info{Codec: "ac-3", Channels: 5, LFE: 1, Bitrate: 640, SampleRate: 48000}, "Dolby Audio", "5.1", "640kbps"},
+		{"alac-16-44.m4a", Info{Codec: "alac", Channels: 2, Bitrate: 1050, SampleRate: 44100, BitDepth: 16}, "ALAC", "2.0", "1050kbps"},
+		{"alac-24-192.m4a", Info{Codec: "alac", Channels: 2, Bitrate: 4600, SampleRate: 192000, BitDepth: 24}, "ALAC", "2.0", "4600kbps"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			info, err := Read(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *info != tt.want {
+				t.Errorf("Read() = %+v, want %+v", *info, tt.want)
+			}
+			if got := info.Format(); got != tt.format {
+				t.Errorf("Format() = %q, want %q", got, tt.format)
+			}
+			if got := info.ChannelsString(); got != tt.channels {
+				t.Errorf("ChannelsString() = %q, want %q", got, tt.channels)
+			}
+			if got := info.BitrateString(); got != tt.bitrate {
+				t.Errorf("BitrateString() = %q, want %q", got, tt.bitrate)
+			}
+		})
+	}
+}
+
+func TestReadMissing(t *testing.T) {
+	if _, err := Read(filepath.Join("testdata", "missing.m4a")); err == nil {
+		t.Error("Read() of a missing file succeeded")
+	}
+}
@@ -0,0 +1,166 @@
+package discography
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Release is one entry of an artist's albums relationship.
+type Release struct {
+	ID            string
+	Name          string
+	ArtistName    string
+	ReleaseDate   string // YYYY-MM-DD
+	URL           string
+	ContentRating string // explicit, clean or ""
+	TrackCount    int
+	IsSingle      bool
+	IsCompilation bool
+}
+
+// Filter narrows a discography. Zero values keep everything.
+type Filter struct {
+	Types  []string // album, single, ep, compilation, live
+	After  string   // YYYY, YYYY-MM or YYYY-MM-DD, inclusive
+	Before string   // inclusive
+	Rating string   // explicit or clean: drop the other edition when both exist
+	Dedupe bool     // keep one of deluxe, expanded and standard editions
+}
+
+var (
+	liveName    = regexp.MustCompile(`(?i)(\(live\b|\[live\b|\blive (at|from|in)\b| - live$)`)
+	editionName = regexp.MustCompile(`(?i)\s*[\(\[][^\)\]]*(deluxe|expanded|edition|remaster|anniversary|bonus|version)[^\)\]]*[\)\]]`)
+	suffixName  = regexp.MustCompile(`(?i)\s+-\s+(single|ep)$`)
+)
+
+// Type classifies r as album, single, ep, compilation or live. Apple only
+// flags singles and compilations, the rest comes from the name.
+func Type(r Release) string {
+	lower := strings.ToLower(r.Name)
+	switch {
+	case r.IsCompilation:
+		return "compilation"
+	case strings.HasSuffix(lower, " - ep"):
+		return "ep"
+	case strings.HasSuffix(lower, " - single"):
+		return "single"
+	case liveName.MatchString(r.Name):
+		return "live"
+	case r.IsSingle:
+		if r.TrackCount > 3 {
+			return "ep"
+		}
+		return "single"
+	}
+	return "album"
+}
+
+// BaseName strips the " - Single"/" - EP" suffix and edition notes like
+// "(Deluxe Edition)" so editions of one release compare equal.
+func BaseName(name string) string {
+	name = suffixName.ReplaceAllString(name, "")
+	name = editionName.ReplaceAllString(name, "")
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// Apply returns the releases of list that pass f, in their original order,
+// and the ones it dropped.
+func (f Filter) Apply(list []Release) ([]Release, []Release) {
+	var kept, dropped []Release
+	for _, r := range list {
+		if f.match(r) {
+			kept = append(kept, r)
+		} else {
+			dropped = append(dropped, r)
+		}
+	}
+	if f.Rating != "" {
+		var skipped []Release
+		kept, skipped = ResolveEditions(kept, f.Rating)
+		dropped = append(dropped, skipped...)
+	}
+	if f.Dedupe {
+		kept, dropped = dedupe(kept, dropped)
+	}
+	return kept, dropped
+}
+
+func (f Filter) match(r Release) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if strings.EqualFold(t, Type(r)) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.After != "" && r.ReleaseDate < f.After {
+		return false
+	}
+	if f.Before != "" && r.ReleaseDate > padDate(f.Before) {
+		return false
+	}
+	return true
+}
+
+// padDate makes a partial date the last day it covers, so "2020" includes
+// all of 2020.
+func padDate(date string) string {
+	switch len(date) {
+	case 4:
+		return date + "-12-31"
+	case 7:
+		return date + "-31"
+	}
+	return date
+}
+
+// ResolveEditions keeps one of each group of explicit and clean editions of
+// the same release, the one rated rating. Editions are grouped by
+// normalised name, artist, track count and release date.
+func ResolveEditions(list []Release, rating string) ([]Release, []Release) {
+	preferred := make(map[string]bool)
+	for _, r := range list {
+		if r.ContentRating == rating {
+			preferred[editionKey(r)] = true
+		}
+	}
+	var kept, skipped []Release
+	for _, r := range list {
+		if r.ContentRating != rating && preferred[editionKey(r)] {
+			skipped = append(skipped, r)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept, skipped
+}
+
+func editionKey(r Release) string {
+	name := strings.Join(strings.Fields(strings.ToLower(r.Name)), " ")
+	return strings.Join([]string{name, strings.ToLower(r.ArtistName), strconv.Itoa(r.TrackCount), r.ReleaseDate}, "\x00")
+}
+
+// dedupe keeps the edition with the most tracks of each base name and type.
+func dedupe(kept, dropped []Release) ([]Release, []Release) {
+	best := make(map[string]Release)
+	for _, r := range kept {
+		key := BaseName(r.Name) + "\x00" + Type(r)
+		if current, ok := best[key]; !ok || r.TrackCount > current.TrackCount {
+			best[key] = r
+		}
+	}
+	var out []Release
+	for _, r := range kept {
+		if best[BaseName(r.Name)+"\x00"+Type(r)].ID == r.ID {
+			out = append(out, r)
+		} else {
+			dropped = append(dropped, r)
+		}
+	}
+	return out, dropped
+}
@@ -0,0 +1,109 @@
+package discography
+
+import (
+	"reflect"
+	"testing"
+)
+
+var releases = []Release{
+	{ID: "1", Name: "Red", ArtistName: "Taylor Swift", ReleaseDate: "2012-10-22", TrackCount: 16},
+	{ID: "2", Name: "Red (Deluxe Edition)", ArtistName: "Taylor Swift", ReleaseDate: "2012-10-22", TrackCount: 22},
+	{ID: "3", Name: "Red (Taylor's Version)", ArtistName: "Taylor Swift", ReleaseDate: "2021-11-12", ContentRating: "explicit", TrackCount: 30},
+	{ID: "4", Name: "Red (Taylor's Version)", ArtistName: "Taylor Swift", ReleaseDate: "2021-11-12", ContentRating: "clean", TrackCount: 30},
+	{ID: "5", Name: "Begin Again - Single", ArtistName: "Taylor Swift", ReleaseDate: "2012-09-25", TrackCount: 1, IsSingle: true},
+	{ID: "6", Name: "The More Red (Taylor's Version) - EP", ArtistName: "Taylor Swift", ReleaseDate: "2021-11-19", TrackCount: 6, IsSingle: true},
+	{ID: "7", Name: "Speak Now World Tour Live", ArtistName: "Taylor Swift", ReleaseDate: "2011-11-21", TrackCount: 17},
+	{ID: "8", Name: "Red (Live at Wembley)", ArtistName: "Taylor Swift", ReleaseDate: "2013-06-01", TrackCount: 12},
+	{ID: "9", Name: "Fearless Hits", ArtistName: "Various Artists", ReleaseDate: "2009-01-01", TrackCount: 20, IsCompilation: true},
+	{ID: "10", Name: "Bonus Tracks", ArtistName: "Taylor Swift", ReleaseDate: "2014-01-01", TrackCount: 4, IsSingle: true},
+}
+
+// ids lists the catalog IDs of list in order.
+func ids(list []Release) []string {
+	var out []string
+	for _, r := range list {
+		out = append(out, r.ID)
+	}
+	return out
+}
+
+func TestType(t *testing.T) {
+	want := []string{"album", "album", "album", "album", "single", "ep", "album", "live", "compilation", "ep"}
+	for i, r := range releases {
+		if got := Type(r); got != want[i] {
+			t.Errorf("Type(%q) = %s, want %s", r.Name, got, want[i])
+		}
+	}
+}
+
+func TestBaseName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Red", "red"},
+		{"Red (Deluxe Edition)", "red"},
+		{"Red [Deluxe Version]", "red"},
+		{"Abbey Road (Remastered 2019)", "abbey road"},
+		{"Fearless (Platinum Edition) - EP", "fearless"},
+		{"Begin Again - Single", "begin again"},
+		{"Red (Taylor's Version)", "red"},
+		{"Red (Live at Wembley)", "red (live at wembley)"},
+		{"Love - Single Version", "love - single version"},
+	}
+	for _, tt := range tests {
+		if got := BaseName(tt.name); got != tt.want {
+			t.Errorf("BaseName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFilterApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  Filter
+		kept    []string
+		dropped []string
+	}{
+		{"zero value keeps everything", Filter{}, ids(releases), nil},
+		{"albums", Filter{Types: []string{"album"}}, []string{"1", "2", "3", "4", "7"}, []string{"5", "6", "8", "9", "10"}},
+		{"types are case insensitive", Filter{Types: []string{"EP", "Single"}}, []string{"5", "6", "10"}, []string{"1", "2", "3", "4", "7", "8", "9"}},
+		{"after is inclusive", Filter{After: "2012-10-22"}, []string{"1", "2", "3", "4", "6", "8", "10"}, []string{"5", "7", "9"}},
+		{"before a year covers all of it", Filter{Before: "2012"}, []string{"1", "2", "5", "7", "9"}, []string{"3", "4", "6", "8", "10"}},
+		{"before a month covers all of it", Filter{Before: "2012-09"}, []string{"5", "7", "9"}, []string{"1", "2", "3", "4", "6", "8", "10"}},
+		{"date range", Filter{After: "2013", Before: "2014-01-01"}, []string{"8", "10"}, []string{"1", "2", "3", "4", "5", "6", "7", "9"}},
+		{"clean drops the explicit twin", Filter{Rating: "clean"}, []string{"1", "2", "4", "5", "6", "7", "8", "9", "10"}, []string{"3"}},
+		{"explicit drops the clean twin", Filter{Rating: "explicit", Types: []string{"album"}}, []string{"1", "2", "3", "7"}, []string{"5", "6", "8", "9", "10", "4"}},
+		{"dedupe keeps the most tracks of each base name", Filter{Dedupe: true, Types: []string{"album"}, Rating: "explicit"}, []string{"3", "7"}, []string{"5", "6", "8", "9", "10", "4", "1", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, dropped := tt.filter.Apply(releases)
+			if got := ids(kept); !reflect.DeepEqual(got, tt.kept) {
+				t.Errorf("kept %v, want %v", got, tt.kept)
+			}
+			if got := ids(dropped); !reflect.DeepEqual(got, tt.dropped) {
+				t.Errorf("dropped %v, want %v", got, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	list := []Release{
+		{ID: "1", Name: "1989", TrackCount: 13},
+		{ID: "2", Name: "1989 (Deluxe Edition)", TrackCount: 19},
+		{ID: "3", Name: "1989 (Deluxe Edition)", TrackCount: 16},
+		{ID: "4", Name: "1989 - Single", TrackCount: 1, IsSingle: true},
+		{ID: "5", Name: "Reputation", TrackCount: 15},
+		{ID: "6", Name: "Reputation [Bonus Version]", TrackCount: 15},
+	}
+	kept, dropped := dedupe(list, nil)
+	// a single is not an edition of the album, the first of a tie stays
+	if got, want := ids(kept), []string{"2", "4", "5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+	if got, want := ids(dropped), []string{"1", "3", "6"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dropped %v, want %v", got, want)
+	}
+}
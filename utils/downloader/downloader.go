@@ -0,0 +1,410 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+
+	"main/utils/amurl"
+	"main/utils/discography"
+	"main/utils/failure"
+	"main/utils/lyrics"
+	"main/utils/playlist"
+	"main/utils/progress"
+	"main/utils/quality"
+	"main/utils/structs"
+	"main/utils/transcode"
+)
+
+// Options configure a Downloader. They stand for config.yaml and the
+// command line flags of the same names.
+type Options struct {
+	Config       structs.ConfigSet // as read by LoadConfig
+	Token        string            // developer token, read from the web player when empty
+	Atmos        bool
+	AAC          bool
+	AtmosOnly    bool // skip albums without Atmos tracks
+	LyricsOnly   bool
+	CoverArtOnly bool
+	SkipMV       bool
+	Debug        bool     // show the qualities of each track instead of downloading
+	Editions     []string // alac, atmos and aac to save several editions in one pass
+	// Storefront is the catalog of search, isrc: and upc: lookups and of
+	// links without one, us when empty.
+	Storefront string
+	// Filter narrows the albums of artists. Its Rating is prefer-rating.
+	Filter discography.Filter
+	// Interactive lets a download ask on the terminal which albums of an
+	// artist, items of a page and results of a search to take, and with
+	// Select which tracks of each album. Without it everything is taken.
+	Interactive bool
+	Select      bool
+	AllAlbums   bool // take every album of an artist without asking
+	AllItems    bool // take everything on curator and room pages without asking
+	Song        bool // save only the ?i= song of album links
+	Upgrade     bool // replace copies saved in a lower quality, the upgrade command
+	Sync        bool // keep playlist folders in step with the playlists, the sync command
+	// OnTrack is called after each track with how it ended.
+	OnTrack func(result structs.TrackResult)
+	// Observer gets the progress of each track, nothing is reported when
+	// it is nil. progress.Terminal draws the bars of the command line.
+	Observer progress.Observer
+}
+
+// Stats counts how the tracks of a Download ended.
+type Stats struct {
+	structs.Counter
+	Quality  map[string]int             // tracks saved in each of QualityTiers
+	Editions map[string]structs.Counter // by edition, with Options.Editions
+	Upgraded int                        // old copies replaced, with Options.Upgrade
+}
+
+// SearchOptions narrow a Search.
+type SearchOptions struct {
+	Types []string // song, album, artist, playlist and music-video, all when empty
+	Limit int      // results of each type
+	First bool     // take the first result without asking
+	Exact bool     // take the first result named exactly like the term without asking
+}
+
+// Downloader saves albums, playlists, songs and music videos the way the
+// command line does, for programs that embed it. Each call keeps its state
+// in a run of its own, so calls may overlap. api-base-url is the one
+// setting shared by the whole process.
+type Downloader struct {
+	opts  Options
+	token string
+}
+
+// run is the state of one Downloader call: the options it was made with,
+// the counts and what was saved so far.
+type run struct {
+	Config         structs.ConfigSet
+	dl_atmos       bool
+	dl_aac         bool
+	dl_select      bool
+	dl_song        bool
+	artist_select  bool
+	debug_mode     bool
+	lyrics_only    bool
+	atmos_only     bool
+	skip_mv        bool
+	cover_art_only bool // New flag for downloading only cover art
+	upgrade_mode   bool
+	sync_mode      bool
+	watch_once     bool
+	search_types   []string
+	search_first   bool
+	search_exact   bool
+	search_limit   int
+	storefront_arg string
+	page_all       bool
+	artistFilter   discography.Filter
+	editions       []string
+	mirrorEncoder  transcode.Encoder
+	userStorefront string                           // storefront of the media-user-token account
+	trackPicks     []int                            // track numbers chosen in the TUI or by a serve job, nil otherwise
+	trackDone      func(result structs.TrackResult) // called after each selected track, for the TUI and serve
+
+	counter        structs.Counter
+	runErr         error                        // the worst failure of the run, for the exit code
+	okDict         map[string][]string          // catalog IDs done, by okKey
+	trackFiles     map[string]map[string]string // saved track paths by okKey and catalog ID
+	editionCount   map[string]structs.Counter
+	qualityCount   map[string]int
+	upgradeCount   int
+	lyricsCache    map[string]lyricsResult
+	albumCoverPath string
+	syncManifest   *playlist.Manifest // the playlist being synced, nil otherwise
+	syncFolder     string
+	syncStaged     map[string]string // original paths of the tracks syncPrepare set aside, by catalog ID
+}
+
+// QualityTiers are the qualities quality-preference may list, best first.
+func QualityTiers() []string {
+	return append([]string(nil), qualityTiers...)
+}
+
+// New checks opts and reads a developer token when none is given. It fails
+// with failure.ErrAuth when there is no token to use.
+func New(opts Options) (*Downloader, error) {
+	for _, name := range opts.Editions {
+		if name != "alac" && name != "atmos" && name != "aac" {
+			return nil, fmt.Errorf("unknown edition: %s, use alac, atmos or aac", name)
+		}
+	}
+	if rating := opts.Filter.Rating; rating != "" && rating != "explicit" && rating != "clean" {
+		return nil, fmt.Errorf("unknown rating: %s, use explicit or clean", rating)
+	}
+	for _, format := range strings.Split(opts.Config.PlaylistFile, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format != "" && !contains(playlist.Formats, format) {
+			return nil, fmt.Errorf("unknown format in playlist-file: %s, use m3u8, pls or xspf", format)
+		}
+	}
+	for _, tier := range strings.Split(opts.Config.QualityPreference, ">") {
+		if tier = strings.TrimSpace(tier); tier != "" && !contains(qualityTiers, tier) {
+			slog.Warn("unknown quality in quality-preference", "quality", tier)
+		}
+	}
+	token := opts.Token
+	if token == "" {
+		var err error
+		token, err = getToken()
+		if err != nil || token == "" {
+			if opts.Config.AuthorizationToken == "" || opts.Config.AuthorizationToken == "your-authorization-token" {
+				return nil, fmt.Errorf("%w: failed to get token", failure.ErrAuth)
+			}
+			token = strings.Replace(opts.Config.AuthorizationToken, "Bearer ", "", -1)
+		}
+	}
+	useAPIBase(opts.Config.APIBaseURL)
+	return &Downloader{opts: opts, token: token}, nil
+}
+
+// run makes a run from the options of d, then calls fn with it.
+func (d *Downloader) run(ctx context.Context, fn func(ctx context.Context, r *run) ([]structs.TrackResult, error)) ([]structs.TrackResult, error) {
+	r := &run{Config: d.opts.Config}
+	r.Config.PreferRating = d.opts.Filter.Rating
+	r.dl_atmos, r.dl_aac, r.atmos_only = d.opts.Atmos || d.opts.AtmosOnly, d.opts.AAC, d.opts.AtmosOnly
+	r.lyrics_only, r.cover_art_only, r.skip_mv = d.opts.LyricsOnly, d.opts.CoverArtOnly, d.opts.SkipMV
+	r.debug_mode, r.editions, r.artistFilter = d.opts.Debug, d.opts.Editions, d.opts.Filter
+	r.upgrade_mode, r.sync_mode, r.dl_song = d.opts.Upgrade, d.opts.Sync, d.opts.Song
+	r.storefront_arg = d.opts.Storefront
+	if r.storefront_arg == "" {
+		r.storefront_arg = "us"
+	}
+	// without a terminal to ask on, everything is taken
+	r.dl_select = d.opts.Interactive && d.opts.Select
+	r.artist_select = !d.opts.Interactive || d.opts.AllAlbums
+	r.page_all = !d.opts.Interactive || d.opts.AllItems
+	if r.lyrics_only {
+		r.Config.SaveLrcFile = true
+	}
+	if r.Config.MirrorEnable {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			slog.Warn("ffmpeg is not found, mirror copies disabled")
+			r.Config.MirrorEnable = false
+		} else {
+			r.mirrorEncoder = transcode.FFmpeg{}
+		}
+	}
+	r.reset()
+	r.trackDone = d.opts.OnTrack
+	if d.opts.Observer != nil {
+		ctx = progress.With(ctx, d.opts.Observer)
+	}
+	return fn(ctx, r)
+}
+
+// reset clears the counts of r, the tracks done and where they were saved.
+func (r *run) reset() {
+	r.counter, r.runErr = structs.Counter{}, nil
+	r.okDict = make(map[string][]string)
+	r.qualityCount = make(map[string]int)
+	r.editionCount = make(map[string]structs.Counter)
+	r.trackFiles = make(map[string]map[string]string)
+	r.lyricsCache = make(map[string]lyricsResult)
+	r.upgradeCount = 0
+}
+
+// stats is the Stats of the tracks of r.
+func (r *run) stats() Stats {
+	s := Stats{Counter: r.counter, Quality: make(map[string]int), Editions: make(map[string]structs.Counter), Upgraded: r.upgradeCount}
+	for tier, n := range r.qualityCount {
+		s.Quality[tier] = n
+	}
+	for name, c := range r.editionCount {
+		s.Editions[name] = c
+	}
+	return s
+}
+
+// Resolve turns the links and identifiers of urls into the album,
+// playlist, song and music video URLs Download takes: artists become their
+// albums and music videos, curator and room pages what is picked from them,
+// library items and isrc:/upc: identifiers their catalog URLs. With
+// prefer-rating, the other rating of an album in the batch is dropped.
+func (d *Downloader) Resolve(ctx context.Context, urls []string) ([]string, error) {
+	var out []string
+	_, err := d.run(ctx, func(ctx context.Context, r *run) ([]structs.TrackResult, error) {
+		if len(urls) > 0 {
+			if first, _ := amurl.Parse(urls[0]); first.Kind == amurl.Artist {
+				artist := r.artistUrls
+				if r.cover_art_only {
+					artist = r.artistCoverUrls
+				}
+				expanded, err := artist(ctx, urls[0], d.token)
+				if err != nil {
+					return nil, err
+				}
+				urls = append(expanded, urls[1:]...)
+			}
+		}
+		out = r.resolveIdentifiers(ctx, r.resolveLibrary(ctx, r.expandPages(ctx, urls, d.token), d.token), d.token)
+		if r.Config.PreferRating != "" {
+			out = r.resolveBatchEditions(ctx, out, d.token)
+		}
+		return nil, nil
+	})
+	return out, err
+}
+
+// Download saves what each of urls points to, as Resolve returns them, one
+// after another. The error is the worst failure of the tracks and albums,
+// for failure.Code.
+func (d *Downloader) Download(ctx context.Context, urls []string) (Stats, error) {
+	var s Stats
+	_, err := d.run(ctx, func(ctx context.Context, r *run) ([]structs.TrackResult, error) {
+		for albumNum, urlRaw := range urls {
+			if ctx.Err() != nil {
+				break
+			}
+			slog.Info("album", "number", albumNum+1, "of", len(urls), "url", urlRaw)
+			if _, err := r.downloadUrl(ctx, urlRaw, d.token); err != nil && ctx.Err() == nil {
+				slog.Error("album failed", "url", urlRaw, "err", err)
+				r.runErr = failure.Worst(r.runErr, err)
+			}
+		}
+		s = r.stats()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, r.runErr
+	})
+	return s, err
+}
+
+// DownloadAlbum saves an album, or a playlist for pl. IDs, of storefront:
+// the tracks numbered in tracks, every track when there are none.
+func (d *Downloader) DownloadAlbum(ctx context.Context, storefront, id string, tracks ...int) ([]structs.TrackResult, error) {
+	return d.run(ctx, func(ctx context.Context, r *run) ([]structs.TrackResult, error) {
+		if len(tracks) > 0 {
+			r.trackPicks = tracks
+		}
+		return r.rip(ctx, id, d.token, storefront, r.Config.MediaUserToken, "")
+	})
+}
+
+// DownloadTrack saves one song into the folder of its album.
+func (d *Downloader) DownloadTrack(ctx context.Context, storefront, id string) (structs.TrackResult, error) {
+	results, err := d.run(ctx, func(ctx context.Context, r *run) ([]structs.TrackResult, error) {
+		return r.downloadUrl(ctx, songUrl(storefront, id), d.token)
+	})
+	return single(id, results, err)
+}
+
+// DownloadMV saves a music video on its own.
+func (d *Downloader) DownloadMV(ctx context.Context, storefront, id string) (structs.TrackResult, error) {
+	results, err := d.run(ctx, func(ctx context.Context, r *run) ([]structs.TrackResult, error) {
+		return r.downloadUrl(ctx, fmt.Sprintf("https://music.apple.com/%s/music-video/%s", storefront, id), d.token)
+	})
+	return single(id, results, err)
+}
+
+// FetchLyrics returns the lyrics of a song in the lrc-type and lrc-format
+// of the config, without saving anything. It needs the media-user-token.
+func (d *Downloader) FetchLyrics(ctx context.Context, storefront, id string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	config := d.opts.Config
+	return lyrics.Get(ctx, storefront, id, config.LrcType, config.Language, config.LrcFormat, d.token, config.MediaUserToken)
+}
+
+// Library lists the sections of the user's library in kinds, albums,
+// playlists or songs, as URLs to Download.
+func (d *Downloader) Library(ctx context.Context, kinds []string) ([]string, error) {
+	var urls []string
+	_, err := d.run(ctx, func(ctx context.Context, r *run) ([]structs.TrackResult, error) {
+		var err error
+		urls, err = r.libraryUrls(ctx, kinds, d.token)
+		return nil, err
+	})
+	return urls, err
+}
+
+// Search looks term up in the catalog and returns the URLs of what is
+// picked from the results, artists as their albums.
+func (d *Downloader) Search(ctx context.Context, term string, opts SearchOptions) ([]string, error) {
+	var urls []string
+	_, err := d.run(ctx, func(ctx context.Context, r *run) ([]structs.TrackResult, error) {
+		r.search_types, r.search_limit = opts.Types, opts.Limit
+		r.search_first, r.search_exact = opts.First || !d.opts.Interactive, opts.Exact
+		var err error
+		urls, err = r.searchCatalog(ctx, term, d.token)
+		return nil, err
+	})
+	return urls, err
+}
+
+// QualityReport checks the qualities each song of urls is offered in,
+// without downloading. An artist URL makes one report of all its albums.
+func (d *Downloader) QualityReport(ctx context.Context, urls []string) ([]quality.Report, error) {
+	var reports []quality.Report
+	_, err := d.run(ctx, func(ctx context.Context, r *run) ([]structs.TrackResult, error) {
+		var err error
+		reports, err = r.qualityReport(ctx, r.resolveIdentifiers(ctx, r.resolveLibrary(ctx, urls, d.token), d.token), d.token)
+		return nil, err
+	})
+	return reports, err
+}
+
+// Browse opens the terminal interface on the albums of an artist and
+// saves what is picked there.
+func (d *Downloader) Browse(ctx context.Context, artistUrl string) (Stats, error) {
+	var s Stats
+	_, err := d.run(ctx, func(ctx context.Context, r *run) ([]structs.TrackResult, error) {
+		if err := r.browseArtist(ctx, artistUrl, d.token); err != nil {
+			return nil, err
+		}
+		s = r.stats()
+		return nil, r.runErr
+	})
+	return s, err
+}
+
+// Watch downloads the new releases of watch-artists every watch-interval
+// minutes until ctx is cancelled, or checks them once. A single check
+// fails with the worst failure of its tracks and albums, for failure.Code.
+func (d *Downloader) Watch(ctx context.Context, once bool) error {
+	_, err := d.run(ctx, func(ctx context.Context, r *run) ([]structs.TrackResult, error) {
+		r.watch_once = once
+		return nil, r.watchArtists(ctx, d.token)
+	})
+	return err
+}
+
+// Serve runs the download queue behind the HTTP API on serve-address until
+// ctx is cancelled. It fails when the queue file can't be read or
+// serve-address can't be listened on.
+func (d *Downloader) Serve(ctx context.Context) error {
+	_, err := d.run(ctx, func(ctx context.Context, r *run) ([]structs.TrackResult, error) {
+		return nil, r.serveApi(ctx, d.token)
+	})
+	return err
+}
+
+// single is the result of a call that saves one track.
+func single(id string, results []structs.TrackResult, err error) (structs.TrackResult, error) {
+	if err != nil {
+		return structs.TrackResult{ID: id, Status: structs.TrackError, Error: err.Error()}, err
+	}
+	if len(results) == 0 {
+		return structs.TrackResult{ID: id}, fmt.Errorf("nothing downloaded for %s", id)
+	}
+	result := results[0]
+	if result.Status != structs.TrackSuccess {
+		switch {
+		case result.Err != nil:
+			return result, result.Err
+		case result.Error != "":
+			return result, errors.New(result.Error)
+		}
+		return result, fmt.Errorf("track %s: %s", id, result.Status)
+	}
+	return result, nil
+}
@@ -0,0 +1,3883 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"main/utils/amurl"
+	"main/utils/audioinfo"
+	"main/utils/discography"
+	"main/utils/failure"
+	"main/utils/library"
+	"main/utils/logs"
+	"main/utils/lyrics"
+	"main/utils/pages"
+	"main/utils/playlist"
+	"main/utils/progress"
+	"main/utils/quality"
+	"main/utils/runv2"
+	"main/utils/runv3"
+	"main/utils/search"
+	"main/utils/selector"
+	"main/utils/server"
+	"main/utils/structs"
+	"main/utils/transcode"
+	"main/utils/tui"
+	"main/utils/watch"
+
+	"github.com/grafov/m3u8"
+	"github.com/olekukonko/tablewriter"
+	"github.com/zhaarey/go-mp4tag"
+	"gopkg.in/yaml.v2"
+)
+
+const maxNameLength = 100
+
+// nameWildcard marks the part of a file name that findTrackFile treats as any text.
+const nameWildcard = "\x00"
+
+// getStorefrontFromURL extracts the storefront from an Apple Music URL.
+func getStorefrontFromURL(urlStr string) (string, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(u.Path, "/")
+	if len(parts) >= 2 {
+		return parts[1], nil // parts[0] is "", parts[1] is storefront (e.g., "us")
+	}
+	return "", errors.New("invalid URL format")
+}
+
+var (
+	forbiddenNames = regexp.MustCompile(`[/\\<>:"|?*]`)
+	qualityTiers   = []string{"atmos", "dolby-audio", "alac-hires", "alac", "aac", "aac-binaural", "aac-downmix", "aac-lc"}
+	aacLcVariant   = quality.Variant{Codec: "mp4a.40.2", Channels: 2, Bitrate: 256} // aac-lc is not in the master playlist
+)
+
+// useAPIBase sends API requests to base, the Apple Music API when it is "".
+func useAPIBase(base string) {
+	if base == "" {
+		base = amurl.DefaultAPIBase
+	}
+	amurl.APIBase = base
+}
+
+// LoadConfig reads a config.yaml.
+func LoadConfig(path string) (structs.ConfigSet, error) {
+	var config structs.ConfigSet
+	// 读取config.yaml文件内容
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	// 将yaml解析到config变量中
+	err = yaml.Unmarshal(data, &config)
+	return config, err
+}
+
+func (r *run) LimitString(s string) string {
+	if len([]rune(s)) > r.Config.LimitMax {
+		return string([]rune(s)[:r.Config.LimitMax])
+	}
+	return s
+}
+
+func isInArray(arr []int, target int) bool {
+	for _, num := range arr {
+		if num == target {
+			return true
+		}
+	}
+	return false
+}
+
+func fileExists(path string) (bool, error) {
+	f, err := os.Stat(path)
+	if err == nil {
+		return !f.IsDir(), nil
+	} else if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// checkRef returns the storefront and ID of raw if it links to kind. Links
+// without a storefront use --storefront.
+func (r *run) checkRef(raw string, kind amurl.Kind) (string, string) {
+	ref, err := amurl.Parse(raw)
+	if err != nil || ref.Kind != kind {
+		return "", ""
+	}
+	if ref.Storefront == "" {
+		return r.storefront_arg, ref.ID
+	}
+	return ref.Storefront, ref.ID
+}
+
+// isPlaylist reports whether id is a catalog playlist (pl.) or a playlist
+// of the user's library (p.).
+func isPlaylist(id string) bool {
+	return strings.Contains(id, "pl.") || strings.HasPrefix(id, "p.")
+}
+
+func (r *run) getUrlSong(ctx context.Context, songUrl string, token string) (string, error) {
+	storefront, songId := r.checkRef(songUrl, amurl.Song)
+	manifest, err := r.getInfoFromAdam(ctx, songId, token, storefront)
+	if err != nil {
+		warn(ctx, "failed to get manifest", err)
+		r.counter.Add(failure.Status(err))
+		return "", err
+	}
+	albumId := manifest.Relationships.Albums.Data[0].ID
+	songAlbumUrl := fmt.Sprintf("https://music.apple.com/%s/album/1/%s?i=%s", storefront, albumId, songId)
+	return songAlbumUrl, nil
+}
+func (r *run) getUrlArtistName(ctx context.Context, artistUrl string, token string) (string, string, error) {
+	storefront, artistId := r.checkRef(artistUrl, amurl.Artist)
+	req, err := http.NewRequestWithContext(ctx, "GET", amurl.API(fmt.Sprintf("/v1/catalog/%s/artists/%s", storefront, artistId)), nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+	req.Header.Set("Origin", "https://music.apple.com")
+	query := url.Values{}
+	query.Set("l", r.Config.Language)
+	do, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer do.Body.Close()
+	if do.StatusCode != http.StatusOK {
+		return "", "", failure.CatalogResponse(do)
+	}
+	obj := new(structs.AutoGeneratedArtist)
+	err = json.NewDecoder(do.Body).Decode(&obj)
+	if err != nil {
+		return "", "", err
+	}
+	return obj.Data[0].Attributes.Name, obj.Data[0].ID, nil
+}
+
+// getArtistReleases pages through the albums or music-videos relationship
+// of an artist.
+func (r *run) getArtistReleases(ctx context.Context, storefront string, artistId string, relationship string, token string) ([]discography.Release, error) {
+	Num := 0
+	var releases []discography.Release
+	for {
+		req, err := http.NewRequestWithContext(ctx, "GET", amurl.API(fmt.Sprintf("/v1/catalog/%s/artists/%s/%s?limit=100&offset=%d&l=%s", storefront, artistId, relationship, Num, r.Config.Language)), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+		req.Header.Set("Origin", "https://music.apple.com")
+		do, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if do.StatusCode != http.StatusOK {
+			do.Body.Close()
+			return nil, failure.CatalogResponse(do)
+		}
+		obj := new(structs.AutoGeneratedArtist)
+		err = json.NewDecoder(do.Body).Decode(&obj)
+		do.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, album := range obj.Data {
+			releases = append(releases, discography.Release{
+				ID:            album.ID,
+				Name:          album.Attributes.Name,
+				ArtistName:    album.Attributes.ArtistName,
+				ReleaseDate:   album.Attributes.ReleaseDate,
+				URL:           album.Attributes.URL,
+				ContentRating: album.Attributes.ContentRating,
+				TrackCount:    album.Attributes.TrackCount,
+				IsSingle:      album.Attributes.IsSingle,
+				IsCompilation: album.Attributes.IsCompilation,
+			})
+		}
+		Num = Num + 100
+		if len(obj.Next) == 0 {
+			break
+		}
+	}
+	return releases, nil
+}
+
+func (r *run) checkArtist(ctx context.Context, artistUrl string, token string, relationship string) ([]string, error) {
+	storefront, artistId := r.checkRef(artistUrl, amurl.Artist)
+	//id := 1
+	var args []string
+	var urls []string
+	var options [][]string
+	releases, err := r.getArtistReleases(ctx, storefront, artistId, relationship, token)
+	if err != nil {
+		return nil, err
+	}
+	// release type, rating and edition filters only make sense for albums
+	filter := r.artistFilter
+	if relationship != "albums" {
+		filter = discography.Filter{After: r.artistFilter.After, Before: r.artistFilter.Before}
+	}
+	kept, dropped := filter.Apply(releases)
+	if len(dropped) > 0 {
+		slog.Info("filtered out releases", "kind", relationship, "dropped", len(dropped), "of", len(releases))
+	}
+	for _, release := range kept {
+		options = append(options, []string{release.Name, release.ReleaseDate, release.ID, release.URL, release.ContentRating})
+	}
+	sort.Slice(options, func(i, j int) bool {
+		// 将日期字符串解析为 time.Time 类型进行比较
+		dateI, _ := time.Parse("2006-01-02", options[i][1])
+		dateJ, _ := time.Parse("2006-01-02", options[j][1])
+		return dateI.Before(dateJ) // 返回 true 表示 i 在 j 前面
+	})
+
+	table := tablewriter.NewWriter(os.Stdout)
+	if relationship == "albums" {
+		table.SetHeader([]string{"", "Album Name", "Date", "Album ID"})
+	} else if relationship == "music-videos" {
+		table.SetHeader([]string{"", "MV Name", "Date", "MV ID"})
+	}
+	//table.SetFooter([]string{"", "", "Total", "$146.93"})
+	//table.SetAutoMergeCells(true)
+	//table.SetAutoMergeCellsByColumnIndex([]int{1,2,3})
+	table.SetRowLine(false)
+	//table.AppendBulk(options)
+	table.SetHeaderColor(tablewriter.Colors{},
+		tablewriter.Colors{tablewriter.FgRedColor, tablewriter.Bold},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgBlackColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgBlackColor})
+
+	table.SetColumnColor(tablewriter.Colors{tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgRedColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgBlackColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgBlackColor})
+	var choices []selector.Option
+	for i, v := range options {
+		urls = append(urls, v[3])
+		choices = append(choices, selector.Option{Label: fmt.Sprintf("%s (%s)", v[0], v[1]), Rating: v[4]})
+		options[i] = append([]string{fmt.Sprint(i + 1)}, v[:3]...)
+		table.Append(options[i])
+	}
+	table.Render()
+	if r.artist_select {
+		fmt.Println("You have selected all options:")
+		return urls, nil
+	}
+	for _, i := range selector.Prompt(bufio.NewReader(os.Stdin), os.Stdout, relationship+" options", choices) {
+		args = append(args, urls[i])
+	}
+	return args, nil
+}
+
+// pickRows shows rows (type, name, artist, date) numbered in a table and
+// returns the indexes the user chooses.
+func pickRows(what string, rows [][]string) []int {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"", "Type", "Name", "Artist", "Date"})
+	table.SetRowLine(false)
+	table.SetHeaderColor(tablewriter.Colors{},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgBlackColor},
+		tablewriter.Colors{tablewriter.FgRedColor, tablewriter.Bold},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgBlackColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgBlackColor})
+	table.SetColumnColor(tablewriter.Colors{tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgBlackColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgRedColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgBlackColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgBlackColor})
+	for i, row := range rows {
+		table.Append(append([]string{fmt.Sprint(i + 1)}, row...))
+	}
+	table.Render()
+	var choices []selector.Option
+	for _, row := range rows {
+		choices = append(choices, selector.Option{Label: fmt.Sprintf("%s: %s - %s", row[0], row[2], row[1])})
+	}
+	return selector.Prompt(bufio.NewReader(os.Stdin), os.Stdout, what, choices)
+}
+
+// songUrl is the /song/ link of a song. A song's url attribute is its
+// album's, which would download the whole album.
+func songUrl(storefront, id string) string {
+	return fmt.Sprintf("https://music.apple.com/%s/song/%s", storefront, id)
+}
+
+// expandPages replaces curator, record label and editorial room links in
+// urls with what the user picks from them, or everything with --all.
+func (r *run) expandPages(ctx context.Context, urls []string, token string) []string {
+	var out []string
+	for _, urlRaw := range urls {
+		ref, err := amurl.Parse(urlRaw)
+		if err != nil {
+			out = append(out, urlRaw)
+			continue
+		}
+		storefront := ref.Storefront
+		if storefront == "" {
+			storefront = r.storefront_arg
+		}
+		var items []pages.Item
+		switch ref.Kind {
+		case amurl.Curator, amurl.AppleCurator:
+			items, err = pages.Curator(ctx, storefront, ref.ID, ref.Kind == amurl.AppleCurator, r.Config.Language, token)
+		case amurl.RecordLabel:
+			items, err = pages.RecordLabel(ctx, storefront, ref.ID, r.Config.Language, token)
+		case amurl.Room:
+			items, err = pages.Room(ctx, storefront, ref.ID, r.Config.Language, token)
+		case amurl.MultiRoom:
+			items, err = pages.MultiRoom(ctx, storefront, ref.ID, r.Config.Language, token)
+		default:
+			out = append(out, urlRaw)
+			continue
+		}
+		if err != nil {
+			slog.Warn("failed to list page", "kind", ref.Kind, "id", ref.ID, "err", err)
+			continue
+		}
+		slog.Info("found page items", "kind", ref.Kind, "id", ref.ID, "items", len(items))
+		if len(items) == 0 {
+			continue
+		}
+		var picked []int
+		if r.page_all {
+			for i := range items {
+				picked = append(picked, i)
+			}
+		} else {
+			var rows [][]string
+			for _, item := range items {
+				rows = append(rows, []string{strings.TrimSuffix(item.Type, "s"), item.Name, item.ArtistName, item.ReleaseDate})
+			}
+			picked = pickRows(string(ref.Kind)+" items", rows)
+		}
+		for _, i := range picked {
+			if items[i].Type == "songs" {
+				out = append(out, songUrl(storefront, items[i].ID))
+			} else {
+				out = append(out, items[i].URL)
+			}
+		}
+	}
+	return out
+}
+
+// searchCatalog runs the search command: it shows what term finds and
+// returns the URLs of the chosen items, with artists expanded to albums.
+func (r *run) searchCatalog(ctx context.Context, term string, token string) ([]string, error) {
+	types := search.Types
+	if len(r.search_types) > 0 {
+		var err error
+		if types, err = search.ParseTypes(r.search_types); err != nil {
+			return nil, err
+		}
+	}
+	results, err := search.Search(ctx, r.storefront_arg, term, types, r.search_limit, r.Config.Language, token)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		slog.Info("no search results", "term", term)
+		return nil, nil
+	}
+	var picked []search.Result
+	if r.search_first || r.search_exact {
+		result, ok := search.Pick(results, term, r.search_exact)
+		if !ok {
+			return nil, fmt.Errorf("no result named %q", term)
+		}
+		slog.Info("found", "type", strings.TrimSuffix(result.Type, "s"), "artist", result.ArtistName, "name", result.Name)
+		picked = append(picked, result)
+	} else {
+		var rows [][]string
+		for _, res := range results {
+			rows = append(rows, []string{strings.TrimSuffix(res.Type, "s"), res.Name, res.ArtistName, res.ReleaseDate})
+		}
+		for _, i := range pickRows("search results", rows) {
+			picked = append(picked, results[i])
+		}
+	}
+	var urls []string
+	for _, res := range picked {
+		switch res.Type {
+		case "songs":
+			urls = append(urls, songUrl(r.storefront_arg, res.ID))
+		case "artists":
+			albumArgs, err := r.checkArtist(ctx, res.URL, token, "albums")
+			if err != nil {
+				slog.Warn("failed to get artist albums", "url", res.URL, "err", err)
+				continue
+			}
+			urls = append(urls, albumArgs...)
+		default:
+			urls = append(urls, res.URL)
+		}
+	}
+	return urls, nil
+}
+
+// resolveBatchEditions drops album URLs whose explicit or clean counterpart
+// is also in urls, keeping the edition rated prefer-rating.
+func (r *run) resolveBatchEditions(ctx context.Context, urls []string, token string) []string {
+	idsByStorefront := make(map[string][]string)
+	albumUrls := make(map[string]string)
+	for _, urlRaw := range urls {
+		ref, err := amurl.Parse(urlRaw)
+		if err != nil || ref.Kind != amurl.Album || ref.TrackID != "" {
+			continue
+		}
+		storefront, albumId := r.checkRef(urlRaw, amurl.Album)
+		idsByStorefront[storefront] = append(idsByStorefront[storefront], albumId)
+		albumUrls[albumId] = urlRaw
+	}
+	var releases []discography.Release
+	for storefront, ids := range idsByStorefront {
+		albums, err := r.getAlbumReleases(ctx, storefront, ids, token)
+		if err != nil {
+			slog.Warn("failed to check album editions", "storefront", storefront, "err", err)
+			return urls
+		}
+		releases = append(releases, albums...)
+	}
+	_, skipped := discography.ResolveEditions(releases, r.Config.PreferRating)
+	if len(skipped) == 0 {
+		return urls
+	}
+	skip := make(map[string]bool)
+	for _, release := range skipped {
+		rating := release.ContentRating
+		if rating == "" {
+			rating = "unrated"
+		}
+		slog.Info("skipping edition, the other is in the batch", "rating", rating, "name", release.Name, "id", release.ID, "prefer", r.Config.PreferRating)
+		skip[albumUrls[release.ID]] = true
+	}
+	var kept []string
+	for _, urlRaw := range urls {
+		if !skip[urlRaw] {
+			kept = append(kept, urlRaw)
+		}
+	}
+	return kept
+}
+
+// getAlbumReleases looks up the attributes of albums in one storefront,
+// 100 per request.
+func (r *run) getAlbumReleases(ctx context.Context, storefront string, ids []string, token string) ([]discography.Release, error) {
+	var releases []discography.Release
+	for start := 0; start < len(ids); start += 100 {
+		end := start + 100
+		if end > len(ids) {
+			end = len(ids)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", amurl.API(fmt.Sprintf("/v1/catalog/%s/albums?ids=%s&l=%s", storefront, strings.Join(ids[start:end], ","), r.Config.Language)), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+		req.Header.Set("Origin", "https://music.apple.com")
+		do, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if do.StatusCode != http.StatusOK {
+			do.Body.Close()
+			return nil, failure.CatalogResponse(do)
+		}
+		obj := new(structs.AutoGeneratedArtist)
+		err = json.NewDecoder(do.Body).Decode(&obj)
+		do.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, album := range obj.Data {
+			releases = append(releases, discography.Release{
+				ID:            album.ID,
+				Name:          album.Attributes.Name,
+				ArtistName:    album.Attributes.ArtistName,
+				ReleaseDate:   album.Attributes.ReleaseDate,
+				URL:           album.Attributes.URL,
+				ContentRating: album.Attributes.ContentRating,
+				TrackCount:    album.Attributes.TrackCount,
+				IsSingle:      album.Attributes.IsSingle,
+				IsCompilation: album.Attributes.IsCompilation,
+			})
+		}
+	}
+	return releases, nil
+}
+
+func (r *run) getMeta(ctx context.Context, albumId string, token string, storefront string) (*structs.AutoGenerated, error) {
+	if strings.HasPrefix(albumId, "p.") {
+		return r.getLibraryPlaylistMeta(ctx, albumId, token, storefront)
+	}
+	var mtype string
+	var next string
+	if isPlaylist(albumId) {
+		mtype = "playlists"
+	} else {
+		mtype = "albums"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", amurl.API(fmt.Sprintf("/v1/catalog/%s/%s/%s", storefront, mtype, albumId)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+	req.Header.Set("Origin", "https://music.apple.com")
+	query := url.Values{}
+	query.Set("omit[resource]", "autos")
+	query.Set("include", "tracks,artists,record-labels")
+	query.Set("include[songs]", "artists,albums")
+	query.Set("fields[artists]", "name,artwork")
+	query.Set("fields[albums:albums]", "artistName,artwork,name,releaseDate,url")
+	query.Set("fields[record-labels]", "name")
+	query.Set("extend", "editorialVideo")
+	query.Set("l", r.Config.Language)
+	req.URL.RawQuery = query.Encode()
+	do, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer do.Body.Close()
+	if do.StatusCode != http.StatusOK {
+		return nil, failure.CatalogResponse(do)
+	}
+	obj := new(structs.AutoGenerated)
+	err = json.NewDecoder(do.Body).Decode(&obj)
+	if err != nil {
+		return nil, err
+	}
+	if isPlaylist(albumId) {
+		obj.Data[0].Attributes.ArtistName = "Apple Music"
+		if len(obj.Data[0].Relationships.Tracks.Next) > 0 {
+			next = obj.Data[0].Relationships.Tracks.Next
+			for {
+				req, err := http.NewRequestWithContext(ctx, "GET", amurl.API(fmt.Sprintf("%s&l=%s&include=albums", next, r.Config.Language)), nil)
+				if err != nil {
+					return nil, err
+				}
+				req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+				req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+				req.Header.Set("Origin", "https://music.apple.com")
+				do, err := http.DefaultClient.Do(req)
+				if err != nil {
+					return nil, err
+				}
+				defer do.Body.Close()
+				if do.StatusCode != http.StatusOK {
+					return nil, failure.CatalogResponse(do)
+				}
+				obj2 := new(structs.AutoGeneratedTrack)
+				err = json.NewDecoder(do.Body).Decode(&obj2)
+				if err != nil {
+					return nil, err
+				}
+				for _, value := range obj2.Data {
+					obj.Data[0].Relationships.Tracks.Data = append(obj.Data[0].Relationships.Tracks.Data, value)
+				}
+				next = obj2.Next
+				if len(next) == 0 {
+					break
+				}
+			}
+		}
+	}
+	return obj, nil
+}
+
+// getCatalogIdsByFilter looks up songs or albums by filter, e.g. isrc or
+// upc. Several songs can share an ISRC, one per album they are on.
+func (r *run) getCatalogIdsByFilter(ctx context.Context, storefront, kind, filter, value, token string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", amurl.API(fmt.Sprintf("/v1/catalog/%s/%s?filter[%s]=%s&l=%s", storefront, kind, filter, url.QueryEscape(value), r.Config.Language)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+	req.Header.Set("Origin", "https://music.apple.com")
+	do, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer do.Body.Close()
+	if do.StatusCode != http.StatusOK {
+		return nil, failure.CatalogResponse(do)
+	}
+	var obj struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(do.Body).Decode(&obj)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, item := range obj.Data {
+		ids = append(ids, item.ID)
+	}
+	return ids, nil
+}
+
+// resolveIdentifiers replaces isrc: and upc: identifiers in urls with the
+// song and album URLs they name in the --storefront catalog.
+func (r *run) resolveIdentifiers(ctx context.Context, urls []string, token string) []string {
+	var out []string
+	for _, urlRaw := range urls {
+		ref, err := amurl.Parse(urlRaw)
+		if err != nil || (ref.Kind != amurl.ISRC && ref.Kind != amurl.UPC) {
+			out = append(out, urlRaw)
+			continue
+		}
+		kind, linkType := "songs", "song"
+		if ref.Kind == amurl.UPC {
+			kind, linkType = "albums", "album"
+		}
+		ids, err := r.getCatalogIdsByFilter(ctx, r.storefront_arg, kind, string(ref.Kind), ref.ID, token)
+		if err != nil {
+			slog.Warn("failed to look up", "id", urlRaw, "err", err)
+			continue
+		}
+		if len(ids) == 0 {
+			slog.Warn("not found in the catalog", "storefront", r.storefront_arg, "id", urlRaw)
+			continue
+		}
+		id := ids[0]
+		if len(ids) > 1 && ref.Kind == amurl.ISRC {
+			songs, err := r.getCatalogSongs(ctx, r.storefront_arg, ids, token)
+			if err != nil {
+				slog.Warn("failed to look up", "id", urlRaw, "err", err)
+				continue
+			}
+			if len(songs) > 0 {
+				id = pickIsrcSong(songs)
+			}
+			slog.Info("identifier matches several songs", "id", urlRaw, "using", id, "of", strings.Join(ids, ", "))
+		} else if len(ids) > 1 {
+			slog.Info("identifier matches several, using the first", "id", urlRaw, "kind", kind, "matches", len(ids))
+		}
+		out = append(out, fmt.Sprintf("https://music.apple.com/%s/%s/%s", r.storefront_arg, linkType, id))
+	}
+	return out
+}
+
+// pickIsrcSong chooses one of the songs sharing an ISRC: the one on an album
+// that is not a compilation, then on the earliest album, then the lowest
+// catalog ID, so every run picks the same.
+func pickIsrcSong(songs []structs.TrackData) string {
+	rank := func(song structs.TrackData) (bool, string) {
+		if albums := song.Relationships.Albums.Data; len(albums) > 0 {
+			return albums[0].Attributes.IsCompilation, albums[0].Attributes.ReleaseDate
+		}
+		return false, song.Attributes.ReleaseDate
+	}
+	sorted := append([]structs.TrackData(nil), songs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ci, di := rank(sorted[i])
+		cj, dj := rank(sorted[j])
+		if ci != cj {
+			return !ci
+		}
+		if di != dj {
+			return di < dj
+		}
+		if len(sorted[i].ID) != len(sorted[j].ID) {
+			return len(sorted[i].ID) < len(sorted[j].ID)
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted[0].ID
+}
+
+// refAlbum is the storefront and ID rip takes for an album or playlist link.
+func (r *run) refAlbum(ref amurl.Ref) (string, string, error) {
+	storefront := ref.Storefront
+	if storefront == "" {
+		storefront = r.storefront_arg
+	}
+	switch {
+	case ref.Kind == amurl.Album || ref.Kind == amurl.Playlist:
+		return storefront, ref.ID, nil
+	case ref.Kind == amurl.Library && library.Kind(ref.ID) == "playlists":
+		return r.userStorefront, ref.ID, nil
+	}
+	return "", "", fmt.Errorf("not an album or playlist: %s", ref.Kind)
+}
+
+// getLibraryPlaylistMeta reads a playlist of the user's library that is not
+// in the catalog, with the catalog versions of its songs as tracks.
+func (r *run) getLibraryPlaylistMeta(ctx context.Context, playlistId string, token string, storefront string) (*structs.AutoGenerated, error) {
+	if len(r.Config.MediaUserToken) <= 50 {
+		return nil, errors.New("media-user-token is not set")
+	}
+	obj := new(structs.AutoGenerated)
+	err := library.Get(ctx, fmt.Sprintf("/v1/me/library/playlists/%s?l=%s", playlistId, r.Config.Language), token, r.Config.MediaUserToken, obj)
+	if err != nil {
+		return nil, err
+	}
+	if len(obj.Data) == 0 {
+		return nil, errors.New("playlist not found")
+	}
+	ids, missing, err := library.PlaylistTracks(ctx, playlistId, token, r.Config.MediaUserToken)
+	if err != nil {
+		return nil, err
+	}
+	if missing > 0 {
+		slog.Warn("tracks not in the catalog, skipped", "missing", missing)
+	}
+	tracks, err := r.getCatalogSongs(ctx, storefront, ids, token)
+	if err != nil {
+		return nil, err
+	}
+	if len(tracks) == 0 {
+		return nil, errors.New("no tracks of this playlist are in the catalog")
+	}
+	obj.Data[0].Attributes.ArtistName = "Apple Music"
+	if obj.Data[0].Attributes.Artwork.URL == "" {
+		obj.Data[0].Attributes.Artwork.URL = tracks[0].Attributes.Artwork.URL
+	}
+	obj.Data[0].Relationships.Tracks.Data = tracks
+	return obj, nil
+}
+
+// getCatalogSongs fetches songs by catalog ID, in the order of ids. Songs
+// not available in storefront are left out.
+func (r *run) getCatalogSongs(ctx context.Context, storefront string, ids []string, token string) ([]structs.TrackData, error) {
+	songs := make(map[string]structs.TrackData)
+	for start := 0; start < len(ids); start += 300 {
+		end := start + 300
+		if end > len(ids) {
+			end = len(ids)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", amurl.API(fmt.Sprintf("/v1/catalog/%s/songs?ids=%s&include=albums,artists&l=%s", storefront, strings.Join(ids[start:end], ","), r.Config.Language)), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+		req.Header.Set("Origin", "https://music.apple.com")
+		do, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if do.StatusCode != http.StatusOK {
+			do.Body.Close()
+			return nil, failure.CatalogResponse(do)
+		}
+		var obj struct {
+			Data []structs.TrackData `json:"data"`
+		}
+		err = json.NewDecoder(do.Body).Decode(&obj)
+		do.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, song := range obj.Data {
+			songs[song.ID] = song
+		}
+	}
+	var tracks []structs.TrackData
+	for _, id := range ids {
+		if song, ok := songs[id]; ok {
+			tracks = append(tracks, song)
+		}
+	}
+	return tracks, nil
+}
+
+// librarySetup checks the media-user-token and looks up the storefront of
+// its account, once.
+func (r *run) librarySetup(ctx context.Context, token string) error {
+	if r.userStorefront != "" {
+		return nil
+	}
+	if len(r.Config.MediaUserToken) <= 50 {
+		return errors.New("media-user-token is not set")
+	}
+	storefront, err := library.Storefront(ctx, token, r.Config.MediaUserToken)
+	if err != nil {
+		return err
+	}
+	r.userStorefront = storefront
+	return nil
+}
+
+// libraryUrl is the URL an item of the library is downloaded from: its
+// catalog URL, or a library URL for playlists that are not published.
+func (r *run) libraryUrl(item library.Item) string {
+	switch {
+	case item.Kind == "playlists" && item.CatalogID == "":
+		return "https://music.apple.com/library/playlist/" + item.ID
+	case item.CatalogID == "":
+		return ""
+	case item.Kind == "albums":
+		return fmt.Sprintf("https://music.apple.com/%s/album/%s", r.userStorefront, item.CatalogID)
+	case item.Kind == "playlists":
+		return fmt.Sprintf("https://music.apple.com/%s/playlist/%s", r.userStorefront, item.CatalogID)
+	}
+	return fmt.Sprintf("https://music.apple.com/%s/song/%s", r.userStorefront, item.CatalogID)
+}
+
+// libraryUrls lists the given sections of the user's library as URLs.
+func (r *run) libraryUrls(ctx context.Context, kinds []string, token string) ([]string, error) {
+	if err := r.librarySetup(ctx, token); err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, kind := range kinds {
+		if !contains(library.Kinds, kind) {
+			return nil, fmt.Errorf("unknown library section: %s, use albums, playlists or songs", kind)
+		}
+		items, err := library.List(ctx, kind, token, r.Config.MediaUserToken)
+		if err != nil {
+			return nil, err
+		}
+		skipped := 0
+		for _, item := range items {
+			if u := r.libraryUrl(item); u != "" {
+				urls = append(urls, u)
+			} else {
+				skipped++
+			}
+		}
+		slog.Info("found library items", "kind", kind, "items", len(items))
+		if skipped > 0 {
+			slog.Warn("library items not in the catalog, skipped", "kind", kind, "skipped", skipped)
+		}
+	}
+	return urls, nil
+}
+
+// resolveLibrary replaces library URLs and IDs (l., p. and i.) in urls with
+// the URLs they are downloaded from.
+func (r *run) resolveLibrary(ctx context.Context, urls []string, token string) []string {
+	var out []string
+	for _, urlRaw := range urls {
+		ref, err := amurl.Parse(urlRaw)
+		// library playlists already looked up stay library playlists
+		if err != nil || ref.Kind != amurl.Library || (library.Kind(ref.ID) == "playlists" && r.userStorefront != "") {
+			out = append(out, urlRaw)
+			continue
+		}
+		id := ref.ID
+		if err := r.librarySetup(ctx, token); err != nil {
+			slog.Warn("failed to read library item", "id", id, "err", err)
+			continue
+		}
+		item, err := library.Resolve(ctx, id, token, r.Config.MediaUserToken)
+		if err != nil {
+			slog.Warn("failed to read library item", "id", id, "err", err)
+			continue
+		}
+		if u := r.libraryUrl(item); u != "" {
+			out = append(out, u)
+		} else {
+			slog.Warn("not in the catalog, skipped", "name", item.Name)
+		}
+	}
+	return out
+}
+
+func (r *run) writeCover(ctx context.Context, sanAlbumFolder, name string, url string) (string, error) {
+	// Validate inputs
+	if sanAlbumFolder == "" {
+		return "", errors.New("Error: Empty album folder path provided")
+	}
+	if name == "" {
+		return "", errors.New("Error: Empty cover name provided")
+	}
+	if url == "" {
+		return "", errors.New("Error: Empty cover URL provided")
+	}
+
+	// Format URL and determine output path
+	covPath := filepath.Join(sanAlbumFolder, name+"."+r.Config.CoverFormat)
+	originalUrl := url
+
+	if r.Config.CoverFormat == "original" {
+		// Extract extension from URL
+		urlParts := strings.Split(url, "/")
+		if len(urlParts) < 3 {
+			return "", fmt.Errorf("Invalid URL format: %s", url)
+		}
+
+		extPart := urlParts[len(urlParts)-2]
+		extIndex := strings.LastIndex(extPart, ".")
+		if extIndex < 0 {
+			return "", fmt.Errorf("Could not determine extension from URL: %s", url)
+		}
+
+		ext := extPart[extIndex+1:]
+		if ext == "" {
+			ext = "jpg" // Default to jpg if extraction fails
+			slog.Warn("could not extract cover extension, defaulting to jpg", "url", url)
+		}
+		covPath = filepath.Join(sanAlbumFolder, name+"."+ext)
+	}
+
+	// Check if cover already exists
+	exists, err := fileExists(covPath)
+	if err != nil {
+		return "", fmt.Errorf("Failed to check if cover exists at %s: %w", covPath, err)
+	}
+
+	// Remove existing file if it exists
+	if exists {
+		err = os.Remove(covPath)
+		if err != nil {
+			return "", fmt.Errorf("Failed to remove existing cover at %s: %w", covPath, err)
+		}
+	}
+
+	// Process URL based on format requirements
+	if r.Config.CoverFormat == "png" {
+		re := regexp.MustCompile(`\{w\}x\{h\}`)
+		if !re.MatchString(url) {
+			return "", fmt.Errorf("URL does not contain {w}x{h} placeholder: %s", url)
+		}
+
+		parts := re.Split(url, 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("Failed to split URL on {w}x{h}: %s", url)
+		}
+
+		url = parts[0] + "{w}x{h}" + strings.Replace(parts[1], ".jpg", ".png", 1)
+	}
+
+	// Replace dimensions in URL
+	url = strings.Replace(url, "{w}x{h}", r.Config.CoverSize, 1)
+
+	// Special handling for original format
+	if r.Config.CoverFormat == "original" {
+		url = strings.Replace(url, "is1-ssl.mzstatic.com/image/thumb", "a5.mzstatic.com/us/r1000/0", 1)
+		lastSlashIndex := strings.LastIndex(url, "/")
+		if lastSlashIndex < 0 {
+			return "", fmt.Errorf("Invalid URL format for original cover: %s", url)
+		}
+		url = url[:lastSlashIndex]
+	}
+
+	slog.Debug("downloading cover", "name", name, "path", covPath, "url", url)
+
+	// Create HTTP request
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create HTTP request for URL %s: %w", url, err)
+	}
+
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+
+	// Execute request
+	do, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// Try with original URL as fallback
+		if url != originalUrl {
+			slog.Debug("cover failed, trying original URL", "url", originalUrl, "err", err)
+			req, err = http.NewRequestWithContext(ctx, "GET", originalUrl, nil)
+			if err != nil {
+				return "", fmt.Errorf("Failed to create HTTP request for fallback URL: %w", err)
+			}
+
+			req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+			do, err = http.DefaultClient.Do(req)
+			if err != nil {
+				return "", fmt.Errorf("Network error downloading cover from both URLs: %w", err)
+			}
+		} else {
+			return "", fmt.Errorf("Network error downloading cover: %w", err)
+		}
+	}
+
+	defer do.Body.Close()
+
+	// Check HTTP status
+	if do.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Server returned non-OK status downloading cover: %s (HTTP %d)", do.Status, do.StatusCode)
+	}
+
+	// Check content length
+	contentLength := do.ContentLength
+	if contentLength <= 0 {
+		slog.Debug("cover content length not available, proceeding anyway", "url", url)
+	} else if contentLength < 1000 {
+		slog.Warn("cover image is very small, might be a placeholder", "url", url, "bytes", contentLength)
+	}
+
+	// Create destination file
+	f, err := os.Create(covPath)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create cover file at %s: %w", covPath, err)
+	}
+	defer f.Close()
+
+	// Copy content
+	bytesWritten, err := io.Copy(f, do.Body)
+	if err != nil {
+		return "", fmt.Errorf("Failed to write cover data to file: %w", err)
+	}
+
+	if bytesWritten == 0 {
+		return "", errors.New("Cover downloaded but file is empty (0 bytes)")
+	}
+
+	slog.Debug("cover downloaded", "path", covPath, "bytes", bytesWritten)
+	return covPath, nil
+}
+
+func writeLyrics(sanAlbumFolder, filename string, lrc string) error {
+	lyricspath := filepath.Join(sanAlbumFolder, filename)
+	f, err := os.Create(lyricspath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(lrc)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func contains(slice []string, item string) bool {
+	for _, v := range slice {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAlbumHasAtmos checks if an album has any Dolby Atmos tracks
+func (r *run) checkAlbumHasAtmos(ctx context.Context, meta *structs.AutoGenerated, token string, storefront string) (bool, error) {
+	// Skip check for playlists
+	if len(meta.Data) == 0 || meta.Data[0].Type == "playlists" {
+		return false, nil
+	}
+
+	// Check at least 3 tracks or all if album has fewer tracks
+	checkLimit := 3
+	if len(meta.Data[0].Relationships.Tracks.Data) < checkLimit {
+		checkLimit = len(meta.Data[0].Relationships.Tracks.Data)
+	}
+
+	for i := 0; i < checkLimit; i++ {
+		track := meta.Data[0].Relationships.Tracks.Data[i]
+
+		manifest, err := r.getInfoFromAdam(ctx, track.ID, token, storefront)
+		if err != nil {
+			continue // Skip this track if can't get manifest
+		}
+
+		if manifest.Attributes.ExtendedAssetUrls.EnhancedHls == "" {
+			continue // Skip if no enhanced HLS URL
+		}
+
+		// Check for Atmos in the M3U8
+		masterUrl := manifest.Attributes.ExtendedAssetUrls.EnhancedHls
+
+		// Try device port for better detection if configured
+		if r.Config.GetM3u8Mode == "all" || (r.Config.GetM3u8Mode == "hires" && contains(track.Attributes.AudioTraits, "hi-res-lossless")) {
+			deviceM3u8, err := r.checkM3u8(track.ID, "song")
+			if err == nil && strings.HasSuffix(deviceM3u8, ".m3u8") {
+				masterUrl = deviceM3u8
+			}
+		}
+
+		// Check if the track has Atmos
+		hasAtmos, err := checkTrackHasAtmos(ctx, masterUrl)
+		if err != nil {
+			continue
+		}
+
+		if hasAtmos {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// checkTrackHasAtmos checks if a track's M3U8 contains Atmos variants
+func checkTrackHasAtmos(ctx context.Context, masterUrl string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", masterUrl, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, errors.New(resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+
+	masterString := string(body)
+	from, listType, err := m3u8.DecodeFrom(strings.NewReader(masterString), true)
+	if err != nil || listType != m3u8.MASTER {
+		return false, errors.New("m3u8 not of master type")
+	}
+
+	master := from.(*m3u8.MasterPlaylist)
+
+	// Check for Atmos variants
+	for _, variant := range master.Variants {
+		if variant.Codecs == "ec-3" && strings.Contains(variant.Audio, "atmos") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// songFileName fills a song-file-format template for track and appends ext.
+// Names longer than maxNameLength fall back to the song ID.
+func (r *run) songFileName(format string, track structs.TrackData, trackNum int, Quality, Tag_string, Codec, Channels, Bitrate, ext string) string {
+	songName := strings.NewReplacer(
+		"{SongId}", track.ID,
+		"{SongNumer}", fmt.Sprintf("%02d", trackNum),
+		"{SongName}", r.LimitString(track.Attributes.Name),
+		"{DiscNumber}", fmt.Sprintf("%0d", track.Attributes.DiscNumber),
+		"{TrackNumber}", fmt.Sprintf("%0d", track.Attributes.TrackNumber),
+		"{Quality}", Quality,
+		"{Tag}", Tag_string,
+		"{Codec}", Codec,
+		"{Channels}", Channels,
+		"{Bitrate}", Bitrate,
+		"{ArtistName}", r.LimitString(track.Attributes.ArtistName),
+	).Replace(format)
+	filename := fmt.Sprintf("%s.%s", forbiddenNames.ReplaceAllString(songName, "_"), ext)
+	if len(filename) > maxNameLength {
+		songNameFallback := strings.NewReplacer(
+			"{SongId}", track.ID,
+			"{SongNumer}", fmt.Sprintf("%02d", trackNum),
+			"{SongName}", track.ID,
+			"{DiscNumber}", "",
+			"{TrackNumber}", "",
+			"{Quality}", "",
+			"{Tag}", "",
+			"{Codec}", "",
+			"{Channels}", "",
+			"{Bitrate}", "",
+			"{ArtistName}", "",
+		).Replace(format)
+		filename = fmt.Sprintf("%s.%s", forbiddenNames.ReplaceAllString(songNameFallback, "_"), ext)
+	}
+	return filename
+}
+
+// findTrackFile returns the path of the file in dir whose name matches
+// pattern, or "" if there is none.
+func findTrackFile(dir, pattern string) string {
+	if found := findEntries(dir, pattern, false); len(found) > 0 {
+		return found[0]
+	}
+	return ""
+}
+
+// findEntries returns the paths of the files, or the folders when dirs is
+// set, in dir whose names match pattern.
+func findEntries(dir, pattern string, dirs bool) []string {
+	parts := strings.Split(pattern, nameWildcard)
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	re, err := regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+	if err != nil {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var found []string
+	for _, entry := range entries {
+		if entry.IsDir() == dirs && re.MatchString(entry.Name()) {
+			found = append(found, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return found
+}
+
+// findOldCopy returns the local copy of a track whose file name matches
+// pattern, for upgrades. It is looked for in sanAlbumFolder, then in the
+// album folders of saveFolder, whatever {Quality} and {Codec} they were
+// named with. Other save folders hold other editions, which an upgrade
+// must not replace.
+func (r *run) findOldCopy(sanAlbumFolder, saveFolder, pattern string, meta *structs.AutoGenerated, albumId, singerFoldername, albumTag string) string {
+	if found := findTrackFile(sanAlbumFolder, pattern); found != "" {
+		return found
+	}
+	albumPattern := forbiddenNames.ReplaceAllString(r.albumFolderName(r.Config.AlbumFolderFormat, r.Config.PlaylistFolderFormat, meta, albumId, nameWildcard, nameWildcard, albumTag), "_")
+	artistFolder := filepath.Join(saveFolder, forbiddenNames.ReplaceAllString(singerFoldername, "_"))
+	for _, albumFolder := range findEntries(artistFolder, albumPattern, true) {
+		if found := findTrackFile(albumFolder, pattern); found != "" {
+			return found
+		}
+	}
+	return ""
+}
+
+// albumFolderName fills the album or playlist folder template for meta.
+// Names longer than maxNameLength fall back to the album ID.
+func (r *run) albumFolderName(albumFormat, playlistFormat string, meta *structs.AutoGenerated, albumId, Quality, Codec, Tag_string string) string {
+	var albumFolder string
+	if isPlaylist(albumId) {
+		albumFolder = strings.NewReplacer(
+			"{ArtistName}", "Apple Music",
+			"{PlaylistName}", r.LimitString(meta.Data[0].Attributes.Name),
+			"{PlaylistId}", albumId,
+			"{Quality}", Quality,
+			"{Codec}", Codec,
+			"{Tag}", Tag_string,
+		).Replace(playlistFormat)
+	} else {
+		albumFolder = strings.NewReplacer(
+			"{ReleaseDate}", meta.Data[0].Attributes.ReleaseDate,
+			"{ReleaseYear}", meta.Data[0].Attributes.ReleaseDate[:4],
+			"{ArtistName}", r.LimitString(meta.Data[0].Attributes.ArtistName),
+			"{AlbumName}", r.LimitString(meta.Data[0].Attributes.Name),
+			"{UPC}", meta.Data[0].Attributes.Upc,
+			"{RecordLabel}", meta.Data[0].Attributes.RecordLabel,
+			"{Copyright}", meta.Data[0].Attributes.Copyright,
+			"{AlbumId}", albumId,
+			"{Quality}", Quality,
+			"{Codec}", Codec,
+			"{Tag}", Tag_string,
+		).Replace(albumFormat)
+	}
+	if strings.HasSuffix(albumFolder, ".") {
+		albumFolder = strings.ReplaceAll(albumFolder, ".", "")
+	}
+	albumFolder = strings.TrimSpace(albumFolder)
+	if len(albumFolder) > maxNameLength {
+		albumFolder = albumId
+	}
+	return albumFolder
+}
+
+// mirrorTrack writes the portable copy of a finished master file into
+// mirrorFolder, using the mirror templates and profile from the config,
+// with the cover at covPath.
+func (r *run) mirrorTrack(ctx context.Context, trackPath, covPath, mirrorFolder string, track structs.TrackData, trackNum int, Tag_string string) error {
+	profile := transcode.Profile{Codec: r.Config.MirrorCodec, Bitrate: r.Config.MirrorBitrate}
+	filename := r.songFileName(r.Config.MirrorSongFileFormat, track, trackNum, r.Config.MirrorBitrate, Tag_string, strings.ToUpper(r.Config.MirrorCodec), "", r.Config.MirrorBitrate, transcode.Extension(r.Config.MirrorCodec))
+	mirrorPath := filepath.Join(mirrorFolder, filename)
+	return transcode.Mirror(ctx, r.mirrorEncoder, trackPath, covPath, mirrorPath, profile)
+}
+
+// qualityChain returns the codecs tried for each track, best first.
+// The edition being saved, "" without --editions, or --atmos and --aac
+// decide it; otherwise
+// quality-preference is used, and ALAC when it is empty. "aac-lc" is only
+// in it when quality-preference lists it, see lcFallback for tracks
+// without an enhanced HLS manifest.
+func (r *run) qualityChain(edition string) []string {
+	atmos, aac := r.dl_atmos, r.dl_aac
+	if edition != "" {
+		atmos, aac = edition == "atmos", edition == "aac"
+	}
+	var entries []string
+	if atmos {
+		entries = []string{"atmos", "dolby-audio"}
+	} else if aac {
+		entries = []string{r.Config.AacType}
+	} else if r.Config.QualityPreference != "" && edition == "" {
+		entries = strings.Split(r.Config.QualityPreference, ">")
+	} else {
+		entries = []string{"alac"}
+	}
+	var tiers []string
+	for _, tier := range entries {
+		tier = strings.TrimSpace(tier)
+		if tier != "" && !contains(tiers, tier) {
+			tiers = append(tiers, tier)
+		}
+	}
+	if len(tiers) == 0 {
+		return []string{"alac"}
+	}
+	return tiers
+}
+
+// lcFallback reports whether a track without an enhanced HLS manifest is
+// saved in AAC-LC instead. It always was, but for Atmos; a quality-preference
+// has to list aac-lc for it.
+func (r *run) lcFallback(tiers []string, edition string) bool {
+	if contains(tiers, "aac-lc") {
+		return true
+	}
+	fromPreference := r.Config.QualityPreference != "" && edition == "" && !r.dl_atmos && !r.dl_aac
+	return tiers[0] != "atmos" && !fromPreference
+}
+
+// tierCodec is the {Codec} name of a quality-preference entry.
+func tierCodec(tier string) string {
+	switch tier {
+	case "atmos":
+		return "ATMOS"
+	case "dolby-audio":
+		return "AC3"
+	case "alac", "alac-hires":
+		return "ALAC"
+	}
+	return "AAC"
+}
+
+// saveFolderFor returns the save folder for tracks of tier.
+func (r *run) saveFolderFor(tier string) string {
+	switch tierCodec(tier) {
+	case "ATMOS", "AC3":
+		return r.Config.AtmosSaveFolder
+	case "AAC":
+		if r.Config.AacSaveFolder != "" {
+			return r.Config.AacSaveFolder
+		}
+	}
+	return r.Config.AlacSaveFolder
+}
+
+// okKey keys okDict, so each edition of an album is retried on its own.
+func okKey(albumId, edition string) string {
+	if edition == "" {
+		return albumId
+	}
+	return albumId + "#" + edition
+}
+
+// tallyEdition adds what counter gained since before to the counts of name.
+func (r *run) tallyEdition(name string, before structs.Counter) {
+	c := r.editionCount[name]
+	c.Unavailable += r.counter.Unavailable - before.Unavailable
+	c.NotSong += r.counter.NotSong - before.NotSong
+	c.Error += r.counter.Error - before.Error
+	c.Success += r.counter.Success - before.Success
+	c.Total += r.counter.Total - before.Total
+	r.editionCount[name] = c
+}
+
+type lyricsResult struct {
+	lrc string
+	err error
+}
+
+// trackLyrics fetches the lyrics of songId once per album.
+func (r *run) trackLyrics(ctx context.Context, storefront, songId, token, mediaUserToken string) (string, error) {
+	if cached, ok := r.lyricsCache[songId]; ok {
+		return cached.lrc, cached.err
+	}
+	lrc, err := lyrics.Get(ctx, storefront, songId, r.Config.LrcType, r.Config.Language, r.Config.LrcFormat, token, mediaUserToken)
+	r.lyricsCache[songId] = lyricsResult{lrc, err}
+	return lrc, err
+}
+
+// albumCover writes the album cover into folder. It is downloaded once per
+// album and copied into the folders of further editions.
+func (r *run) albumCover(ctx context.Context, folder string, url string) (string, error) {
+	if r.albumCoverPath == "" {
+		covPath, err := r.writeCover(ctx, folder, "cover", url)
+		if err == nil {
+			r.albumCoverPath = covPath
+		}
+		return covPath, err
+	}
+	covPath := filepath.Join(folder, filepath.Base(r.albumCoverPath))
+	if covPath == r.albumCoverPath {
+		return covPath, nil
+	}
+	data, err := os.ReadFile(r.albumCoverPath)
+	if err != nil {
+		return "", err
+	}
+	return covPath, os.WriteFile(covPath, data, 0644)
+}
+
+// localTier names the quality-preference entry a downloaded file matches.
+func localTier(info *audioinfo.Info, tiers []string) string {
+	switch info.Codec {
+	case "ec-3":
+		if info.Atmos {
+			return "atmos"
+		}
+		return "ec-3"
+	case "ac-3":
+		return "dolby-audio"
+	case "alac":
+		if info.SampleRate > 48000 && contains(tiers, "alac-hires") {
+			return "alac-hires"
+		}
+		return "alac"
+	}
+	// binaural and downmix AAC cannot be told apart from the file
+	for _, tier := range tiers {
+		if tierCodec(tier) == "AAC" {
+			return tier
+		}
+	}
+	return "aac-lc"
+}
+
+// isUpgrade reports whether variant, found as tier, beats the file at
+// localPath: first by position in tiers, then by sample rate and bit
+// depth for ALAC or bitrate for lossy codecs. ok is false when the file
+// is of a tier missing from tiers, another edition that is neither
+// replaced nor kept in place of this one.
+func isUpgrade(localPath string, variant quality.Variant, tier string, tiers []string) (better bool, ok bool, err error) {
+	info, err := audioinfo.Read(localPath)
+	if err != nil {
+		return false, false, err
+	}
+	local := localTier(info, tiers)
+	if !contains(tiers, local) {
+		return false, false, nil
+	}
+	if local != tier {
+		for _, entry := range tiers {
+			if entry == tier || entry == local {
+				return entry == tier, true, nil
+			}
+		}
+	}
+	if info.Codec == "alac" {
+		if variant.SampleRate != info.SampleRate {
+			return variant.SampleRate > info.SampleRate, true, nil
+		}
+		return variant.BitDepth > info.BitDepth, true, nil
+	}
+	return variant.Bitrate > info.Bitrate, true, nil
+}
+
+// replaceTrack moves newPath to finalPath in place of oldPath. The old copy
+// is archived, kept next to it as .old or deleted, per upgrade-old-copy.
+func (r *run) replaceTrack(oldPath, newPath, finalPath string) error {
+	switch r.Config.UpgradeOldCopy {
+	case "delete":
+	case "keep":
+		if err := linkOrCopy(oldPath, oldPath+".old"); err != nil {
+			return err
+		}
+	default:
+		archivePath := filepath.Join(r.Config.UpgradeArchiveFolder, filepath.Base(filepath.Dir(oldPath)), filepath.Base(oldPath))
+		if err := os.MkdirAll(filepath.Dir(archivePath), os.ModePerm); err != nil {
+			return err
+		}
+		if err := linkOrCopy(oldPath, archivePath); err != nil {
+			return err
+		}
+	}
+	// rename replaces an old copy of the same name in one step
+	if err := os.Rename(newPath, finalPath); err != nil {
+		return err
+	}
+	if oldPath != finalPath {
+		return os.Remove(oldPath)
+	}
+	return nil
+}
+
+func linkOrCopy(src, dst string) error {
+	os.Remove(dst)
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
+
+// sidecarLrc is the lyrics file saved next to trackPath.
+func (r *run) sidecarLrc(trackPath string) string {
+	return strings.TrimSuffix(trackPath, filepath.Ext(trackPath)) + "." + r.Config.LrcFormat
+}
+
+// moveTrack renames a track and its lyrics file.
+func (r *run) moveTrack(oldPath, newPath string) error {
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	if exists, _ := fileExists(r.sidecarLrc(oldPath)); exists {
+		return os.Rename(r.sidecarLrc(oldPath), r.sidecarLrc(newPath))
+	}
+	return nil
+}
+
+// syncPrepare runs before the tracks of a synced playlist. Tracks no longer
+// in the playlist are archived or deleted per sync-removed, and tracks that
+// moved are set aside under a temporary name so renumbered files cannot
+// collide.
+func (r *run) syncPrepare(meta *structs.AutoGenerated, folder string) error {
+	var ids []string
+	for _, track := range meta.Data[0].Relationships.Tracks.Data {
+		ids = append(ids, track.ID)
+	}
+	for _, id := range r.syncManifest.Removed(ids) {
+		path := filepath.Join(folder, r.syncManifest.Tracks[id].Path)
+		if exists, _ := fileExists(path); exists {
+			slog.Info("removed from playlist", "path", filepath.Base(path))
+			if err := r.removeSynced(path, folder); err != nil {
+				return err
+			}
+		}
+		delete(r.syncManifest.Tracks, id)
+	}
+	for i, id := range ids {
+		entry, ok := r.syncManifest.Tracks[id]
+		if !ok || entry.Number == i+1 {
+			continue
+		}
+		oldPath := filepath.Join(folder, entry.Path)
+		if exists, _ := fileExists(oldPath); !exists {
+			continue
+		}
+		staged := filepath.Join(filepath.Dir(oldPath), ".sync-"+id+filepath.Ext(oldPath))
+		if err := r.moveTrack(oldPath, staged); err != nil {
+			return err
+		}
+		r.syncStaged[id] = entry.Path
+		entry.Path, _ = filepath.Rel(folder, staged)
+		r.syncManifest.Tracks[id] = entry
+	}
+	// staged names must survive an interrupted run
+	return r.syncManifest.Save(folder)
+}
+
+// syncUnstage runs after the tracks of a synced playlist and moves the
+// tracks syncPrepare set aside but downloadTrack did not rename back to
+// their old names. One whose old name was taken stays staged, the manifest
+// still finds it.
+func (r *run) syncUnstage(folder string) {
+	for id, oldRel := range r.syncStaged {
+		entry, ok := r.syncManifest.Tracks[id]
+		if !ok {
+			continue
+		}
+		staged := filepath.Join(folder, entry.Path)
+		if exists, _ := fileExists(staged); !exists || !strings.HasPrefix(filepath.Base(staged), ".sync-") {
+			continue
+		}
+		oldPath := filepath.Join(folder, oldRel)
+		if exists, _ := fileExists(oldPath); exists {
+			continue
+		}
+		if err := r.moveTrack(staged, oldPath); err != nil {
+			slog.Warn("failed to restore staged track", "path", staged, "err", err)
+			continue
+		}
+		entry.Path = oldRel
+		r.syncManifest.Tracks[id] = entry
+	}
+}
+
+// removeSynced archives a removed track into sync-archive-folder, unless
+// sync-removed is delete, and removes it from the playlist folder.
+func (r *run) removeSynced(path, folder string) error {
+	if r.Config.SyncRemoved != "delete" {
+		archivePath := filepath.Join(r.Config.SyncArchiveFolder, filepath.Base(folder), filepath.Base(path))
+		if err := os.MkdirAll(filepath.Dir(archivePath), os.ModePerm); err != nil {
+			return err
+		}
+		if err := linkOrCopy(path, archivePath); err != nil {
+			return err
+		}
+		if exists, _ := fileExists(r.sidecarLrc(path)); exists {
+			if err := linkOrCopy(r.sidecarLrc(path), r.sidecarLrc(archivePath)); err != nil {
+				return err
+			}
+		}
+	}
+	os.Remove(r.sidecarLrc(path))
+	return os.Remove(path)
+}
+
+// syncRenumber rewrites the track number tags of a synced track that moved
+// or whose playlist changed length.
+func (r *run) syncRenumber(id, trackPath string, trackNum, trackTotal int) error {
+	if r.syncManifest == nil || r.Config.UseSongInfoForPlaylist {
+		return nil
+	}
+	if entry, ok := r.syncManifest.Tracks[id]; ok && entry.Number == trackNum && r.syncManifest.Total == trackTotal {
+		return nil
+	}
+	mp4, err := mp4tag.Open(trackPath)
+	if err != nil {
+		return err
+	}
+	defer mp4.Close()
+	return mp4.Write(&mp4tag.MP4Tags{TrackNumber: int16(trackNum), TrackTotal: int16(trackTotal)}, []string{})
+}
+
+// recordTrack notes where a track was saved under key, the okKey of its
+// album, for playlist files and sync.
+func (r *run) recordTrack(key, id, trackPath string, trackNum int) {
+	if r.trackFiles[key] == nil {
+		r.trackFiles[key] = make(map[string]string)
+	}
+	r.trackFiles[key][id] = trackPath
+	if r.syncManifest == nil {
+		return
+	}
+	rel, err := filepath.Rel(r.syncFolder, trackPath)
+	if err != nil {
+		rel = trackPath
+	}
+	r.syncManifest.Tracks[id] = playlist.Track{Path: rel, Number: trackNum}
+}
+
+// playlistFormats are the playlist files to write after each album or
+// playlist, from playlist-file. sync always writes an .m3u8.
+func (r *run) playlistFormats() []string {
+	var formats []string
+	for _, format := range strings.Split(r.Config.PlaylistFile, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format != "" && !contains(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+	if r.sync_mode && !contains(formats, "m3u8") {
+		formats = append(formats, "m3u8")
+	}
+	return formats
+}
+
+// writePlaylistFiles lists the tracks of meta that are on disk in catalog
+// order, in each of formats, next to them in folder. Tracks saved in this
+// run are taken from trackFiles under key, the rest are looked up by name.
+func (r *run) writePlaylistFiles(meta *structs.AutoGenerated, key, folder string, formats []string) error {
+	var entries []playlist.Entry
+	for i, track := range meta.Data[0].Relationships.Tracks.Data {
+		trackPath, ok := r.trackFiles[key][track.ID]
+		if !ok {
+			pattern := r.songFileName(r.Config.SongFileFormat, track, i+1, nameWildcard, nameWildcard, nameWildcard, nameWildcard, nameWildcard, "m4a")
+			if trackPath = findTrackFile(folder, pattern); trackPath == "" {
+				continue
+			}
+		}
+		rel, err := filepath.Rel(folder, trackPath)
+		if err != nil {
+			rel = trackPath
+		}
+		entries = append(entries, playlist.Entry{
+			Path:     rel,
+			Title:    track.Attributes.Name,
+			Artist:   track.Attributes.ArtistName,
+			Duration: time.Duration(track.Attributes.DurationInMillis) * time.Millisecond,
+		})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	for _, format := range formats {
+		path := filepath.Join(folder, filepath.Base(folder)+"."+format)
+		if err := playlist.Write(path, format, meta.Data[0].Attributes.Name, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warn logs a problem with the current track and passes it on to the
+// progress observer.
+func warn(ctx context.Context, msg string, err error) {
+	logs.From(ctx).Warn(msg, "err", err)
+	progress.From(ctx).Warning(fmt.Sprintf("%s: %v", msg, err))
+}
+
+// 下载单曲逻辑
+func (r *run) downloadTrack(ctx context.Context, trackNum int, trackTotal int, meta *structs.AutoGenerated, track structs.TrackData, albumId, edition, token, storefront, mediaUserToken, sanAlbumFolder, Codec string, covPath string, mirrorFolder string, singerFoldername, albumTag string) structs.TrackResult {
+	result := structs.TrackResult{Number: trackNum, ID: track.ID, Name: track.Attributes.Name}
+	ctx = logs.With(ctx, "track", trackNum, "id", track.ID)
+	log := logs.From(ctx)
+	obs := progress.From(ctx)
+	obs.TrackStarted(progress.Track{Number: trackNum, Total: trackTotal, ID: track.ID, Name: track.Attributes.Name})
+
+	// Skip if cover_art_only mode is enabled
+	if r.cover_art_only {
+		// We already downloaded the album cover in rip(), no need to do anything per track
+		return result.End(structs.TrackSuccess, nil)
+	}
+
+	//mv dl dev
+	if track.Type == "music-videos" {
+		if r.lyrics_only {
+			log.Info("skipping music video in lyrics-only mode")
+			return result.End(structs.TrackSuccess, nil)
+		}
+
+		if len(mediaUserToken) <= 50 {
+			log.Warn("media-user-token is not set, skipping music video")
+			return result.End(structs.TrackSuccess, nil)
+		}
+		if _, err := exec.LookPath("mp4decrypt"); err != nil {
+			log.Warn("mp4decrypt is not found, skipping music video")
+			return result.End(structs.TrackSuccess, nil)
+		}
+
+		// Skip if skip_mv flag is enabled
+		if r.skip_mv {
+			log.Info("skipping music video, skip-mv is set")
+			return result.End(structs.TrackSuccess, nil)
+		}
+
+		err := r.mvDownloader(ctx, track.ID, sanAlbumFolder, token, storefront, mediaUserToken, meta)
+		if err != nil {
+			warn(ctx, "failed to dl MV", err)
+			return result.End(structs.TrackError, err)
+		}
+		return result.End(structs.TrackSuccess, nil)
+	}
+
+	manifest, err := r.getInfoFromAdam(ctx, track.ID, token, storefront)
+	if err != nil {
+		warn(ctx, "failed to get manifest", err)
+		return result.End(failure.Status(err), err)
+	}
+	key := okKey(albumId, edition)
+	tiers := r.qualityChain(edition)
+	var variant quality.Variant
+	var tier string
+	if tiers[0] == "aac-lc" {
+		variant, tier = aacLcVariant, "aac-lc"
+	} else if manifest.Attributes.ExtendedAssetUrls.EnhancedHls == "" {
+		if !r.lcFallback(tiers, edition) {
+			log.Warn("unavailable, no enhanced HLS manifest")
+			err := fmt.Errorf("%w: no enhanced HLS manifest", failure.ErrNoLossless)
+			return result.End(failure.Status(err), err)
+		}
+		log.Warn("no enhanced HLS manifest, trying AAC-LC")
+		variant, tier = aacLcVariant, "aac-lc"
+	} else {
+		needCheck := false
+
+		if r.Config.GetM3u8Mode == "all" {
+			needCheck = true
+		} else if r.Config.GetM3u8Mode == "hires" && contains(track.Attributes.AudioTraits, "hi-res-lossless") {
+			needCheck = true
+		}
+		var EnhancedHls_m3u8 string
+		if needCheck {
+			EnhancedHls_m3u8, _ = r.checkM3u8(track.ID, "song")
+			if strings.HasSuffix(EnhancedHls_m3u8, ".m3u8") {
+				manifest.Attributes.ExtendedAssetUrls.EnhancedHls = EnhancedHls_m3u8
+			}
+		}
+		variant, tier, err = r.extractMedia(ctx, manifest.Attributes.ExtendedAssetUrls.EnhancedHls, tiers, false)
+		if err != nil {
+			warn(ctx, "failed to extract info from manifest", err)
+			return result.End(failure.Status(err), err)
+		}
+	}
+	Quality := tierQuality(variant, tier)
+	result.Quality = tier
+	if r.Config.PerCodecFolders && tierCodec(tier) != Codec {
+		albumFolder := r.albumFolderName(r.Config.AlbumFolderFormat, r.Config.PlaylistFolderFormat, meta, albumId, Quality, tierCodec(tier), albumTag)
+		sanAlbumFolder = filepath.Join(r.saveFolderFor(tier), forbiddenNames.ReplaceAllString(singerFoldername, "_"), forbiddenNames.ReplaceAllString(albumFolder, "_"))
+		os.MkdirAll(sanAlbumFolder, os.ModePerm)
+		if exists, _ := fileExists(filepath.Join(sanAlbumFolder, "cover."+r.Config.CoverFormat)); !exists {
+			if _, err := r.albumCover(ctx, sanAlbumFolder, meta.Data[0].Attributes.Artwork.URL); err != nil {
+				log.Warn("failed to write cover", "err", err)
+			}
+		}
+	}
+	Codec = tierCodec(tier)
+	stringsToJoin := []string{}
+	if track.Attributes.IsAppleDigitalMaster {
+		if r.Config.AppleMasterChoice != "" {
+			stringsToJoin = append(stringsToJoin, r.Config.AppleMasterChoice)
+		}
+	}
+	if track.Attributes.ContentRating == "explicit" {
+		if r.Config.ExplicitChoice != "" {
+			stringsToJoin = append(stringsToJoin, r.Config.ExplicitChoice)
+		}
+	}
+	if track.Attributes.ContentRating == "clean" {
+		if r.Config.CleanChoice != "" {
+			stringsToJoin = append(stringsToJoin, r.Config.CleanChoice)
+		}
+	}
+	Tag_string := strings.Join(stringsToJoin, " ")
+
+	filename := r.songFileName(r.Config.SongFileFormat, track, trackNum, Quality, Tag_string, Codec, "", "", "m4a")
+	lrcFilename := r.songFileName(r.Config.SongFileFormat, track, trackNum, Quality, Tag_string, Codec, "", "", r.Config.LrcFormat)
+	// {Channels} and {Bitrate} come from the downloaded file, so the final
+	// name is only known afterwards and an existing copy is found by pattern
+	namedAfterDownload := strings.Contains(r.Config.SongFileFormat, "{Channels}") || strings.Contains(r.Config.SongFileFormat, "{Bitrate}")
+
+	// Define trackPath here
+	trackPath := filepath.Join(sanAlbumFolder, filename)
+	if namedAfterDownload {
+		pattern := r.songFileName(r.Config.SongFileFormat, track, trackNum, Quality, Tag_string, Codec, nameWildcard, nameWildcard, "m4a")
+		if found := findTrackFile(sanAlbumFolder, pattern); found != "" {
+			trackPath = found
+		}
+	}
+
+	// Get lyrics - now unconditionally downloaded in lyrics-only mode
+	var lrc string = ""
+	lyricsDownloaded := false
+	if r.Config.EmbedLrc || r.Config.SaveLrcFile || r.lyrics_only {
+		lrcStr, err := r.trackLyrics(ctx, storefront, track.ID, token, mediaUserToken)
+		if err != nil {
+			log.Info("no lyrics", "err", err)
+		} else {
+			lyricsDownloaded = true
+			if r.Config.SaveLrcFile || r.lyrics_only {
+				err := writeLyrics(sanAlbumFolder, lrcFilename, lrcStr)
+				if err != nil {
+					log.Warn("failed to write lyrics", "path", lrcFilename, "err", err)
+				} else if r.lyrics_only {
+					log.Info("lyrics saved", "path", lrcFilename)
+				}
+			}
+			if r.Config.EmbedLrc {
+				lrc = lrcStr
+			}
+		}
+	}
+
+	// In lyrics-only mode, mark as success after downloading lyrics and skip the rest
+	if r.lyrics_only {
+		if !lyricsDownloaded {
+			log.Info("no lyrics found")
+		}
+		r.okDict[key] = append(r.okDict[key], track.ID)
+		return result.End(structs.TrackSuccess, nil)
+	}
+
+	// upgrade: find the local copy whatever its quality, and replace it only
+	// when what is available now is better
+	var oldPath string
+	if r.upgrade_mode {
+		pattern := r.songFileName(r.Config.SongFileFormat, track, trackNum, nameWildcard, Tag_string, nameWildcard, nameWildcard, nameWildcard, "m4a")
+		if found := r.findOldCopy(sanAlbumFolder, r.saveFolderFor(tier), pattern, meta, albumId, singerFoldername, albumTag); found != "" {
+			better, ok, err := isUpgrade(found, variant, tier, tiers)
+			if err != nil {
+				log.Error("failed to read local track", "path", found, "err", err)
+				return result.End(structs.TrackError, err)
+			}
+			if !ok {
+				log.Debug("local copy is another edition", "path", found)
+			} else if better {
+				log.Info("upgrading", "path", filepath.Base(found), "quality", tier, "to", Quality)
+				oldPath = found
+			} else {
+				trackPath = found
+			}
+		}
+	}
+
+	// sync: a track that moved in the playlist is renamed, not downloaded again
+	if r.syncManifest != nil && oldPath == "" {
+		if entry, ok := r.syncManifest.Tracks[track.ID]; ok {
+			syncedPath := filepath.Join(r.syncFolder, entry.Path)
+			if exists, _ := fileExists(syncedPath); exists && syncedPath != trackPath {
+				if namedAfterDownload {
+					if info, err := audioinfo.Read(syncedPath); err == nil {
+						trackPath = filepath.Join(sanAlbumFolder, r.songFileName(r.Config.SongFileFormat, track, trackNum, Quality, Tag_string, Codec, info.ChannelsString(), info.BitrateString(), "m4a"))
+					}
+				}
+				log.Info("moved in playlist", "path", filepath.Base(trackPath))
+				if err := r.moveTrack(syncedPath, trackPath); err != nil {
+					warn(ctx, "failed to move track", err)
+					return result.End(structs.TrackError, err)
+				}
+			}
+		}
+	}
+
+	exists, err := fileExists(trackPath)
+	if err != nil {
+		log.Warn("failed to check if track exists", "path", trackPath, "err", err)
+	}
+	if exists && oldPath == "" {
+		log.Info("track already exists", "path", trackPath)
+		if err := r.syncRenumber(track.ID, trackPath, trackNum, trackTotal); err != nil {
+			warn(ctx, "failed to renumber track", err)
+			return result.End(structs.TrackError, err)
+		}
+		if mirrorFolder != "" {
+			// the master is saved either way, the copy is made on the next run
+			if err := r.mirrorTrack(ctx, trackPath, covPath, mirrorFolder, track, trackNum, Tag_string); err != nil {
+				warn(ctx, "failed to write mirror copy", err)
+			}
+		}
+		r.qualityCount[tier]++
+		r.recordTrack(key, track.ID, trackPath, trackNum)
+		r.okDict[key] = append(r.okDict[key], track.ID)
+		result.Path = trackPath
+		return result.End(structs.TrackSuccess, nil)
+	}
+	upgradePath := trackPath
+	if oldPath != "" {
+		trackPath = strings.TrimSuffix(trackPath, ".m4a") + ".upgrade.m4a"
+		defer os.Remove(trackPath)
+	}
+	if tier == "aac-lc" {
+		if len(mediaUserToken) <= 50 {
+			log.Error("invalid media-user-token, AAC-LC needs it")
+			return result.End(structs.TrackError, fmt.Errorf("%w: invalid media-user-token", failure.ErrAuth))
+		}
+		_, err := runv3.Run(ctx, track.ID, trackPath, token, mediaUserToken, false)
+		if err != nil {
+			log.Error("failed to download AAC-LC", "err", err)
+			return result.End(failure.Status(err), err)
+		}
+	} else {
+		//边下载边解密
+		err = runv2.Run(ctx, track.ID, variant.URI, trackPath, r.Config)
+		if err != nil {
+			log.Error("failed to download", "quality", tier, "err", err)
+			return result.End(failure.Status(err), err)
+		}
+	}
+	obs.StageChanged(progress.Tag)
+	audioInfo, err := audioinfo.Read(trackPath)
+	if err != nil {
+		log.Warn("failed to read audio info", "err", err)
+	} else if namedAfterDownload && oldPath != "" {
+		upgradePath = filepath.Join(sanAlbumFolder, r.songFileName(r.Config.SongFileFormat, track, trackNum, Quality, Tag_string, Codec, audioInfo.ChannelsString(), audioInfo.BitrateString(), "m4a"))
+	} else if namedAfterDownload {
+		finalPath := filepath.Join(sanAlbumFolder, r.songFileName(r.Config.SongFileFormat, track, trackNum, Quality, Tag_string, Codec, audioInfo.ChannelsString(), audioInfo.BitrateString(), "m4a"))
+		if finalPath != trackPath {
+			if err := os.Rename(trackPath, finalPath); err != nil {
+				log.Error("failed to rename track", "err", err)
+				return result.End(structs.TrackError, err)
+			}
+			trackPath = finalPath
+			lrcPath := filepath.Join(sanAlbumFolder, lrcFilename)
+			if exists, _ := fileExists(lrcPath); exists {
+				finalLrc := r.songFileName(r.Config.SongFileFormat, track, trackNum, Quality, Tag_string, Codec, audioInfo.ChannelsString(), audioInfo.BitrateString(), r.Config.LrcFormat)
+				if err := os.Rename(lrcPath, filepath.Join(sanAlbumFolder, finalLrc)); err != nil {
+					log.Warn("failed to rename lyrics", "err", err)
+				}
+			}
+		}
+	}
+	tags := []string{
+		"tool=",
+		fmt.Sprintf("artist=%s", meta.Data[0].Attributes.ArtistName),
+		//fmt.Sprintf("lyrics=%s", lrc),
+	}
+	var trackCovPath string
+	if r.Config.EmbedCover {
+		if isPlaylist(albumId) && r.Config.DlAlbumcoverForPlaylist {
+			trackCovPath, err = r.writeCover(ctx, sanAlbumFolder, track.ID, track.Attributes.Artwork.URL)
+			if err != nil {
+				log.Warn("failed to write cover", "err", err)
+			}
+			tags = append(tags, fmt.Sprintf("cover=%s", trackCovPath))
+		} else {
+			tags = append(tags, fmt.Sprintf("cover=%s", covPath))
+		}
+	}
+	tagsString := strings.Join(tags, ":")
+	cmd := exec.CommandContext(ctx, "MP4Box", "-itags", tagsString, trackPath)
+	if err := cmd.Run(); err != nil {
+		log.Error("failed to embed tags with MP4Box", "path", trackPath, "err", err)
+		if ctx.Err() != nil {
+			// an untagged file would pass for a finished one next time
+			os.Remove(trackPath)
+		}
+		return result.End(structs.TrackError, fmt.Errorf("%w: %v", failure.ErrTagging, err))
+	}
+	if isPlaylist(albumId) && r.Config.DlAlbumcoverForPlaylist && trackCovPath != "" {
+		if err := os.Remove(trackCovPath); err != nil {
+			log.Error("failed to delete track cover", "path", trackCovPath, "err", err)
+			return result.End(structs.TrackError, err)
+		}
+	}
+	err = r.writeMP4Tags(trackPath, lrc, meta, trackNum, trackTotal, audioInfo, tier)
+	if err != nil {
+		warn(ctx, "failed to write tags in media", err)
+		return result.End(structs.TrackError, fmt.Errorf("%w: %v", failure.ErrTagging, err))
+	}
+	if oldPath != "" {
+		if err := r.replaceTrack(oldPath, trackPath, upgradePath); err != nil {
+			warn(ctx, "failed to replace track", err)
+			return result.End(structs.TrackError, err)
+		}
+		trackPath = upgradePath
+		r.upgradeCount++
+	}
+	if mirrorFolder != "" {
+		// the master is saved either way, the copy is made on the next run
+		if err := r.mirrorTrack(ctx, trackPath, covPath, mirrorFolder, track, trackNum, Tag_string); err != nil {
+			warn(ctx, "failed to write mirror copy", err)
+		}
+	}
+	r.qualityCount[tier]++
+	r.recordTrack(key, track.ID, trackPath, trackNum)
+	r.okDict[key] = append(r.okDict[key], track.ID)
+	result.Path = trackPath
+	return result.End(structs.TrackSuccess, nil)
+}
+
+// rip saves the tracks of an album or playlist that the flags select and
+// returns how each ended.
+func (r *run) rip(ctx context.Context, albumId string, token string, storefront string, mediaUserToken string, urlArg_i string) ([]structs.TrackResult, error) {
+	ctx = logs.With(ctx, "album", albumId)
+	meta, err := r.getMeta(ctx, albumId, token, storefront)
+	if err != nil {
+		return nil, err
+	}
+	// editions of one album share its cover and lyrics
+	r.lyricsCache = make(map[string]lyricsResult)
+	r.albumCoverPath = ""
+
+	// If cover_art_only flag is set, only download cover art
+	if r.cover_art_only {
+		logs.From(ctx).Info("cover art only, saving the album artwork")
+
+		// Use the same folder structure logic as regular downloads
+		var singerFoldername string
+		if r.Config.ArtistFolderFormat != "" {
+			if isPlaylist(albumId) {
+				singerFoldername = strings.NewReplacer(
+					"{ArtistName}", "Apple Music",
+					"{ArtistId}", "",
+					"{UrlArtistName}", "Apple Music",
+				).Replace(r.Config.ArtistFolderFormat)
+			} else if len(meta.Data[0].Relationships.Artists.Data) > 0 {
+				singerFoldername = strings.NewReplacer(
+					"{UrlArtistName}", r.LimitString(meta.Data[0].Attributes.ArtistName),
+					"{ArtistName}", r.LimitString(meta.Data[0].Attributes.ArtistName),
+					"{ArtistId}", meta.Data[0].Relationships.Artists.Data[0].ID,
+				).Replace(r.Config.ArtistFolderFormat)
+			} else {
+				singerFoldername = strings.NewReplacer(
+					"{UrlArtistName}", r.LimitString(meta.Data[0].Attributes.ArtistName),
+					"{ArtistName}", r.LimitString(meta.Data[0].Attributes.ArtistName),
+					"{ArtistId}", "",
+				).Replace(r.Config.ArtistFolderFormat)
+			}
+			if strings.HasSuffix(singerFoldername, ".") {
+				singerFoldername = strings.ReplaceAll(singerFoldername, ".", "")
+			}
+			singerFoldername = strings.TrimSpace(singerFoldername)
+			if len(singerFoldername) > maxNameLength {
+				if len(meta.Data[0].Relationships.Artists.Data) > 0 && !isPlaylist(albumId) {
+					singerFoldername = meta.Data[0].Relationships.Artists.Data[0].ID
+				} else {
+					singerFoldername = "UnknownArtist"
+				}
+			}
+		}
+
+		singerFolder := filepath.Join(r.Config.AlacSaveFolder, forbiddenNames.ReplaceAllString(singerFoldername, "_"))
+
+		var albumFolder string
+		if isPlaylist(albumId) {
+			albumFolder = strings.NewReplacer(
+				"{ArtistName}", "Apple Music",
+				"{PlaylistName}", r.LimitString(meta.Data[0].Attributes.Name),
+				"{PlaylistId}", albumId,
+				"{Quality}", "",
+				"{Codec}", "ALAC",
+				"{Tag}", "",
+			).Replace(r.Config.PlaylistFolderFormat)
+		} else {
+			albumFolder = strings.NewReplacer(
+				"{ReleaseDate}", meta.Data[0].Attributes.ReleaseDate,
+				"{ReleaseYear}", meta.Data[0].Attributes.ReleaseDate[:4],
+				"{ArtistName}", r.LimitString(meta.Data[0].Attributes.ArtistName),
+				"{AlbumName}", r.LimitString(meta.Data[0].Attributes.Name),
+				"{UPC}", meta.Data[0].Attributes.Upc,
+				"{RecordLabel}", meta.Data[0].Attributes.RecordLabel,
+				"{Copyright}", meta.Data[0].Attributes.Copyright,
+				"{AlbumId}", albumId,
+				"{Quality}", "",
+				"{Codec}", "ALAC",
+				"{Tag}", "",
+			).Replace(r.Config.AlbumFolderFormat)
+		}
+
+		albumFolder = strings.TrimSpace(albumFolder)
+		sanAlbumFolder := filepath.Join(singerFolder, forbiddenNames.ReplaceAllString(albumFolder, "_"))
+
+		// Create album folder
+		err = os.MkdirAll(sanAlbumFolder, 0755)
+		if err != nil {
+			return nil, errors.New("Failed to create album folder.\n" + err.Error())
+		}
+
+		// Download cover
+		coverURL := meta.Data[0].Attributes.Artwork.URL
+		_, err = r.writeCover(ctx, sanAlbumFolder, "cover", coverURL)
+		if err != nil {
+			return nil, errors.New("Failed to download cover.\n" + err.Error())
+		}
+
+		logs.From(ctx).Info("album artwork saved", "folder", sanAlbumFolder)
+		r.counter.Success++
+		return nil, nil
+	}
+
+	// If atmos-only flag is set, check if album has Atmos tracks
+	if r.atmos_only {
+		// Set dl_atmos to true when atmos_only is enabled
+		r.dl_atmos = true
+
+		// Check if album has Atmos tracks
+		hasAtmos, err := r.checkAlbumHasAtmos(ctx, meta, token, storefront)
+		if err != nil {
+			logs.From(ctx).Warn("failed to check Atmos availability", "err", err)
+		}
+
+		if !hasAtmos {
+			logs.From(ctx).Info("skipping album, no Dolby Atmos tracks")
+			r.counter.Unavailable++
+			return nil, nil
+		}
+
+	}
+
+	if r.debug_mode {
+		// Print album info
+		fmt.Println(meta.Data[0].Attributes.ArtistName)
+		fmt.Println(meta.Data[0].Attributes.Name)
+
+		for trackNum, track := range meta.Data[0].Relationships.Tracks.Data {
+			trackNum++
+			fmt.Printf("\nTrack %d of %d:\n", trackNum, len(meta.Data[0].Relationships.Tracks.Data))
+			fmt.Printf("%02d. %s\n", trackNum, track.Attributes.Name)
+
+			manifest, err := r.getInfoFromAdam(ctx, track.ID, token, storefront)
+			if err != nil {
+				logs.From(ctx).Error("failed to get manifest", "track", trackNum, "id", track.ID, "err", err)
+				continue
+			}
+
+			var m3u8Url string
+			//Web端m3u8
+			if manifest.Attributes.ExtendedAssetUrls.EnhancedHls != "" {
+				m3u8Url = manifest.Attributes.ExtendedAssetUrls.EnhancedHls
+			}
+			//设备端满血m3u8
+			needCheck := false
+			if r.Config.GetM3u8Mode == "all" {
+				needCheck = true
+			} else if r.Config.GetM3u8Mode == "hires" && contains(track.Attributes.AudioTraits, "hi-res-lossless") {
+				needCheck = true
+			}
+			if needCheck {
+				fullM3u8Url, err := r.checkM3u8(track.ID, "song")
+				if err == nil && strings.HasSuffix(fullM3u8Url, ".m3u8") {
+					m3u8Url = fullM3u8Url
+				} else {
+					logs.From(ctx).Warn("failed to get best quality m3u8 from device m3u8 port, using the Web API one", "track", trackNum, "err", err)
+				}
+			}
+
+			_, _, err = r.extractMedia(ctx, m3u8Url, r.qualityChain(""), true)
+			if err != nil {
+				logs.From(ctx).Error("failed to extract quality info", "track", trackNum, "id", track.ID, "err", err)
+				continue
+			}
+		}
+		return nil, nil // Return directly without showing statistics
+	}
+	trackTotal := len(meta.Data[0].Relationships.Tracks.Data)
+	arr := make([]int, trackTotal)
+	for i := 0; i < trackTotal; i++ {
+		arr[i] = i + 1
+	}
+	selected := []int{}
+
+	if r.trackPicks != nil {
+		selected = r.trackPicks
+	} else if r.dl_song {
+		if urlArg_i == "" {
+			//fmt.Println("URL does not contain parameter 'i'. Please ensure the URL includes 'i' or use another mode.")
+			//return nil
+		} else {
+			for trackNum, track := range meta.Data[0].Relationships.Tracks.Data {
+				trackNum++
+				if urlArg_i == track.ID {
+					selected = append(selected, trackNum)
+					break
+				}
+			}
+		}
+		if len(selected) == 0 {
+			return nil, nil
+		}
+	} else if !r.dl_select {
+		selected = arr
+	} else {
+		var data [][]string
+		for trackNum, track := range meta.Data[0].Relationships.Tracks.Data {
+			trackNum++
+			var trackName string
+			if meta.Data[0].Type == "albums" {
+				trackName = fmt.Sprintf("%02d. %s", track.Attributes.TrackNumber, track.Attributes.Name)
+			} else {
+				trackName = fmt.Sprintf("%s - %s", track.Attributes.Name, track.Attributes.ArtistName)
+			}
+			data = append(data, []string{fmt.Sprint(trackNum),
+				trackName,
+				track.Attributes.ContentRating,
+				track.Type})
+
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"", "Track Name", "Rating", "Type"})
+		//table.SetFooter([]string{"", "", "Footer", "Footer4"})
+		table.SetRowLine(false)
+		//table.SetAutoMergeCells(true)
+		table.SetCaption(meta.Data[0].Type == "albums", fmt.Sprintf("Storefront: %s, %d tracks missing", strings.ToUpper(storefront), meta.Data[0].Attributes.TrackCount-trackTotal))
+		table.SetHeaderColor(tablewriter.Colors{},
+			tablewriter.Colors{tablewriter.FgRedColor, tablewriter.Bold},
+			tablewriter.Colors{tablewriter.FgBlackColor, tablewriter.Bold},
+			tablewriter.Colors{tablewriter.FgBlackColor, tablewriter.Bold})
+
+		table.SetColumnColor(tablewriter.Colors{tablewriter.FgCyanColor},
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgRedColor},
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgBlackColor},
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgBlackColor})
+		for _, row := range data {
+			if row[2] == "explicit" {
+				row[2] = "E"
+			} else if row[2] == "clean" {
+				row[2] = "C"
+			} else {
+				row[2] = "None"
+			}
+			if row[3] == "music-videos" {
+				row[3] = "MV"
+			} else if row[3] == "songs" {
+				row[3] = "SONG"
+			}
+			table.Append(row)
+		}
+		//table.AppendBulk(data)
+		table.Render()
+		var choices []selector.Option
+		for _, track := range meta.Data[0].Relationships.Tracks.Data {
+			choices = append(choices, selector.Option{Label: fmt.Sprintf("%s - %s", track.Attributes.Name, track.Attributes.ArtistName), Rating: track.Attributes.ContentRating})
+		}
+		for _, i := range selector.Prompt(bufio.NewReader(os.Stdin), os.Stdout, "track options", choices) {
+			selected = append(selected, i+1)
+		}
+		fmt.Println("Selected options:", selected)
+	}
+	if len(r.editions) == 0 {
+		return r.ripEdition(ctx, meta, albumId, "", token, storefront, mediaUserToken, trackTotal, selected)
+	}
+	var results []structs.TrackResult
+	for _, edition := range r.editions {
+		logs.From(ctx).Info("saving edition", "edition", edition)
+		before := r.counter
+		editionResults, err := r.ripEdition(ctx, meta, albumId, edition, token, storefront, mediaUserToken, trackTotal, selected)
+		r.tallyEdition(edition, before)
+		results = append(results, editionResults...)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// ripEdition saves the selected tracks of meta as edition, "" without
+// --editions: folders, covers and then each track.
+func (r *run) ripEdition(ctx context.Context, meta *structs.AutoGenerated, albumId, edition, token, storefront, mediaUserToken string, trackTotal int, selected []int) ([]structs.TrackResult, error) {
+	if edition != "" {
+		ctx = logs.With(ctx, "edition", edition)
+	}
+	log := logs.From(ctx)
+	tiers := r.qualityChain(edition)
+	tier := tiers[0]
+	var singerFoldername string
+	if r.Config.ArtistFolderFormat != "" {
+		if isPlaylist(albumId) {
+			singerFoldername = strings.NewReplacer(
+				"{ArtistName}", "Apple Music",
+				"{ArtistId}", "",
+				"{UrlArtistName}", "Apple Music",
+			).Replace(r.Config.ArtistFolderFormat)
+		} else if len(meta.Data[0].Relationships.Artists.Data) > 0 {
+			singerFoldername = strings.NewReplacer(
+				"{UrlArtistName}", r.LimitString(meta.Data[0].Attributes.ArtistName),
+				"{ArtistName}", r.LimitString(meta.Data[0].Attributes.ArtistName),
+				"{ArtistId}", meta.Data[0].Relationships.Artists.Data[0].ID,
+			).Replace(r.Config.ArtistFolderFormat)
+		} else {
+			singerFoldername = strings.NewReplacer(
+				"{UrlArtistName}", r.LimitString(meta.Data[0].Attributes.ArtistName),
+				"{ArtistName}", r.LimitString(meta.Data[0].Attributes.ArtistName),
+				"{ArtistId}", "",
+			).Replace(r.Config.ArtistFolderFormat)
+		}
+		if strings.HasSuffix(singerFoldername, ".") {
+			singerFoldername = strings.ReplaceAll(singerFoldername, ".", "")
+		}
+		singerFoldername = strings.TrimSpace(singerFoldername)
+		if len(singerFoldername) > maxNameLength {
+			if len(meta.Data[0].Relationships.Artists.Data) > 0 && !isPlaylist(albumId) {
+				singerFoldername = meta.Data[0].Relationships.Artists.Data[0].ID
+			} else {
+				singerFoldername = "UnknownArtist"
+			}
+		}
+	}
+	var Quality string
+	// the folder is named after what the first track resolves to
+	if strings.Contains(r.Config.AlbumFolderFormat, "Quality") || (len(tiers) > 1 && strings.Contains(r.Config.AlbumFolderFormat, "Codec")) {
+		if tier == "aac-lc" {
+			Quality = "256kbps"
+		} else {
+			manifest1, err := r.getInfoFromAdam(ctx, meta.Data[0].Relationships.Tracks.Data[0].ID, token, storefront)
+			if err != nil {
+				log.Warn("failed to get manifest of the first track", "err", err)
+			} else {
+				if manifest1.Attributes.ExtendedAssetUrls.EnhancedHls == "" {
+					if r.lcFallback(tiers, edition) {
+						tier = "aac-lc"
+					}
+					Quality = "256kbps"
+					//fmt.Println("Unavailable.\n")
+				} else {
+					needCheck := false
+
+					if r.Config.GetM3u8Mode == "all" {
+						needCheck = true
+					} else if r.Config.GetM3u8Mode == "hires" && contains(meta.Data[0].Relationships.Tracks.Data[0].Attributes.AudioTraits, "hi-res-lossless") {
+						needCheck = true
+					}
+					var EnhancedHls_m3u8 string
+					if needCheck {
+						EnhancedHls_m3u8, _ = r.checkM3u8(meta.Data[0].Relationships.Tracks.Data[0].ID, "album")
+						if strings.HasSuffix(EnhancedHls_m3u8, ".m3u8") {
+							manifest1.Attributes.ExtendedAssetUrls.EnhancedHls = EnhancedHls_m3u8
+						}
+					}
+					firstVariant, firstTier, err := r.extractMedia(ctx, manifest1.Attributes.ExtendedAssetUrls.EnhancedHls, tiers, true)
+					if err != nil {
+						log.Warn("failed to extract quality from manifest", "err", err)
+					} else if firstTier == "atmos" {
+						// Atmos album folders are named after atmos-max
+						tier, Quality = firstTier, fmt.Sprintf("%dkbps", r.Config.AtmosMax-2000)
+					} else if firstTier != "" {
+						tier, Quality = firstTier, tierQuality(firstVariant, firstTier)
+					}
+				}
+			}
+		}
+	}
+	Codec := tierCodec(tier)
+	singerFolder := filepath.Join(r.saveFolderFor(tier), forbiddenNames.ReplaceAllString(singerFoldername, "_"))
+	stringsToJoin := []string{}
+	if meta.Data[0].Attributes.IsAppleDigitalMaster || meta.Data[0].Attributes.IsMasteredForItunes {
+		if r.Config.AppleMasterChoice != "" {
+			stringsToJoin = append(stringsToJoin, r.Config.AppleMasterChoice)
+		}
+	}
+	if meta.Data[0].Attributes.ContentRating == "explicit" {
+		if r.Config.ExplicitChoice != "" {
+			stringsToJoin = append(stringsToJoin, r.Config.ExplicitChoice)
+		}
+	}
+	if meta.Data[0].Attributes.ContentRating == "clean" {
+		if r.Config.CleanChoice != "" {
+			stringsToJoin = append(stringsToJoin, r.Config.CleanChoice)
+		}
+	}
+	Tag_string := strings.Join(stringsToJoin, " ")
+	albumFolder := r.albumFolderName(r.Config.AlbumFolderFormat, r.Config.PlaylistFolderFormat, meta, albumId, Quality, Codec, Tag_string)
+	sanAlbumFolder := filepath.Join(singerFolder, forbiddenNames.ReplaceAllString(albumFolder, "_"))
+	os.MkdirAll(sanAlbumFolder, os.ModePerm)
+	log.Info("saving album", "folder", albumFolder)
+	var mirrorFolder string
+	if r.Config.MirrorEnable {
+		mirrorAlbumFolder := r.albumFolderName(r.Config.MirrorAlbumFolderFormat, r.Config.MirrorPlaylistFormat, meta, albumId, r.Config.MirrorBitrate, strings.ToUpper(r.Config.MirrorCodec), Tag_string)
+		mirrorFolder = filepath.Join(r.Config.MirrorSaveFolder, forbiddenNames.ReplaceAllString(singerFoldername, "_"), forbiddenNames.ReplaceAllString(mirrorAlbumFolder, "_"))
+	}
+	//get artist cover
+	if r.Config.SaveArtistCover && !(isPlaylist(albumId)) {
+		if len(meta.Data[0].Relationships.Artists.Data) > 0 {
+			_, err := r.writeCover(ctx, singerFolder, "folder", meta.Data[0].Relationships.Artists.Data[0].Attributes.Artwork.Url)
+			if err != nil {
+				log.Warn("failed to write artist cover", "err", err)
+			}
+		}
+	}
+	//get album cover
+	covPath, err := r.albumCover(ctx, sanAlbumFolder, meta.Data[0].Attributes.Artwork.URL)
+	if err != nil {
+		log.Warn("failed to write cover", "err", err)
+	}
+	//get animated artwork
+	if r.Config.SaveAnimatedArtwork && meta.Data[0].Attributes.EditorialVideo.MotionDetailSquare.Video != "" {
+
+		// Download square version
+		motionvideoUrlSquare, err := r.extractVideo(ctx, meta.Data[0].Attributes.EditorialVideo.MotionDetailSquare.Video)
+		if err != nil {
+			log.Info("no square animated artwork", "err", err)
+		} else {
+			exists, err := fileExists(filepath.Join(sanAlbumFolder, "square_animated_artwork.mp4"))
+			if err != nil {
+				log.Warn("failed to check if animated artwork square exists", "err", err)
+			}
+			if exists {
+				log.Info("square animated artwork already exists")
+			} else {
+				cmd := exec.CommandContext(ctx, "ffmpeg", "-loglevel", "quiet", "-y", "-i", motionvideoUrlSquare, "-c", "copy", filepath.Join(sanAlbumFolder, "square_animated_artwork.mp4"))
+				if err := cmd.Run(); err != nil {
+					log.Warn("failed to download animated artwork square", "err", err)
+				} else {
+					log.Info("square animated artwork saved")
+				}
+			}
+		}
+
+		if r.Config.EmbyAnimatedArtwork {
+			// Convert square version to gif
+			cmd3 := exec.CommandContext(ctx, "ffmpeg", "-i", filepath.Join(sanAlbumFolder, "square_animated_artwork.mp4"), "-vf", "scale=440:-1", "-r", "24", "-f", "gif", filepath.Join(sanAlbumFolder, "folder.jpg"))
+			if err := cmd3.Run(); err != nil {
+				log.Warn("failed to convert animated artwork square to gif", "err", err)
+			}
+		}
+
+		// Download tall version
+		motionvideoUrlTall, err := r.extractVideo(ctx, meta.Data[0].Attributes.EditorialVideo.MotionDetailTall.Video)
+		if err != nil {
+			log.Info("no tall animated artwork", "err", err)
+		} else {
+			exists, err := fileExists(filepath.Join(sanAlbumFolder, "tall_animated_artwork.mp4"))
+			if err != nil {
+				log.Warn("failed to check if animated artwork tall exists", "err", err)
+			}
+			if exists {
+				log.Info("tall animated artwork already exists")
+			} else {
+				cmd := exec.CommandContext(ctx, "ffmpeg", "-loglevel", "quiet", "-y", "-i", motionvideoUrlTall, "-c", "copy", filepath.Join(sanAlbumFolder, "tall_animated_artwork.mp4"))
+				if err := cmd.Run(); err != nil {
+					log.Warn("failed to download animated artwork tall", "err", err)
+				} else {
+					log.Info("tall animated artwork saved")
+				}
+			}
+		}
+	}
+	if r.sync_mode && isPlaylist(albumId) {
+		r.syncManifest, err = playlist.Load(sanAlbumFolder)
+		if err != nil {
+			return nil, err
+		}
+		r.syncFolder = sanAlbumFolder
+		r.syncStaged = make(map[string]string)
+		defer func() { r.syncManifest, r.syncStaged = nil, nil }()
+		if err := r.syncPrepare(meta, sanAlbumFolder); err != nil {
+			return nil, err
+		}
+	}
+	var results []structs.TrackResult
+	for trackNum, track := range meta.Data[0].Relationships.Tracks.Data {
+		trackNum++
+		if ctx.Err() != nil {
+			// the tracks done so far still go into the sync manifest
+			break
+		}
+		if contains(r.okDict[okKey(albumId, edition)], track.ID) {
+			//fmt.Println("已完成直接跳过.\n")
+			r.counter.Add(structs.TrackSuccess)
+			continue
+		}
+		if isInArray(selected, trackNum) {
+			result := r.downloadTrack(ctx, trackNum, trackTotal, meta, track, albumId, edition, token, storefront, mediaUserToken, sanAlbumFolder, Codec, covPath, mirrorFolder, singerFoldername, Tag_string)
+			r.counter.Add(result.Status)
+			r.runErr = failure.Worst(r.runErr, result.Err)
+			results = append(results, result)
+			progress.From(ctx).TrackFinished(result)
+			if r.trackDone != nil {
+				r.trackDone(result)
+			}
+		}
+	}
+	if r.syncManifest != nil {
+		r.syncUnstage(sanAlbumFolder)
+		r.syncManifest.Total = trackTotal
+		if err := r.syncManifest.Save(sanAlbumFolder); err != nil {
+			return results, err
+		}
+	}
+	if ctx.Err() != nil {
+		return results, ctx.Err()
+	}
+	if formats := r.playlistFormats(); len(formats) > 0 && !r.lyrics_only && !r.cover_art_only {
+		if err := r.writePlaylistFiles(meta, okKey(albumId, edition), sanAlbumFolder, formats); err != nil {
+			log.Warn("failed to write playlist file", "err", err)
+		}
+	}
+	return results, nil
+}
+
+func (r *run) writeMP4Tags(trackPath, lrc string, meta *structs.AutoGenerated, trackNum, trackTotal int, info *audioinfo.Info, tier string) error {
+	index := trackNum - 1
+
+	t := &mp4tag.MP4Tags{
+		Title:      meta.Data[0].Relationships.Tracks.Data[index].Attributes.Name,
+		TitleSort:  meta.Data[0].Relationships.Tracks.Data[index].Attributes.Name,
+		Artist:     meta.Data[0].Relationships.Tracks.Data[index].Attributes.ArtistName,
+		ArtistSort: meta.Data[0].Relationships.Tracks.Data[index].Attributes.ArtistName,
+		Custom: map[string]string{
+			"PERFORMER":   meta.Data[0].Relationships.Tracks.Data[index].Attributes.ArtistName,
+			"RELEASETIME": meta.Data[0].Relationships.Tracks.Data[index].Attributes.ReleaseDate,
+			"ISRC":        meta.Data[0].Relationships.Tracks.Data[index].Attributes.Isrc,
+			"LABEL":       meta.Data[0].Attributes.RecordLabel,
+			"UPC":         meta.Data[0].Attributes.Upc,
+		},
+		Composer:     meta.Data[0].Relationships.Tracks.Data[index].Attributes.ComposerName,
+		ComposerSort: meta.Data[0].Relationships.Tracks.Data[index].Attributes.ComposerName,
+		Date:         meta.Data[0].Attributes.ReleaseDate,
+		CustomGenre:  meta.Data[0].Relationships.Tracks.Data[index].Attributes.GenreNames[0],
+		Copyright:    meta.Data[0].Attributes.Copyright,
+		Publisher:    meta.Data[0].Attributes.RecordLabel,
+		Lyrics:       lrc,
+	}
+
+	if !isPlaylist(meta.Data[0].ID) {
+		albumID, err := strconv.ParseUint(meta.Data[0].ID, 10, 32)
+		if err != nil {
+			return err
+		}
+		t.ItunesAlbumID = int32(albumID)
+	}
+
+	if len(meta.Data[0].Relationships.Artists.Data) > 0 {
+		if len(meta.Data[0].Relationships.Tracks.Data[index].Relationships.Artists.Data) > 0 {
+			artistID, err := strconv.ParseUint(meta.Data[0].Relationships.Tracks.Data[index].Relationships.Artists.Data[0].ID, 10, 32)
+			if err != nil {
+				return err
+			}
+			t.ItunesArtistID = int32(artistID)
+		}
+	}
+
+	if isPlaylist(meta.Data[0].ID) && !r.Config.UseSongInfoForPlaylist {
+		t.DiscNumber = 1
+		t.DiscTotal = 1
+		t.TrackNumber = int16(trackNum)
+		t.TrackTotal = int16(trackTotal)
+		t.Album = meta.Data[0].Attributes.Name
+		t.AlbumSort = meta.Data[0].Attributes.Name
+		t.AlbumArtist = meta.Data[0].Attributes.ArtistName
+		t.AlbumArtistSort = meta.Data[0].Attributes.ArtistName
+	} else if isPlaylist(meta.Data[0].ID) && r.Config.UseSongInfoForPlaylist {
+		t.DiscNumber = int16(meta.Data[0].Relationships.Tracks.Data[index].Attributes.DiscNumber)
+		t.DiscTotal = int16(meta.Data[0].Relationships.Tracks.Data[trackTotal-1].Attributes.DiscNumber)
+		t.TrackNumber = int16(meta.Data[0].Relationships.Tracks.Data[index].Attributes.TrackNumber)
+		t.TrackTotal = int16(trackTotal)
+		t.Album = meta.Data[0].Relationships.Tracks.Data[index].Attributes.AlbumName
+		t.AlbumSort = meta.Data[0].Relationships.Tracks.Data[index].Attributes.AlbumName
+		t.AlbumArtist = meta.Data[0].Relationships.Tracks.Data[index].Relationships.Albums.Data[0].Attributes.ArtistName
+		t.AlbumArtistSort = meta.Data[0].Relationships.Tracks.Data[index].Relationships.Albums.Data[0].Attributes.ArtistName
+	} else {
+		t.DiscNumber = int16(meta.Data[0].Relationships.Tracks.Data[index].Attributes.DiscNumber)
+		t.DiscTotal = int16(meta.Data[0].Relationships.Tracks.Data[trackTotal-1].Attributes.DiscNumber)
+		t.TrackNumber = int16(meta.Data[0].Relationships.Tracks.Data[index].Attributes.TrackNumber)
+		t.TrackTotal = int16(trackTotal)
+		t.Album = meta.Data[0].Relationships.Tracks.Data[index].Attributes.AlbumName
+		t.AlbumSort = meta.Data[0].Relationships.Tracks.Data[index].Attributes.AlbumName
+		t.AlbumArtist = meta.Data[0].Attributes.ArtistName
+		t.AlbumArtistSort = meta.Data[0].Attributes.ArtistName
+	}
+
+	if meta.Data[0].Relationships.Tracks.Data[index].Attributes.ContentRating == "explicit" {
+		t.ItunesAdvisory = mp4tag.ItunesAdvisoryExplicit
+	} else if meta.Data[0].Relationships.Tracks.Data[index].Attributes.ContentRating == "clean" {
+		t.ItunesAdvisory = mp4tag.ItunesAdvisoryClean
+	} else {
+		t.ItunesAdvisory = mp4tag.ItunesAdvisoryNone
+	}
+
+	// Add Album URL and Artist URL to custom tags
+	if len(meta.Data[0].Relationships.Tracks.Data[index].Relationships.Albums.Data) > 0 {
+		albumURL := meta.Data[0].Relationships.Tracks.Data[index].Relationships.Albums.Data[0].Attributes.URL
+		t.Custom["ALBUM_URL"] = albumURL
+
+		if len(meta.Data[0].Relationships.Tracks.Data[index].Relationships.Artists.Data) > 0 {
+			artistID := meta.Data[0].Relationships.Tracks.Data[index].Relationships.Artists.Data[0].ID
+			storefront, err := getStorefrontFromURL(albumURL)
+			if err == nil {
+				artistURL := fmt.Sprintf("https://music.apple.com/%s/artist/%s", storefront, artistID)
+				t.Custom["ARTIST_URL"] = artistURL
+			}
+		}
+	}
+
+	// What was actually downloaded, so stereo, 5.1 and Atmos copies can be told apart
+	if info != nil {
+		t.Custom["AUDIO_FORMAT"] = info.Format()
+		t.Custom["CHANNELS"] = info.ChannelsString()
+		if info.Bitrate > 0 {
+			t.Custom["BITRATE"] = strconv.Itoa(info.Bitrate)
+		}
+		if info.SampleRate > 0 {
+			t.Custom["SAMPLE_RATE"] = strconv.Itoa(info.SampleRate)
+		}
+		if info.BitDepth > 0 {
+			t.Custom["BIT_DEPTH"] = strconv.Itoa(info.BitDepth)
+		}
+		if info.Objects > 0 {
+			t.Custom["ATMOS_OBJECTS"] = strconv.Itoa(info.Objects)
+		}
+	}
+	// the quality-preference entry this track was downloaded as
+	if tier != "" {
+		t.Custom["QUALITY"] = tier
+	}
+
+	mp4, err := mp4tag.Open(trackPath)
+	if err != nil {
+		return err
+	}
+	defer mp4.Close()
+	err = mp4.Write(t, []string{})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// artistUrls lists the albums and music videos of the artist at artistUrl
+// to download, and points artist-folder-format at the artist.
+func (r *run) artistUrls(ctx context.Context, artistUrl string, token string) ([]string, error) {
+	urlArtistName, urlArtistID, err := r.getUrlArtistName(ctx, artistUrl, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get artist name: %w", err)
+	}
+	r.Config.ArtistFolderFormat = strings.NewReplacer(
+		"{UrlArtistName}", r.LimitString(urlArtistName),
+		"{ArtistId}", urlArtistID,
+	).Replace(r.Config.ArtistFolderFormat)
+	albumArgs, err := r.checkArtist(ctx, artistUrl, token, "albums")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get artist albums: %w", err)
+	}
+	mvArgs, err := r.checkArtist(ctx, artistUrl, token, "music-videos")
+	if err != nil {
+		slog.Warn("failed to get artist music videos", "url", artistUrl, "err", err)
+	}
+	return append(albumArgs, mvArgs...), nil
+}
+
+// artistCoverUrls saves the artist image of the artist at artistUrl for
+// cover-art mode and lists its albums, whose covers are saved next.
+func (r *run) artistCoverUrls(ctx context.Context, artistUrl string, token string) ([]string, error) {
+	if err := r.downloadArtistCover(ctx, artistUrl, token); err != nil {
+		slog.Warn("failed to download artist cover image, continuing with album covers", "url", artistUrl, "err", err)
+	} else {
+		slog.Info("artist cover image saved", "url", artistUrl)
+	}
+	urlArtistName, urlArtistID, err := r.getUrlArtistName(ctx, artistUrl, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get artist information: %w", err)
+	}
+	slog.Info("found artist", "name", urlArtistName, "id", urlArtistID)
+	r.Config.ArtistFolderFormat = strings.NewReplacer(
+		"{UrlArtistName}", r.LimitString(urlArtistName),
+		"{ArtistId}", urlArtistID,
+	).Replace(r.Config.ArtistFolderFormat)
+	albumArgs, err := r.checkArtist(ctx, artistUrl, token, "albums")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get artist albums: %w", err)
+	}
+	if len(albumArgs) == 0 {
+		slog.Warn("no albums found for artist", "url", artistUrl)
+		return nil, nil
+	}
+	slog.Info("saving album covers", "albums", len(albumArgs))
+	return albumArgs, nil
+}
+
+// downloadUrl saves what an album, playlist, song or music video URL
+// points to and returns how each track ended.
+func (r *run) downloadUrl(ctx context.Context, urlRaw string, token string) ([]structs.TrackResult, error) {
+	ref, err := amurl.Parse(urlRaw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %s: %w", urlRaw, err)
+	}
+	//mv dl dev
+	if ref.Kind == amurl.MusicVideo {
+		if r.debug_mode {
+			return nil, nil
+		}
+		storefront, mvId := r.checkRef(urlRaw, amurl.MusicVideo)
+		result := r.downloadMusicVideo(ctx, mvId, storefront, token)
+		r.counter.Add(result.Status)
+		r.runErr = failure.Worst(r.runErr, result.Err)
+		progress.From(ctx).TrackFinished(result)
+		if r.trackDone != nil {
+			r.trackDone(result)
+		}
+		return []structs.TrackResult{result}, nil
+	}
+	if ref.Kind == amurl.Song {
+		urlRaw, err = r.getUrlSong(ctx, urlRaw, token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get song info: %w", err)
+		}
+		// a /song/ URL turns on single-song mode for itself only
+		songMode := r.dl_song
+		r.dl_song = true
+		defer func() { r.dl_song = songMode }()
+		ref, _ = amurl.Parse(urlRaw)
+	}
+	storefront, albumId, err := r.refAlbum(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %s", urlRaw)
+	}
+	return r.rip(ctx, albumId, token, storefront, r.Config.MediaUserToken, ref.TrackID)
+}
+
+// downloadMusicVideo saves a music video on its own, outside any album.
+func (r *run) downloadMusicVideo(ctx context.Context, mvId string, storefront string, token string) structs.TrackResult {
+	result := structs.TrackResult{Number: 1, ID: mvId}
+	progress.From(ctx).TrackStarted(progress.Track{Number: 1, Total: 1, ID: mvId})
+	// Skip if the skip_mv flag is enabled
+	if r.skip_mv {
+		logs.From(ctx).Info("skipping music video, skip-mv is set")
+		return result.End(structs.TrackSuccess, nil)
+	}
+	if len(r.Config.MediaUserToken) <= 50 {
+		logs.From(ctx).Warn("media-user-token is not set, skipping music video")
+		return result.End(structs.TrackSuccess, nil)
+	}
+	if _, err := exec.LookPath("mp4decrypt"); err != nil {
+		logs.From(ctx).Warn("mp4decrypt is not found, skipping music video")
+		return result.End(structs.TrackSuccess, nil)
+	}
+	mvSaveDir := strings.NewReplacer(
+		"{ArtistName}", "",
+		"{UrlArtistName}", "",
+		"{ArtistId}", "",
+	).Replace(r.Config.ArtistFolderFormat)
+	if mvSaveDir != "" {
+		mvSaveDir = filepath.Join(r.Config.AlacSaveFolder, forbiddenNames.ReplaceAllString(mvSaveDir, "_"))
+	} else {
+		mvSaveDir = r.Config.AlacSaveFolder
+	}
+	err := r.mvDownloader(ctx, mvId, mvSaveDir, token, storefront, r.Config.MediaUserToken, nil)
+	if err != nil {
+		warn(ctx, "failed to dl MV", err)
+		return result.End(structs.TrackError, err)
+	}
+	return result.End(structs.TrackSuccess, nil)
+}
+
+// serveApi runs the download queue behind a local HTTP API until ctx is
+// cancelled. It fails when the queue file can't be read or the address
+// can't be listened on.
+func (r *run) serveApi(ctx context.Context, token string) error {
+	if r.Config.ServeAddress == "" {
+		r.Config.ServeAddress = "127.0.0.1:8080"
+	}
+	if r.Config.ServeQueueFile == "" {
+		r.Config.ServeQueueFile = "serve-queue.json"
+	}
+	// nobody is there to answer prompts
+	r.dl_select, r.artist_select, r.page_all = false, true, true
+	queue, err := server.Open(r.Config.ServeQueueFile, func(ctx context.Context, job server.Job, report func(structs.TrackResult)) error {
+		return r.serveJob(ctx, job, token, report)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load serve queue %s: %w", r.Config.ServeQueueFile, err)
+	}
+	worked := make(chan struct{})
+	go func() {
+		queue.Work(ctx)
+		close(worked)
+	}()
+	srv := &http.Server{Addr: r.Config.ServeAddress, Handler: queue.Handler()}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	slog.Info("listening", "url", "http://"+r.Config.ServeAddress)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve on %s: %w", r.Config.ServeAddress, err)
+	}
+	// the running job goes back to the queue file before exiting
+	<-worked
+	return nil
+}
+
+// serveJob downloads the URL of a job with its options, the same way as
+// the command line does. The job runs on a copy of r with clean counts,
+// like a Downloader call, so r is left as it was.
+func (r *run) serveJob(ctx context.Context, job server.Job, token string, report func(structs.TrackResult)) error {
+	jr := *r
+	jr.reset()
+	jr.dl_atmos, jr.dl_aac = job.Options.Atmos, job.Options.AAC
+	if len(job.Options.Tracks) > 0 {
+		jr.trackPicks = job.Options.Tracks
+	}
+	jr.trackDone = report
+	return jr.downloadJob(ctx, job.URL, token)
+}
+
+// downloadJob downloads the URL of a serve job, an artist as its albums.
+func (r *run) downloadJob(ctx context.Context, jobUrl string, token string) error {
+	urls := []string{jobUrl}
+	if ref, _ := amurl.Parse(jobUrl); ref.Kind == amurl.Artist {
+		var err error
+		urls, err = r.artistUrls(ctx, jobUrl, token)
+		if err != nil {
+			return err
+		}
+	}
+	urls = r.resolveIdentifiers(ctx, r.resolveLibrary(ctx, r.expandPages(ctx, urls, token), token), token)
+	if len(urls) == 0 {
+		return errors.New("nothing to download")
+	}
+	var errs []error
+	for _, urlRaw := range urls {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, err := r.downloadUrl(ctx, urlRaw, token); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// watchArtists downloads the albums of the artists in watch-artists that
+// were not seen before, every watch-interval minutes or once with --once.
+// With --once the error is the worst failure of the check, otherwise it
+// returns nil when ctx is cancelled.
+func (r *run) watchArtists(ctx context.Context, token string) error {
+	if len(r.Config.WatchArtists) == 0 {
+		return errors.New("no artists to watch, add them to watch-artists in config.yaml")
+	}
+	if r.Config.WatchInterval <= 0 {
+		r.Config.WatchInterval = 360
+	}
+	if r.Config.WatchStateFile == "" {
+		r.Config.WatchStateFile = "watch-state.json"
+	}
+	for {
+		state, err := watch.Load(r.Config.WatchStateFile)
+		if err != nil {
+			return fmt.Errorf("failed to read watch state %s: %w", r.Config.WatchStateFile, err)
+		}
+		for _, entry := range r.Config.WatchArtists {
+			if ctx.Err() != nil {
+				break
+			}
+			r.checkWatchedArtist(ctx, entry, state, token)
+		}
+		if r.counter.Total > 0 {
+			slog.Info("watch check finished", "completed", r.counter.Success, "total", r.counter.Total, "warnings", r.counter.Unavailable+r.counter.NotSong, "errors", r.counter.Error)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if r.watch_once {
+			return r.runErr
+		}
+		r.reset()
+		slog.Info("next check", "in", time.Duration(r.Config.WatchInterval)*time.Minute)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Duration(r.Config.WatchInterval) * time.Minute):
+		}
+	}
+}
+
+// checkWatchedArtist downloads the new albums of one watch-artists entry,
+// an artist URL or an ID in watch-storefront. The first check only
+// records the discography.
+func (r *run) checkWatchedArtist(ctx context.Context, entry string, state *watch.State, token string) {
+	storefront, artistId := r.checkRef(entry, amurl.Artist)
+	if artistId == "" {
+		storefront, artistId = r.Config.WatchStorefront, entry
+	}
+	releases, err := r.getArtistReleases(ctx, storefront, artistId, "albums", token)
+	if err != nil {
+		slog.Warn("failed to check artist", "artist", artistId, "err", err)
+		return
+	}
+	if !state.Watched(artistId) {
+		for _, release := range releases {
+			state.Add(artistId, release.ID)
+		}
+		if err := state.Save(r.Config.WatchStateFile); err != nil {
+			slog.Error("failed to save watch state", "path", r.Config.WatchStateFile, "err", err)
+		}
+		slog.Info("watching artist", "id", artistId, "known", len(releases))
+		return
+	}
+	kept, _ := r.artistFilter.Apply(releases)
+	wanted := make(map[string]bool)
+	for _, release := range kept {
+		wanted[release.ID] = true
+	}
+	for _, release := range releases {
+		if ctx.Err() != nil {
+			break
+		}
+		if state.Known(artistId, release.ID) {
+			continue
+		}
+		// filtered out releases are remembered so they are not offered again
+		if !wanted[release.ID] {
+			state.Add(artistId, release.ID)
+			continue
+		}
+		slog.Info("new release", "artist", release.ArtistName, "name", release.Name, "date", release.ReleaseDate)
+		failed := r.counter.Error
+		_, err := r.rip(ctx, release.ID, token, storefront, r.Config.MediaUserToken, "")
+		if err != nil && ctx.Err() == nil {
+			r.runErr = failure.Worst(r.runErr, err)
+		}
+		if err != nil || r.counter.Error > failed {
+			if err == nil {
+				err = errors.New("some tracks failed")
+			}
+			slog.Error("album failed, will retry on the next check", "artist", artistId, "album", release.ID, "err", err)
+			continue
+		}
+		state.Add(artistId, release.ID)
+		if err := state.Save(r.Config.WatchStateFile); err != nil {
+			slog.Error("failed to save watch state", "path", r.Config.WatchStateFile, "err", err)
+		}
+		err = watch.Notify(ctx, r.Config.WatchNotifyCommand, r.Config.WatchNotifyWebhook, watch.Release{
+			ArtistId:    artistId,
+			ArtistName:  release.ArtistName,
+			AlbumId:     release.ID,
+			AlbumName:   release.Name,
+			ReleaseDate: release.ReleaseDate,
+			URL:         release.URL,
+		})
+		if err != nil {
+			slog.Warn("failed to notify", "album", release.ID, "err", err)
+		}
+	}
+	if err := state.Save(r.Config.WatchStateFile); err != nil {
+		slog.Error("failed to save watch state", "path", r.Config.WatchStateFile, "err", err)
+	}
+}
+
+// browseArtist shows the discography of the artist at artistUrl full
+// screen and downloads the tracks queued from it with rip.
+func (r *run) browseArtist(ctx context.Context, artistUrl string, token string) error {
+	storefront, artistId := r.checkRef(artistUrl, amurl.Artist)
+	urlArtistName, urlArtistID, err := r.getUrlArtistName(ctx, artistUrl, token)
+	if err != nil {
+		return err
+	}
+	r.Config.ArtistFolderFormat = strings.NewReplacer(
+		"{UrlArtistName}", r.LimitString(urlArtistName),
+		"{ArtistId}", urlArtistID,
+	).Replace(r.Config.ArtistFolderFormat)
+	releases, err := r.getArtistReleases(ctx, storefront, artistId, "albums", token)
+	if err != nil {
+		return err
+	}
+	kept, _ := r.artistFilter.Apply(releases)
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].ReleaseDate < kept[j].ReleaseDate })
+	app := &tui.App{Title: urlArtistName}
+	for _, release := range kept {
+		app.Releases = append(app.Releases, tui.Release{ID: release.ID, Name: release.Name, Date: release.ReleaseDate, Rating: release.ContentRating})
+	}
+	app.Tracks = func(release tui.Release) ([]tui.Track, error) {
+		meta, err := r.getMeta(ctx, release.ID, token, storefront)
+		if err != nil {
+			return nil, err
+		}
+		var tracks []tui.Track
+		for trackNum, track := range meta.Data[0].Relationships.Tracks.Data {
+			row := tui.Track{Number: trackNum + 1, Name: track.Attributes.Name, Rating: track.Attributes.ContentRating, Type: track.Type}
+			if track.Attributes.ArtistName != meta.Data[0].Attributes.ArtistName {
+				row.Artist = track.Attributes.ArtistName
+			}
+			if track.Type == "songs" {
+				row.Traits = tui.Traits(track.Attributes.AudioTraits)
+			}
+			tracks = append(tracks, row)
+		}
+		return tracks, nil
+	}
+	app.Download = func(job tui.Job, update func(tui.Update)) error {
+		picks := make([]int, 0, len(job.Tracks))
+		for _, track := range job.Tracks {
+			picks = append(picks, track.Number)
+		}
+		r.trackPicks = picks
+		defer func() { r.trackPicks = nil }()
+		_, err := r.rip(progress.With(ctx, tui.NewObserver(update)), job.AlbumID, token, storefront, r.Config.MediaUserToken, "")
+		return err
+	}
+	// log lines on stderr would draw over the screen
+	defer logs.Mute()()
+	screen, err := tui.Open(os.Stdin, os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer screen.Close()
+	return app.Run(screen)
+}
+
+// qualityReport checks what the tracks of the given albums, playlists, songs
+// and artist discographies are available in, without downloading anything:
+// one report for each of urls, an artist with all of its albums.
+func (r *run) qualityReport(ctx context.Context, urls []string, token string) ([]quality.Report, error) {
+	var reports []quality.Report
+	for _, urlRaw := range urls {
+		var report quality.Report
+		albumUrls := []string{urlRaw}
+		if ref, _ := amurl.Parse(urlRaw); ref.Kind == amurl.Artist {
+			name, id, err := r.getUrlArtistName(ctx, urlRaw, token)
+			if err != nil {
+				slog.Warn("failed to get artist", "url", urlRaw, "err", err)
+				continue
+			}
+			artistAlbums, err := r.checkArtist(ctx, urlRaw, token, "albums")
+			if err != nil {
+				slog.Warn("failed to get artist albums", "url", urlRaw, "err", err)
+				continue
+			}
+			report.Name, report.ID = name, id
+			albumUrls = artistAlbums
+		}
+		for _, albumUrl := range albumUrls {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			album, err := r.albumQuality(ctx, albumUrl, token)
+			if err != nil {
+				slog.Warn("failed to check quality", "url", albumUrl, "err", err)
+				continue
+			}
+			report.Albums = append(report.Albums, *album)
+		}
+		if len(report.Albums) == 0 {
+			continue
+		}
+		if report.ID == "" {
+			report.Name, report.ID = report.Albums[0].Name, report.Albums[0].ID
+		}
+		report.Rollup()
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// albumQuality checks every song of the album or playlist at urlRaw, or only
+// the song for song URLs.
+func (r *run) albumQuality(ctx context.Context, urlRaw string, token string) (*quality.AlbumReport, error) {
+	ref, err := amurl.Parse(urlRaw)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Kind == amurl.Song {
+		urlRaw, err = r.getUrlSong(ctx, urlRaw, token)
+		if err != nil {
+			return nil, err
+		}
+		ref, _ = amurl.Parse(urlRaw)
+	}
+	storefront, albumId, err := r.refAlbum(ref)
+	if err != nil {
+		return nil, err
+	}
+	onlyTrack := ref.TrackID
+	meta, err := r.getMeta(ctx, albumId, token, storefront)
+	if err != nil {
+		return nil, err
+	}
+	report := &quality.AlbumReport{Name: meta.Data[0].Attributes.Name, ID: albumId}
+	logs.From(ctx).Info("checking", "name", report.Name)
+	for trackNum, track := range meta.Data[0].Relationships.Tracks.Data {
+		trackNum++
+		if track.Type != "songs" || (onlyTrack != "" && track.ID != onlyTrack) {
+			continue
+		}
+		row := quality.TrackReport{Number: trackNum, Name: track.Attributes.Name, ID: track.ID}
+		manifest, err := r.getInfoFromAdam(ctx, track.ID, token, storefront)
+		if err != nil {
+			logs.From(ctx).Warn("failed to get manifest", "track", trackNum, "err", err)
+		} else if manifest.Attributes.ExtendedAssetUrls.EnhancedHls != "" {
+			m3u8Url := manifest.Attributes.ExtendedAssetUrls.EnhancedHls
+			if r.Config.GetM3u8Mode == "all" || (r.Config.GetM3u8Mode == "hires" && contains(track.Attributes.AudioTraits, "hi-res-lossless")) {
+				if deviceM3u8, err := r.checkM3u8(track.ID, "song"); err == nil && strings.HasSuffix(deviceM3u8, ".m3u8") {
+					m3u8Url = deviceM3u8
+				}
+			}
+			variants, err := quality.Fetch(ctx, m3u8Url)
+			if err != nil {
+				logs.From(ctx).Warn("failed to read master playlist", "track", trackNum, "err", err)
+			} else {
+				row.Formats = quality.Best(variants)
+			}
+		}
+		report.Tracks = append(report.Tracks, row)
+	}
+	return report, nil
+}
+
+func (r *run) mvDownloader(ctx context.Context, adamID string, saveDir string, token string, storefront string, mediaUserToken string, meta *structs.AutoGenerated) error {
+	ctx = logs.With(ctx, "mv", adamID)
+	log := logs.From(ctx)
+	// Skip MV download in lyrics-only mode or if skip-mv is enabled
+	if r.lyrics_only {
+		log.Info("skipping music video in lyrics-only mode")
+		return nil
+	}
+
+	// Skip if skip_mv flag is enabled
+	if r.skip_mv {
+		log.Info("skipping music video, skip-mv is set")
+		return nil
+	}
+
+	// Skip MV download in cover-art-only mode
+	if r.cover_art_only {
+		log.Info("skipping music video in cover-art-only mode")
+		return nil
+	}
+
+	MVInfo, err := r.getMVInfoFromAdam(ctx, adamID, token, storefront)
+	if err != nil {
+		warn(ctx, "failed to get MV manifest", err)
+		return nil
+	}
+
+	// For single music video downloads, set saveDir to artist's folder
+	if meta == nil {
+		artistName := MVInfo.Data[0].Attributes.ArtistName
+		artistId := "" // Relationships field is not available, so artistId is empty
+		singerFoldername := strings.NewReplacer(
+			"{ArtistName}", r.LimitString(artistName),
+			"{ArtistId}", artistId,
+			"{UrlArtistName}", r.LimitString(artistName),
+		).Replace(r.Config.ArtistFolderFormat)
+		if strings.HasSuffix(singerFoldername, ".") {
+			singerFoldername = strings.ReplaceAll(singerFoldername, ".", "")
+		}
+		singerFoldername = strings.TrimSpace(singerFoldername)
+		if len(singerFoldername) > maxNameLength {
+			singerFoldername = "UnknownArtist" // Fallback if name is too long and no artistId
+		}
+		saveDir = filepath.Join(r.Config.AlacSaveFolder, forbiddenNames.ReplaceAllString(singerFoldername, "_"))
+		os.MkdirAll(saveDir, os.ModePerm)
+	}
+
+	// Existing code continues here...
+	var trackTotal int
+	var trackNum int
+	var index int
+	if meta != nil {
+		trackTotal = len(meta.Data[0].Relationships.Tracks.Data)
+		for i, track := range meta.Data[0].Relationships.Tracks.Data {
+			if adamID == track.ID {
+				index = i
+				trackNum = i + 1
+			}
+		}
+	}
+
+	if strings.HasSuffix(saveDir, ".") {
+		saveDir = strings.ReplaceAll(saveDir, ".", "")
+	}
+	saveDir = strings.TrimSpace(saveDir)
+
+	vidPath := filepath.Join(saveDir, fmt.Sprintf("%s_vid.mp4", adamID))
+	audPath := filepath.Join(saveDir, fmt.Sprintf("%s_aud.mp4", adamID))
+	mvSaveName := fmt.Sprintf("%s (%s)", MVInfo.Data[0].Attributes.Name, adamID)
+	if meta != nil {
+		mvSaveName = fmt.Sprintf("%02d. %s", trackNum, MVInfo.Data[0].Attributes.Name)
+	}
+	mvFileName := fmt.Sprintf("%s.mp4", forbiddenNames.ReplaceAllString(mvSaveName, "_"))
+	if len(mvFileName) > maxNameLength {
+		mvFileName = fmt.Sprintf("%s.mp4", adamID)
+	}
+	mvOutPath := filepath.Join(saveDir, mvFileName)
+
+	log.Info("saving music video", "name", MVInfo.Data[0].Attributes.Name)
+
+	exists, _ := fileExists(mvOutPath)
+	if exists {
+		log.Info("music video already exists", "path", mvOutPath)
+		return nil
+	}
+
+	mvm3u8url, _, _ := runv3.GetWebplayback(ctx, adamID, token, mediaUserToken, true)
+	if mvm3u8url == "" {
+		return errors.New("media-user-token may wrong or expired")
+	}
+
+	os.MkdirAll(saveDir, os.ModePerm)
+	// Video
+	videom3u8url, _ := r.extractVideo(ctx, mvm3u8url)
+	videokeyAndUrls, _ := runv3.Run(ctx, adamID, videom3u8url, token, mediaUserToken, true)
+	_ = runv3.ExtMvData(ctx, videokeyAndUrls, vidPath)
+	// Audio
+	audiom3u8url, _ := r.extractMvAudio(ctx, mvm3u8url)
+	audiokeyAndUrls, _ := runv3.Run(ctx, adamID, audiom3u8url, token, mediaUserToken, true)
+	_ = runv3.ExtMvData(ctx, audiokeyAndUrls, audPath)
+	if ctx.Err() != nil {
+		os.Remove(vidPath)
+		os.Remove(audPath)
+		return ctx.Err()
+	}
+
+	// Tags
+	tags := []string{
+		"tool=",
+		fmt.Sprintf("artist=%s", MVInfo.Data[0].Attributes.ArtistName),
+		fmt.Sprintf("title=%s", MVInfo.Data[0].Attributes.Name),
+		fmt.Sprintf("genre=%s", MVInfo.Data[0].Attributes.GenreNames[0]),
+		fmt.Sprintf("created=%s", MVInfo.Data[0].Attributes.ReleaseDate),
+		fmt.Sprintf("ISRC=%s", MVInfo.Data[0].Attributes.Isrc),
+	}
+
+	if MVInfo.Data[0].Attributes.ContentRating == "explicit" {
+		tags = append(tags, "rating=1")
+	} else if MVInfo.Data[0].Attributes.ContentRating == "clean" {
+		tags = append(tags, "rating=2")
+	} else {
+		tags = append(tags, "rating=0")
+	}
+
+	if meta != nil {
+		if meta.Data[0].Type == "playlists" && !r.Config.UseSongInfoForPlaylist {
+			tags = append(tags, "disk=1/1")
+			tags = append(tags, fmt.Sprintf("album=%s", meta.Data[0].Attributes.Name))
+			tags = append(tags, fmt.Sprintf("track=%d", trackNum))
+			tags = append(tags, fmt.Sprintf("tracknum=%d/%d", trackNum, trackTotal))
+			tags = append(tags, fmt.Sprintf("album_artist=%s", meta.Data[0].Attributes.ArtistName))
+			tags = append(tags, fmt.Sprintf("performer=%s", meta.Data[0].Relationships.Tracks.Data[index].Attributes.ArtistName))
+			tags = append(tags, fmt.Sprintf("copyright=%s", meta.Data[0].Attributes.Copyright))
+			tags = append(tags, fmt.Sprintf("UPC=%s", meta.Data[0].Attributes.Upc))
+		} else {
+			tags = append(tags, fmt.Sprintf("album=%s", meta.Data[0].Relationships.Tracks.Data[index].Attributes.AlbumName))
+			tags = append(tags, fmt.Sprintf("disk=%d/%d", meta.Data[0].Relationships.Tracks.Data[index].Attributes.DiscNumber, meta.Data[0].Relationships.Tracks.Data[trackTotal-1].Attributes.DiscNumber))
+			tags = append(tags, fmt.Sprintf("track=%d", meta.Data[0].Relationships.Tracks.Data[index].Attributes.TrackNumber))
+			tags = append(tags, fmt.Sprintf("tracknum=%d/%d", meta.Data[0].Relationships.Tracks.Data[index].Attributes.TrackNumber, meta.Data[0].Attributes.TrackCount))
+			tags = append(tags, fmt.Sprintf("album_artist=%s", meta.Data[0].Attributes.ArtistName))
+			tags = append(tags, fmt.Sprintf("performer=%s", meta.Data[0].Relationships.Tracks.Data[index].Attributes.ArtistName))
+			tags = append(tags, fmt.Sprintf("copyright=%s", meta.Data[0].Attributes.Copyright))
+			tags = append(tags, fmt.Sprintf("UPC=%s", meta.Data[0].Attributes.Upc))
+		}
+	} else {
+		tags = append(tags, fmt.Sprintf("album=%s", MVInfo.Data[0].Attributes.AlbumName))
+		tags = append(tags, fmt.Sprintf("disk=%d", MVInfo.Data[0].Attributes.DiscNumber))
+		tags = append(tags, fmt.Sprintf("track=%d", MVInfo.Data[0].Attributes.TrackNumber))
+		tags = append(tags, fmt.Sprintf("tracknum=%d", MVInfo.Data[0].Attributes.TrackNumber))
+		tags = append(tags, fmt.Sprintf("performer=%s", MVInfo.Data[0].Attributes.ArtistName))
+	}
+
+	var covPath string
+	if true { // Assuming cover embedding is always enabled
+		thumbURL := MVInfo.Data[0].Attributes.Artwork.URL
+		baseThumbName := forbiddenNames.ReplaceAllString(mvSaveName, "_") + "_thumbnail"
+		covPath, err = r.writeCover(ctx, saveDir, baseThumbName, thumbURL)
+		if err != nil {
+			log.Warn("failed to save music video thumbnail", "err", err)
+		} else {
+			tags = append(tags, fmt.Sprintf("cover=%s", covPath))
+		}
+	}
+
+	tagsString := strings.Join(tags, ":")
+	muxCmd := exec.CommandContext(ctx, "MP4Box", "-itags", tagsString, "-quiet", "-add", vidPath, "-add", audPath, "-keep-utc", "-new", mvOutPath)
+	progress.From(ctx).StageChanged(progress.Tag)
+	if err := muxCmd.Run(); err != nil {
+		log.Error("failed to mux music video", "err", err)
+		os.Remove(mvOutPath)
+		return err
+	}
+
+	// Add custom MV_ID tag using mp4tag
+	mp4, err := mp4tag.Open(mvOutPath)
+	if err != nil {
+		log.Error("failed to open music video for tagging", "path", mvOutPath, "err", err)
+		return err
+	}
+	defer mp4.Close()
+
+	t := &mp4tag.MP4Tags{
+		Custom: map[string]string{
+			"MV_ID": adamID,
+		},
+	}
+	err = mp4.Write(t, []string{})
+	if err != nil {
+		log.Error("failed to write music video tags", "path", mvOutPath, "err", err)
+		return err
+	}
+
+	// Cleanup temporary files
+	defer os.Remove(vidPath)
+	defer os.Remove(audPath)
+	defer os.Remove(covPath)
+
+	return nil
+}
+
+func (r *run) extractMvAudio(ctx context.Context, c string) (string, error) {
+	MediaUrl, err := url.Parse(c)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	audioString := string(body)
+	from, listType, err := m3u8.DecodeFrom(strings.NewReader(audioString), true)
+	if err != nil || listType != m3u8.MASTER {
+		return "", errors.New("m3u8 not of media type")
+	}
+
+	audio := from.(*m3u8.MasterPlaylist)
+
+	var audioPriority = []string{"audio-atmos", "audio-ac3", "audio-stereo-256"}
+	if r.Config.MVAudioType == "ac3" {
+		audioPriority = []string{"audio-ac3", "audio-stereo-256"}
+	} else if r.Config.MVAudioType == "aac" {
+		audioPriority = []string{"audio-stereo-256"}
+	}
+
+	re := regexp.MustCompile(`_gr(\d+)_`)
+
+	type AudioStream struct {
+		URL     string
+		Rank    int
+		GroupID string
+	}
+	var audioStreams []AudioStream
+
+	for _, variant := range audio.Variants {
+		for _, audiov := range variant.Alternatives {
+			if audiov.URI != "" {
+				for _, priority := range audioPriority {
+					if audiov.GroupId == priority {
+						matches := re.FindStringSubmatch(audiov.URI)
+						if len(matches) == 2 {
+							var rank int
+							fmt.Sscanf(matches[1], "%d", &rank)
+							streamUrl, _ := MediaUrl.Parse(audiov.URI)
+							audioStreams = append(audioStreams, AudioStream{
+								URL:     streamUrl.String(),
+								Rank:    rank,
+								GroupID: audiov.GroupId,
+							})
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if len(audioStreams) == 0 {
+		return "", errors.New("no suitable audio stream found")
+	}
+
+	sort.Slice(audioStreams, func(i, j int) bool {
+		return audioStreams[i].Rank > audioStreams[j].Rank
+	})
+	logs.From(ctx).Debug("music video audio", "group", audioStreams[0].GroupID)
+	return audioStreams[0].URL, nil
+}
+
+func (r *run) checkM3u8(b string, f string) (string, error) {
+	var EnhancedHls string
+	if r.Config.GetM3u8FromDevice {
+		adamID := b
+		conn, err := net.Dial("tcp", r.Config.GetM3u8Port)
+		if err != nil {
+			slog.Warn("failed to connect to device", "address", r.Config.GetM3u8Port, "err", err)
+			return "none", err
+		}
+		defer conn.Close()
+		slog.Debug("connected to device", "address", r.Config.GetM3u8Port)
+
+		// Send the length of adamID and the adamID itself
+		adamIDBuffer := []byte(adamID)
+		lengthBuffer := []byte{byte(len(adamIDBuffer))}
+
+		// Write length and adamID to the connection
+		_, err = conn.Write(lengthBuffer)
+		if err != nil {
+			slog.Warn("failed to write length to device", "err", err)
+			return "none", err
+		}
+
+		_, err = conn.Write(adamIDBuffer)
+		if err != nil {
+			slog.Warn("failed to write adamID to device", "err", err)
+			return "none", err
+		}
+
+		// Read the response (URL) from the device
+		response, err := bufio.NewReader(conn).ReadBytes('\n')
+		if err != nil {
+			slog.Warn("failed to read response from device", "err", err)
+			return "none", err
+		}
+
+		// Trim any newline characters from the response
+
+		response = bytes.TrimSpace(response)
+		if len(response) > 0 {
+			slog.Debug("received m3u8 from device", "id", adamID, "url", string(response))
+			EnhancedHls = string(response)
+		} else {
+			slog.Warn("received an empty response from device", "id", adamID)
+		}
+	}
+	return EnhancedHls, nil
+}
+
+func formatAvailability(available bool, quality string) string {
+	if !available {
+		return "Not Available"
+	}
+	return quality
+}
+
+// extractMedia picks the stream for the first entry of tiers the master
+// playlist offers and returns it with the entry chosen. "aac-lc" is not in
+// the master playlist; reaching it returns aacLcVariant, which has no URI.
+func (r *run) extractMedia(ctx context.Context, b string, tiers []string, more_mode bool) (quality.Variant, string, error) {
+	variants, err := quality.Fetch(ctx, b)
+	if err != nil {
+		return quality.Variant{}, "", err
+	}
+	if r.debug_mode && more_mode {
+		fmt.Println("\nDebug: All Available Variants:")
+		var data [][]string
+		for _, variant := range variants {
+			data = append(data, []string{variant.Codec, variant.GroupID, fmt.Sprint(variant.Bandwidth)})
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Codec", "Audio", "Bandwidth"})
+		//table.SetFooter([]string{"", "", "Total", "$146.93"})
+		table.SetAutoMergeCells(true)
+		//table.SetAutoMergeCellsByColumnIndex([]int{1,2,3})
+		table.SetRowLine(true)
+		table.AppendBulk(data)
+		table.Render()
+
+		// best variant of each format; variants come sorted by bandwidth
+		var aac, lossless, hiRes, atmos, dolbyAudio *quality.Variant
+		for i := range variants {
+			variant := &variants[i]
+			switch {
+			case variant.Codec == "mp4a.40.2":
+				if aac == nil || variant.Bitrate > aac.Bitrate {
+					aac = variant
+				}
+			case variant.Kind() == "atmos":
+				if atmos == nil || variant.Bitrate > atmos.Bitrate {
+					atmos = variant
+				}
+			case variant.HiRes():
+				if hiRes == nil {
+					hiRes = variant
+				}
+			case variant.Codec == "alac":
+				if lossless == nil {
+					lossless = variant
+				}
+			case variant.Codec == "ac-3":
+				if dolbyAudio == nil {
+					dolbyAudio = variant
+				}
+			}
+		}
+
+		fmt.Println("Available Audio Formats:")
+		fmt.Println("------------------------")
+		fmt.Printf("AAC             : %s\n", describeVariant(aac))
+		fmt.Printf("Lossless        : %s\n", describeVariant(lossless))
+		fmt.Printf("Hi-Res Lossless : %s\n", describeVariant(hiRes))
+		fmt.Printf("Dolby Atmos     : %s\n", describeVariant(atmos))
+		fmt.Printf("Dolby Audio     : %s\n", describeVariant(dolbyAudio))
+		fmt.Println("------------------------")
+
+		return quality.Variant{}, "", nil
+	}
+	for _, tier := range tiers {
+		if tier == "aac-lc" {
+			return aacLcVariant, tier, nil
+		}
+		for _, variant := range variants {
+			if !r.tierMatches(variant, tier) {
+				continue
+			}
+			if r.debug_mode && !more_mode {
+				logs.From(ctx).Debug("found variant", "quality", tier, "group", variant.GroupID, "kbps", variant.Bandwidth/1000)
+			} else if !r.debug_mode && !more_mode {
+				if variant.Codec == "alac" {
+					logs.From(ctx).Info("quality", "bit_depth", variant.BitDepth, "sample_rate", variant.SampleRate)
+				} else {
+					logs.From(ctx).Info("quality", "group", variant.GroupID)
+				}
+			}
+			return variant, tier, nil
+		}
+	}
+	return quality.Variant{}, "", fmt.Errorf("%w: none of %s", failure.ErrNoLossless, strings.Join(tiers, ", "))
+}
+
+// tierQuality is the {Quality} value of variant downloaded as tier.
+func tierQuality(variant quality.Variant, tier string) string {
+	if tier == "aac-lc" {
+		return "256kbps"
+	}
+	return variant.Label()
+}
+
+// tierMatches reports whether variant is a stream of the quality-preference
+// entry tier within alac-max and atmos-max.
+func (r *run) tierMatches(variant quality.Variant, tier string) bool {
+	switch tier {
+	case "atmos":
+		// atmos-max is written like the group IDs, 2768 is 768 kbps
+		return variant.Kind() == "atmos" && variant.Bitrate <= r.Config.AtmosMax%1000
+	case "alac":
+		return variant.Codec == "alac" && variant.SampleRate <= r.Config.AlacMax
+	case "alac-hires":
+		return variant.HiRes() && variant.SampleRate <= r.Config.AlacMax
+	}
+	return variant.Kind() == tier
+}
+
+func describeVariant(variant *quality.Variant) string {
+	if variant == nil {
+		return formatAvailability(false, "")
+	}
+	return formatAvailability(true, variant.Describe())
+}
+func (r *run) extractVideo(ctx context.Context, c string) (string, error) {
+	MediaUrl, err := url.Parse(c)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	videoString := string(body)
+
+	from, listType, err := m3u8.DecodeFrom(strings.NewReader(videoString), true)
+	if err != nil || listType != m3u8.MASTER {
+		return "", errors.New("m3u8 not of media type")
+	}
+
+	video := from.(*m3u8.MasterPlaylist)
+
+	re := regexp.MustCompile(`_(\d+)x(\d+)`)
+
+	var streamUrl *url.URL
+	sort.Slice(video.Variants, func(i, j int) bool {
+		return video.Variants[i].AverageBandwidth > video.Variants[j].AverageBandwidth
+	})
+
+	maxHeight := r.Config.MVMax
+
+	for _, variant := range video.Variants {
+		matches := re.FindStringSubmatch(variant.URI)
+		if len(matches) == 3 {
+			height := matches[2]
+			var h int
+			_, err := fmt.Sscanf(height, "%d", &h)
+			if err != nil {
+				continue
+			}
+			if h <= maxHeight {
+				streamUrl, err = MediaUrl.Parse(variant.URI)
+				if err != nil {
+					return "", err
+				}
+				logs.From(ctx).Debug("music video", "resolution", variant.Resolution, "range", variant.VideoRange)
+				break
+			}
+		}
+	}
+
+	if streamUrl == nil {
+		return "", errors.New("no suitable video stream found")
+	}
+
+	return streamUrl.String(), nil
+}
+
+func (r *run) getInfoFromAdam(ctx context.Context, adamId string, token string, storefront string) (*structs.SongData, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", amurl.API(fmt.Sprintf("/v1/catalog/%s/songs/%s", storefront, adamId)), nil)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Set("extend", "extendedAssetUrls")
+	query.Set("include", "albums")
+	query.Set("l", r.Config.Language)
+	request.URL.RawQuery = query.Encode()
+
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	request.Header.Set("User-Agent", "iTunes/12.11.3 (Windows; Microsoft Windows 10 x64 Professional Edition (Build 19041); x64) AppleWebKit/7611.1022.4001.1 (dt:2)")
+	request.Header.Set("Origin", "https://music.apple.com")
+
+	do, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer do.Body.Close()
+	if do.StatusCode != http.StatusOK {
+		return nil, failure.CatalogResponse(do)
+	}
+
+	obj := new(structs.ApiResult)
+	err = json.NewDecoder(do.Body).Decode(&obj)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range obj.Data {
+		if d.ID == adamId {
+			return &d, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: song %s", failure.ErrNotInStorefront, adamId)
+}
+
+func (r *run) getMVInfoFromAdam(ctx context.Context, adamId string, token string, storefront string) (*structs.AutoGeneratedMusicVideo, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", amurl.API(fmt.Sprintf("/v1/catalog/%s/music-videos/%s", storefront, adamId)), nil)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Set("l", r.Config.Language)
+	request.URL.RawQuery = query.Encode()
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	request.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+	request.Header.Set("Origin", "https://music.apple.com")
+
+	do, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer do.Body.Close()
+	if do.StatusCode != http.StatusOK {
+		return nil, failure.CatalogResponse(do)
+	}
+
+	obj := new(structs.AutoGeneratedMusicVideo)
+	err = json.NewDecoder(do.Body).Decode(&obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+func getToken() (string, error) {
+	req, err := http.NewRequest("GET", "https://beta.music.apple.com", nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	regex := regexp.MustCompile(`/assets/index-legacy-[^/]+\.js`)
+	indexJsUri := regex.FindString(string(body))
+
+	req, err = http.NewRequest("GET", "https://beta.music.apple.com"+indexJsUri, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	regex = regexp.MustCompile(`eyJh([^"]*)`)
+	token := regex.FindString(string(body))
+
+	return token, nil
+}
+
+// New function to download artist cover image
+func (r *run) downloadArtistCover(ctx context.Context, artistUrl string, token string) error {
+	storefront, artistId := r.checkRef(artistUrl, amurl.Artist)
+	if artistId == "" {
+		return errors.New("Invalid artist URL: Could not extract artist ID from the URL")
+	}
+	if storefront == "" {
+		return errors.New("Invalid artist URL: Could not extract storefront from the URL")
+	}
+
+	slog.Debug("downloading artist cover", "id", artistId, "storefront", storefront)
+
+	// Get artist information
+	apiUrl := amurl.API(fmt.Sprintf("/v1/catalog/%s/artists/%s", storefront, artistId))
+	req, err := http.NewRequestWithContext(ctx, "GET", apiUrl, nil)
+	if err != nil {
+		return fmt.Errorf("Error creating API request: %w", err)
+	}
+
+	// Set headers
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+	req.Header.Set("Origin", "https://music.apple.com")
+
+	// Set query parameters
+	query := url.Values{}
+	query.Set("l", r.Config.Language)
+	query.Set("fields[artists]", "name,artwork")
+	req.URL.RawQuery = query.Encode()
+
+	// Make the request
+	do, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Network error while fetching artist data: %w", err)
+	}
+	defer do.Body.Close()
+
+	// Check HTTP status
+	if do.StatusCode != http.StatusOK {
+		return fmt.Errorf("API returned non-200 status: %s (HTTP %d)", do.Status, do.StatusCode)
+	}
+
+	// Decode response
+	obj := new(structs.AutoGeneratedArtist)
+	err = json.NewDecoder(do.Body).Decode(&obj)
+	if err != nil {
+		return fmt.Errorf("Error parsing artist data from API: %w", err)
+	}
+
+	// Check if we got data
+	if len(obj.Data) == 0 {
+		return errors.New("API returned empty data array: No artist data found")
+	}
+
+	// Check if artist attributes exist
+	if obj.Data[0].Attributes.Name == "" {
+		return errors.New("Artist name not found in API response")
+	}
+
+	// Check if artwork exists
+	if obj.Data[0].Attributes.Artwork.URL == "" {
+		return errors.New("Artist has no artwork URL in API response")
+	}
+
+	// Create artist folder
+	artistName := obj.Data[0].Attributes.Name
+	slog.Debug("found artist", "name", artistName, "id", obj.Data[0].ID)
+
+	singerFoldername := strings.NewReplacer(
+		"{UrlArtistName}", r.LimitString(artistName),
+		"{ArtistName}", r.LimitString(artistName),
+		"{ArtistId}", obj.Data[0].ID,
+	).Replace(r.Config.ArtistFolderFormat)
+
+	if strings.HasSuffix(singerFoldername, ".") {
+		singerFoldername = strings.ReplaceAll(singerFoldername, ".", "")
+	}
+	singerFoldername = strings.TrimSpace(singerFoldername)
+	if len(singerFoldername) > maxNameLength {
+		singerFoldername = obj.Data[0].ID
+		slog.Info("artist folder name too long, using the ID", "folder", singerFoldername)
+	}
+
+	singerFolder := filepath.Join(r.Config.AlacSaveFolder, forbiddenNames.ReplaceAllString(singerFoldername, "_"))
+	err = os.MkdirAll(singerFolder, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("Failed to create artist folder: %w", err)
+	}
+
+	// Download artist cover
+	artworkURL := obj.Data[0].Attributes.Artwork.URL
+	_, err = r.writeCover(ctx, singerFolder, "folder", artworkURL)
+	if err != nil {
+		return fmt.Errorf("Failed to download artist cover: %w", err)
+	}
+
+	r.counter.Success++
+
+	return nil
+}
@@ -0,0 +1,359 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"main/utils/failure"
+	"main/utils/playlist"
+	"main/utils/quality"
+	"main/utils/server"
+	"main/utils/structs"
+)
+
+func TestTierMatches(t *testing.T) {
+	tests := []struct {
+		file     string
+		tier     string
+		alacMax  int
+		atmosMax int
+		want     string // group ID of the first match, "" for none
+	}{
+		{"alac-hires.m3u8", "alac", 192000, 2768, "audio-alac-stereo-192000-24"},
+		{"alac-hires.m3u8", "alac", 96000, 2768, "audio-alac-stereo-96000-24"},
+		{"alac-hires.m3u8", "alac", 48000, 2768, "audio-alac-stereo-48000-24"},
+		{"alac-hires.m3u8", "alac", 44100, 2768, "audio-alac-stereo-44100-16"},
+		{"alac-hires.m3u8", "alac-hires", 192000, 2768, "audio-alac-stereo-192000-24"},
+		{"alac-hires.m3u8", "alac-hires", 48000, 2768, ""},
+		{"alac-hires.m3u8", "aac", 192000, 2768, "audio-stereo-256"},
+		{"alac-hires.m3u8", "atmos", 192000, 2768, ""},
+		{"atmos.m3u8", "atmos", 192000, 2768, "audio-atmos-2768"},
+		{"atmos.m3u8", "atmos", 192000, 2448, "audio-atmos-2448"},
+		{"atmos.m3u8", "atmos", 192000, 2000, ""},
+		{"atmos.m3u8", "dolby-audio", 192000, 2768, "audio-ac3-640"},
+		{"atmos.m3u8", "alac-hires", 192000, 2768, ""},
+		{"atmos.m3u8", "aac-binaural", 192000, 2768, "audio-stereo-256-binaural"},
+		{"atmos.m3u8", "aac-downmix", 192000, 2768, "audio-stereo-256-downmix"},
+	}
+	r := &run{}
+	for _, tt := range tests {
+		body, err := os.ReadFile("../quality/testdata/" + tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		variants, err := quality.ParseMaster("https://aod.itunes.apple.com/master.m3u8", string(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Config.AlacMax, r.Config.AtmosMax = tt.alacMax, tt.atmosMax
+		got := ""
+		for _, variant := range variants {
+			if r.tierMatches(variant, tt.tier) {
+				got = variant.GroupID
+				break
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s %s alac-max %d atmos-max %d: got %q, want %q", tt.file, tt.tier, tt.alacMax, tt.atmosMax, got, tt.want)
+		}
+	}
+}
+
+func TestFindOldCopy(t *testing.T) {
+	r := &run{}
+	root := t.TempDir()
+	r.Config.AlacSaveFolder = filepath.Join(root, "alac")
+	r.Config.AtmosSaveFolder = filepath.Join(root, "atmos")
+	r.Config.AacSaveFolder = ""
+	r.Config.AlbumFolderFormat = "{AlbumName} [{Codec} {Quality}]"
+	r.Config.SongFileFormat = "{SongNumer}. {SongName}"
+	r.Config.LimitMax = 200
+
+	var meta structs.AutoGenerated
+	if err := json.Unmarshal([]byte(`{"data":[{"attributes":{"name":"1989","releaseDate":"2023-10-27"}}]}`), &meta); err != nil {
+		t.Fatal(err)
+	}
+	var track structs.TrackData
+	track.Attributes.Name = "Style"
+	pattern := r.songFileName(r.Config.SongFileFormat, track, 3, nameWildcard, "", nameWildcard, nameWildcard, nameWildcard, "m4a")
+
+	// the 16-bit ALAC copy, in an album folder named for its old quality
+	oldFolder := filepath.Join(r.Config.AlacSaveFolder, "Taylor Swift", "1989 [ALAC 16B-44.1kHz]")
+	if err := os.MkdirAll(oldFolder, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := filepath.Join(oldFolder, "03. Style.m4a")
+	if err := os.WriteFile(oldPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	hiresFolder := filepath.Join(r.Config.AlacSaveFolder, "Taylor Swift", "1989 [ALAC 24B-192.0kHz]")
+	if got := r.findOldCopy(hiresFolder, r.saveFolderFor("alac-hires"), pattern, &meta, "1713845538", "Taylor Swift", ""); got != oldPath {
+		t.Errorf("r.findOldCopy() = %q, want %q", got, oldPath)
+	}
+	// the Atmos edition is saved on its own, the ALAC copy is not its to replace
+	atmosFolder := filepath.Join(r.Config.AtmosSaveFolder, "Taylor Swift", "1989 [ATMOS 2768 kbps]")
+	if got := r.findOldCopy(atmosFolder, r.saveFolderFor("atmos"), pattern, &meta, "1713845538", "Taylor Swift", ""); got != "" {
+		t.Errorf("r.findOldCopy() for Atmos found %q in another save folder", got)
+	}
+	track.Attributes.Name = "Shake It Off"
+	other := r.songFileName(r.Config.SongFileFormat, track, 6, nameWildcard, "", nameWildcard, nameWildcard, nameWildcard, "m4a")
+	if got := r.findOldCopy(hiresFolder, r.saveFolderFor("alac-hires"), other, &meta, "1713845538", "Taylor Swift", ""); got != "" {
+		t.Errorf("r.findOldCopy() found %q for a track that is not there", got)
+	}
+}
+
+func TestIsUpgrade(t *testing.T) {
+	hires := quality.Variant{Codec: "alac", SampleRate: 192000, BitDepth: 24}
+	cd := quality.Variant{Codec: "alac", SampleRate: 44100, BitDepth: 16}
+	atmos := quality.Variant{Codec: "ec-3", Bitrate: 768, Atmos: true}
+	tests := []struct {
+		local   string
+		variant quality.Variant
+		tier    string
+		tiers   []string
+		better  bool
+		ok      bool
+	}{
+		{"alac-16-44.m4a", hires, "alac-hires", []string{"alac-hires", "alac"}, true, true},
+		{"alac-16-44.m4a", hires, "alac", []string{"alac"}, true, true},
+		{"alac-24-192.m4a", cd, "alac", []string{"alac"}, false, true},
+		{"alac-24-192.m4a", cd, "alac", []string{"alac-hires", "alac"}, false, true},
+		{"ac3-51.m4a", atmos, "atmos", []string{"atmos", "dolby-audio"}, true, true},
+		{"atmos.m4a", atmos, "atmos", []string{"atmos", "dolby-audio"}, false, true},
+		// an Atmos copy is not in the ALAC chain, ALAC must not replace it
+		{"atmos.m4a", cd, "alac", []string{"alac"}, false, false},
+		{"ec3-51.m4a", atmos, "atmos", []string{"atmos", "dolby-audio"}, false, false},
+	}
+	for _, tt := range tests {
+		better, ok, err := isUpgrade(filepath.Join("..", "audioinfo", "testdata", tt.local), tt.variant, tt.tier, tt.tiers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if better != tt.better || ok != tt.ok {
+			t.Errorf("isUpgrade(%s, %s, %v) = %v, %v, want %v, %v", tt.local, tt.tier, tt.tiers, better, ok, tt.better, tt.ok)
+		}
+	}
+	if _, _, err := isUpgrade(filepath.Join(t.TempDir(), "missing.m4a"), cd, "alac", []string{"alac"}); err == nil {
+		t.Error("isUpgrade() of a missing file succeeded")
+	}
+}
+
+func TestSyncUnstage(t *testing.T) {
+	folder := t.TempDir()
+	r := &run{}
+	r.Config.LrcFormat = "lrc"
+	var meta structs.AutoGenerated
+	if err := json.Unmarshal([]byte(`{"data":[{"relationships":{"tracks":{"data":[{"id":"1"},{"id":"2"}]}}}]}`), &meta); err != nil {
+		t.Fatal(err)
+	}
+	// both tracks moved up one place since the last sync
+	r.syncManifest = &playlist.Manifest{Total: 3, Tracks: map[string]playlist.Track{
+		"1": {Path: "02. One.m4a", Number: 2},
+		"2": {Path: "03. Two.m4a", Number: 3},
+	}}
+	r.syncStaged = make(map[string]string)
+	for _, name := range []string{"02. One.m4a", "02. One.lrc", "03. Two.m4a"} {
+		if err := os.WriteFile(filepath.Join(folder, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.syncPrepare(&meta, folder); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.syncManifest.Tracks["1"].Path; got != ".sync-1.m4a" {
+		t.Fatalf("r.syncPrepare staged track 1 as %q", got)
+	}
+
+	// track 2 was saved under its new name, track 1 failed to download
+	if err := r.moveTrack(filepath.Join(folder, ".sync-2.m4a"), filepath.Join(folder, "02. Two.m4a")); err != nil {
+		t.Fatal(err)
+	}
+	r.syncManifest.Tracks["2"] = playlist.Track{Path: "02. Two.m4a", Number: 2}
+	r.syncUnstage(folder)
+
+	if got := r.syncManifest.Tracks["1"].Path; got != "02. One.m4a" {
+		t.Errorf("track 1 is at %q after r.syncUnstage, want its old name", got)
+	}
+	for _, name := range []string{"02. One.m4a", "02. One.lrc"} {
+		if exists, _ := fileExists(filepath.Join(folder, name)); !exists {
+			t.Errorf("%s was not restored", name)
+		}
+	}
+	if got := r.syncManifest.Tracks["2"].Path; got != "02. Two.m4a" {
+		t.Errorf("r.syncUnstage moved the saved track 2 to %q", got)
+	}
+}
+
+func TestPickIsrcSong(t *testing.T) {
+	song := func(id, releaseDate string, compilation bool) structs.TrackData {
+		var s structs.TrackData
+		s.ID = id
+		var album structs.AlbumData
+		album.Attributes.ReleaseDate = releaseDate
+		album.Attributes.IsCompilation = compilation
+		s.Relationships.Albums.Data = []structs.AlbumData{album}
+		return s
+	}
+	tests := []struct {
+		name  string
+		songs []structs.TrackData
+		want  string
+	}{
+		{"one song", []structs.TrackData{song("1", "1993-01-01", false)}, "1"},
+		{"compilation last", []structs.TrackData{song("1", "1990-01-01", true), song("2", "1993-01-01", false)}, "2"},
+		{"earliest album", []structs.TrackData{song("1", "2010-01-01", false), song("2", "1993-01-01", false)}, "2"},
+		{"lowest ID on a tie", []structs.TrackData{song("1000", "1993-01-01", false), song("999", "1993-01-01", false)}, "999"},
+		{"only compilations", []structs.TrackData{song("1", "2005-01-01", true), song("2", "2001-01-01", true)}, "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pickIsrcSong(tt.songs); got != tt.want {
+				t.Errorf("pickIsrcSong() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeJob(t *testing.T) {
+	requested := make(chan string, 10)
+	catalog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- r.URL.Path
+		switch r.URL.Path {
+		case "/v1/catalog/us/albums/1713845538":
+			http.NotFound(w, r)
+		case "/v1/catalog/us/albums/1440935467":
+			// an album that takes until the job is cancelled
+			<-r.Context().Done()
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer catalog.Close()
+	useAPIBase(catalog.URL)
+	defer useAPIBase("")
+	r := &run{}
+	r.reset()
+	r.Config.AlacSaveFolder = t.TempDir()
+	// left over from an earlier job
+	r.counter = structs.Counter{Success: 3, Total: 3}
+	r.okDict["1713845538"] = []string{"1713845540"}
+
+	queue, err := server.Open(filepath.Join(t.TempDir(), "serve-queue.json"), func(ctx context.Context, job server.Job, report func(structs.TrackResult)) error {
+		return r.serveJob(ctx, job, "token", report)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := httptest.NewServer(queue.Handler())
+	defer api.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	worked := make(chan struct{})
+	go func() {
+		queue.Work(ctx)
+		close(worked)
+	}()
+	defer func() {
+		cancel()
+		<-worked
+	}()
+
+	post := func(url string) server.Job {
+		resp, err := http.Post(api.URL+"/api/jobs", "application/json", strings.NewReader(`{"url": "`+url+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var job server.Job
+		if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+	wait := func(id string) server.Job {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if job, _ := queue.Get(id); job.State != server.Queued && job.State != server.Running {
+				return job
+			}
+		}
+		t.Fatalf("job %s did not finish", id)
+		return server.Job{}
+	}
+
+	job := wait(post("https://music.apple.com/us/album/1989/1713845538").ID)
+	if job.State != server.Failed || !strings.Contains(job.Error, failure.ErrNotInStorefront.Error()) {
+		t.Errorf("job of a missing album = %s %q", job.State, job.Error)
+	}
+	if r.counter != (structs.Counter{Success: 3, Total: 3}) || len(r.okDict) != 1 {
+		t.Errorf("serveJob changed the state it was called on: %+v %v", r.counter, r.okDict)
+	}
+
+	job = post("https://music.apple.com/us/album/red/1440935467")
+	for path := range requested {
+		if path == "/v1/catalog/us/albums/1440935467" {
+			break
+		}
+	}
+	req, _ := http.NewRequest("DELETE", api.URL+"/api/jobs/"+job.ID, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE running job = %s", resp.Status)
+	}
+	if job = wait(job.ID); job.State != server.Cancelled {
+		t.Errorf("cancelled job = %s %q", job.State, job.Error)
+	}
+}
+
+func TestWritePlaylistFiles(t *testing.T) {
+	r := &run{}
+	r.Config.SongFileFormat = "{SongNumer}. {SongName}"
+	r.Config.LimitMax = 200
+	folder := t.TempDir()
+
+	var meta structs.AutoGenerated
+	if err := json.Unmarshal([]byte(`{"data":[{"attributes":{"name":"Mix"},"relationships":{"tracks":{"data":[
+		{"id":"1713845540","attributes":{"name":"Style","artistName":"Taylor Swift","durationInMillis":231000}},
+		{"id":"1440935468","attributes":{"name":"Red","artistName":"Taylor Swift","durationInMillis":223000}},
+		{"id":"1750307021","attributes":{"name":"Fortnight","artistName":"Taylor Swift","durationInMillis":228000}}
+	]}}}]}`), &meta); err != nil {
+		t.Fatal(err)
+	}
+	// Style was saved in this run, under a name the format does not give
+	r.trackFiles = map[string]map[string]string{"pl.mix": {"1713845540": filepath.Join(folder, "01. Style (ALAC).m4a")}}
+	// Red was saved by an earlier run, Fortnight was skipped
+	if err := os.WriteFile(filepath.Join(folder, "02. Red.m4a"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.writePlaylistFiles(&meta, "pl.mix", folder, []string{"m3u8"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(folder, filepath.Base(folder)+".m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "#EXTM3U\n#EXTINF:231,Taylor Swift - Style\n01. Style (ALAC).m4a\n#EXTINF:223,Taylor Swift - Red\n02. Red.m4a\n"
+	if string(data) != want {
+		t.Errorf("playlist file =\n%s\nwant\n%s", data, want)
+	}
+
+	// nothing on disk writes no playlist
+	empty := t.TempDir()
+	r.trackFiles = nil
+	if err := r.writePlaylistFiles(&meta, "pl.mix", empty, []string{"m3u8", "pls"}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(empty); len(entries) != 0 {
+		t.Errorf("playlist written with no tracks on disk: %v", entries)
+	}
+}
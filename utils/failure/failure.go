@@ -0,0 +1,128 @@
+// Package failure names the ways a download goes wrong, so that a track
+// is counted the same wherever it fails and the program ends with an exit
+// code scripts can tell apart.
+package failure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"main/utils/structs"
+)
+
+var (
+	// ErrNotInStorefront is a song, album or video the catalog of the
+	// storefront does not have.
+	ErrNotInStorefront = errors.New("not available in this storefront")
+	// ErrNoLossless is a track with no stream in any quality the
+	// preference allows, mostly lossless without an AAC fallback.
+	ErrNoLossless = errors.New("not available in the wanted quality")
+	// ErrAuth is a developer or media-user-token that is missing, expired
+	// or refused.
+	ErrAuth = errors.New("not authorized, check the tokens")
+	// ErrRateLimited is the API turning away requests that come too fast.
+	ErrRateLimited = errors.New("rate limited by Apple Music")
+	// ErrTagging is a downloaded file whose tags could not be written.
+	ErrTagging = errors.New("failed to write tags")
+	// ErrDecryptorUnavailable is a decryptor that does not accept the
+	// connection on decrypt-m3u8-port.
+	ErrDecryptorUnavailable = errors.New("decryptor is not reachable")
+)
+
+// Exit codes of the command line. Other errors end it with ExitError.
+const (
+	ExitOK              = 0
+	ExitError           = 1
+	ExitUsage           = 2
+	ExitAuth            = 3
+	ExitRateLimited     = 4
+	ExitDecryptor       = 5
+	ExitTagging         = 6
+	ExitNotInStorefront = 7
+	ExitNoLossless      = 8
+	ExitInterrupted     = 130
+)
+
+// Response returns the error of an Apple Music API response that is not
+// 200 OK.
+func Response(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %s", ErrAuth, resp.Status)
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s", ErrRateLimited, resp.Status)
+	}
+	return errors.New(resp.Status)
+}
+
+// CatalogResponse is Response for a lookup in the catalog of a
+// storefront, where 404 means the storefront does not have the item.
+// Elsewhere, as in the library, a 404 says nothing about the storefront.
+func CatalogResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotInStorefront, resp.Status)
+	}
+	return Response(resp)
+}
+
+// Status is the structs.Track status a track that ended with err is
+// counted as.
+func Status(err error) string {
+	switch {
+	case err == nil:
+		return structs.TrackSuccess
+	case errors.Is(err, ErrNotInStorefront), errors.Is(err, ErrNoLossless):
+		return structs.TrackUnavailable
+	}
+	return structs.TrackError
+}
+
+// Code is the exit code of a run that failed with err.
+func Code(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	case errors.Is(err, ErrAuth):
+		return ExitAuth
+	case errors.Is(err, ErrRateLimited):
+		return ExitRateLimited
+	case errors.Is(err, ErrDecryptorUnavailable):
+		return ExitDecryptor
+	case errors.Is(err, ErrTagging):
+		return ExitTagging
+	case errors.Is(err, ErrNotInStorefront):
+		return ExitNotInStorefront
+	case errors.Is(err, ErrNoLossless):
+		return ExitNoLossless
+	}
+	return ExitError
+}
+
+// severity orders the exit codes, the one that most needs the user first:
+// a bad token or a missing decryptor fails every track after it.
+var severity = []int{ExitInterrupted, ExitAuth, ExitDecryptor, ExitRateLimited, ExitTagging, ExitError, ExitNotInStorefront, ExitNoLossless}
+
+// Worst returns whichever of a and b should decide the exit code of a run
+// that saw both.
+func Worst(a, b error) error {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	ca, cb := Code(a), Code(b)
+	for _, code := range severity {
+		if code == ca {
+			return a
+		}
+		if code == cb {
+			return b
+		}
+	}
+	return a
+}
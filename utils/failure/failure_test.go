@@ -0,0 +1,115 @@
+package failure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"main/utils/structs"
+)
+
+func response(code int) *http.Response {
+	return &http.Response{StatusCode: code, Status: fmt.Sprintf("%d %s", code, http.StatusText(code))}
+}
+
+func TestResponse(t *testing.T) {
+	tests := []struct {
+		code    int
+		want    error
+		catalog error
+	}{
+		{http.StatusUnauthorized, ErrAuth, ErrAuth},
+		{http.StatusForbidden, ErrAuth, ErrAuth},
+		{http.StatusTooManyRequests, ErrRateLimited, ErrRateLimited},
+		// only the catalog says a 404 item is missing from the storefront
+		{http.StatusNotFound, nil, ErrNotInStorefront},
+		{http.StatusInternalServerError, nil, nil},
+	}
+	for _, tt := range tests {
+		resp := response(tt.code)
+		for _, c := range []struct {
+			name string
+			err  error
+			want error
+		}{{"Response", Response(resp), tt.want}, {"CatalogResponse", CatalogResponse(resp), tt.catalog}} {
+			if c.err == nil {
+				t.Fatalf("%s(%d) = nil", c.name, tt.code)
+			}
+			if c.want != nil && !errors.Is(c.err, c.want) {
+				t.Errorf("%s(%d) = %v, want %v", c.name, tt.code, c.err, c.want)
+			}
+			if c.want == nil && Code(c.err) != ExitError {
+				t.Errorf("%s(%d) = %v, want a plain error", c.name, tt.code, c.err)
+			}
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, structs.TrackSuccess},
+		{fmt.Errorf("%w: song 1", ErrNotInStorefront), structs.TrackUnavailable},
+		{ErrNoLossless, structs.TrackUnavailable},
+		{ErrAuth, structs.TrackError},
+		{ErrTagging, structs.TrackError},
+		{errors.New("connection reset"), structs.TrackError},
+	}
+	for _, tt := range tests {
+		if got := Status(tt.err); got != tt.want {
+			t.Errorf("Status(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, ExitOK},
+		{context.Canceled, ExitInterrupted},
+		{fmt.Errorf("album 1: %w", context.Canceled), ExitInterrupted},
+		{fmt.Errorf("%w: 401 Unauthorized", ErrAuth), ExitAuth},
+		{ErrRateLimited, ExitRateLimited},
+		{ErrDecryptorUnavailable, ExitDecryptor},
+		{fmt.Errorf("%w: track 3", ErrTagging), ExitTagging},
+		{ErrNotInStorefront, ExitNotInStorefront},
+		{ErrNoLossless, ExitNoLossless},
+		{errors.New("404 Not Found"), ExitError},
+	}
+	for _, tt := range tests {
+		if got := Code(tt.err); got != tt.want {
+			t.Errorf("Code(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWorst(t *testing.T) {
+	other := errors.New("connection reset")
+	tests := []struct {
+		a, b, want error
+	}{
+		{nil, nil, nil},
+		{ErrNoLossless, nil, ErrNoLossless},
+		{nil, ErrNoLossless, ErrNoLossless},
+		{ErrNoLossless, ErrNotInStorefront, ErrNotInStorefront},
+		{ErrNotInStorefront, other, other},
+		{other, ErrTagging, ErrTagging},
+		{ErrTagging, ErrRateLimited, ErrRateLimited},
+		{ErrRateLimited, ErrDecryptorUnavailable, ErrDecryptorUnavailable},
+		{ErrDecryptorUnavailable, ErrAuth, ErrAuth},
+		{ErrAuth, context.Canceled, context.Canceled},
+		// equally bad, the first one stays
+		{ErrAuth, fmt.Errorf("%w: 403 Forbidden", ErrAuth), ErrAuth},
+	}
+	for _, tt := range tests {
+		if got := Worst(tt.a, tt.b); got != tt.want {
+			t.Errorf("Worst(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Package jsonfile writes the state files of the downloader, such as the
+// watch state, the serve queue and the playlist sync manifests.
+package jsonfile
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Write saves v as indented JSON to path, creating its folder. It writes
+// a temporary file first and renames it over path, so an interrupted run
+// never leaves a truncated file behind.
+func Write(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
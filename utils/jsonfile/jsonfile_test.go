@@ -0,0 +1,45 @@
+package jsonfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "watch.json")
+	if err := Write(path, map[string][]string{"159260351": {"1713845538"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(path, map[string]int{"next": 2}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "{\n  \"next\": 2\n}"; got != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "queue.json")
+	// a folder in the way of the file
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(path, 1); err == nil {
+		t.Error("Write() over a folder succeeded")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+	if err := Write(path, func() {}); err == nil {
+		t.Error("Write() of a func succeeded")
+	}
+}
@@ -0,0 +1,173 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"main/utils/amurl"
+	"main/utils/failure"
+)
+
+// Kinds are the library sections List enumerates.
+var Kinds = []string{"albums", "playlists", "songs"}
+
+// Item is an album, playlist or song of the user's library.
+type Item struct {
+	ID         string // library ID: l. albums, p. playlists, i. songs
+	Kind       string // albums, playlists or songs
+	Name       string
+	ArtistName string
+	CatalogID  string // "" when the item is not in the catalog, e.g. uploads and private playlists
+}
+
+// resource is what the library endpoints return for each item.
+type resource struct {
+	ID         string `json:"id"`
+	Attributes struct {
+		Name       string `json:"name"`
+		ArtistName string `json:"artistName"`
+		PlayParams struct {
+			CatalogID string `json:"catalogId"`
+			GlobalID  string `json:"globalId"` // published playlists only
+		} `json:"playParams"`
+	} `json:"attributes"`
+	Relationships struct {
+		Catalog struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		} `json:"catalog"`
+	} `json:"relationships"`
+}
+
+type page struct {
+	Next string     `json:"next"`
+	Data []resource `json:"data"`
+}
+
+// Kind is the library section of id, from its prefix.
+func Kind(id string) string {
+	switch {
+	case strings.HasPrefix(id, "l."):
+		return "albums"
+	case strings.HasPrefix(id, "p."):
+		return "playlists"
+	case strings.HasPrefix(id, "i."):
+		return "songs"
+	}
+	return ""
+}
+
+// Get fetches path from the library API into v. Library requests need the
+// media-user-token next to the developer token.
+func Get(ctx context.Context, path, token, mediaUserToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", amurl.API(path), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Media-User-Token", mediaUserToken)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+	req.Header.Set("Origin", "https://music.apple.com")
+	do, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer do.Body.Close()
+	if do.StatusCode != http.StatusOK {
+		return failure.Response(do)
+	}
+	return json.NewDecoder(do.Body).Decode(v)
+}
+
+// Storefront is the storefront of the user's account.
+func Storefront(ctx context.Context, token, mediaUserToken string) (string, error) {
+	var obj struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := Get(ctx, "/v1/me/storefront", token, mediaUserToken, &obj); err != nil {
+		return "", err
+	}
+	if len(obj.Data) == 0 {
+		return "", errors.New("no storefront for this account")
+	}
+	return obj.Data[0].ID, nil
+}
+
+// List pages through one section of the library, one of Kinds.
+func List(ctx context.Context, kind, token, mediaUserToken string) ([]Item, error) {
+	var items []Item
+	for offset := 0; ; offset += 100 {
+		var obj page
+		if err := Get(ctx, fmt.Sprintf("/v1/me/library/%s?limit=100&offset=%d&include=catalog", kind, offset), token, mediaUserToken, &obj); err != nil {
+			return nil, err
+		}
+		for _, r := range obj.Data {
+			items = append(items, item(kind, r))
+		}
+		if len(obj.Next) == 0 {
+			break
+		}
+	}
+	return items, nil
+}
+
+// Resolve looks up a library album, playlist or song by ID.
+func Resolve(ctx context.Context, id, token, mediaUserToken string) (Item, error) {
+	kind := Kind(id)
+	if kind == "" {
+		return Item{}, fmt.Errorf("not a library ID: %s", id)
+	}
+	var obj page
+	if err := Get(ctx, fmt.Sprintf("/v1/me/library/%s/%s?include=catalog", kind, id), token, mediaUserToken, &obj); err != nil {
+		return Item{}, err
+	}
+	if len(obj.Data) == 0 {
+		return Item{}, fmt.Errorf("not in library: %s", id)
+	}
+	return item(kind, obj.Data[0]), nil
+}
+
+func item(kind string, r resource) Item {
+	it := Item{ID: r.ID, Kind: kind, Name: r.Attributes.Name, ArtistName: r.Attributes.ArtistName}
+	switch {
+	case kind == "playlists":
+		it.CatalogID = r.Attributes.PlayParams.GlobalID
+	case len(r.Relationships.Catalog.Data) > 0:
+		it.CatalogID = r.Relationships.Catalog.Data[0].ID
+	default:
+		it.CatalogID = r.Attributes.PlayParams.CatalogID
+	}
+	return it
+}
+
+// PlaylistTracks returns the catalog IDs of the songs of a library
+// playlist, in order, and how many of its tracks are not in the catalog.
+func PlaylistTracks(ctx context.Context, id, token, mediaUserToken string) ([]string, int, error) {
+	var ids []string
+	missing := 0
+	for offset := 0; ; offset += 100 {
+		var obj page
+		err := Get(ctx, fmt.Sprintf("/v1/me/library/playlists/%s/tracks?limit=100&offset=%d&include=catalog", id, offset), token, mediaUserToken, &obj)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, r := range obj.Data {
+			if it := item("songs", r); it.CatalogID != "" {
+				ids = append(ids, it.CatalogID)
+			} else {
+				missing++
+			}
+		}
+		if len(obj.Next) == 0 {
+			break
+		}
+	}
+	return ids, missing, nil
+}
@@ -0,0 +1,149 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"main/utils/amurl"
+	"main/utils/failure"
+)
+
+// serve answers library requests with the JSON routed to their path and
+// query, and 404 for anything else, for the rest of the test.
+func serve(t *testing.T, routes map[string]string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Media-User-Token") != "user" {
+			t.Errorf("request %s without both tokens", r.URL)
+		}
+		body, ok := routes[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	amurl.APIBase = srv.URL
+	t.Cleanup(func() { amurl.APIBase = amurl.DefaultAPIBase })
+}
+
+func TestKind(t *testing.T) {
+	for id, want := range map[string]string{
+		"l.Qx9zAbc":  "albums",
+		"p.AbCd3fG":  "playlists",
+		"i.B2xYz":    "songs",
+		"1713845538": "",
+		"pl.f4d106":  "",
+	} {
+		if got := Kind(id); got != want {
+			t.Errorf("Kind(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	serve(t, map[string]string{
+		"/v1/me/library/albums?limit=100&offset=0&include=catalog": `{"next": "/v1/me/library/albums?offset=100", "data": [
+			{"id": "l.Qx9zAbc", "attributes": {"name": "1989 (Taylor's Version)", "artistName": "Taylor Swift", "playParams": {"catalogId": "1713845538"}},
+			 "relationships": {"catalog": {"data": [{"id": "1713845538"}]}}},
+			{"id": "l.Upl0ad1", "attributes": {"name": "Demos", "artistName": "Me"}}
+		]}`,
+		"/v1/me/library/albums?limit=100&offset=100&include=catalog": `{"data": [
+			{"id": "l.R3d", "attributes": {"name": "Red", "artistName": "Taylor Swift", "playParams": {"catalogId": "1440935467"}}}
+		]}`,
+		"/v1/me/library/playlists?limit=100&offset=0&include=catalog": `{"data": [
+			{"id": "p.AbCd3fG", "attributes": {"name": "Shared", "playParams": {"globalId": "pl.u-8aAVZAVCrgb7Ej"}}},
+			{"id": "p.Priv4te", "attributes": {"name": "Private"}}
+		]}`,
+	})
+	items, err := List(context.Background(), "albums", "token", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the catalog relationship, playParams, or nothing for uploads
+	want := []Item{
+		{ID: "l.Qx9zAbc", Kind: "albums", Name: "1989 (Taylor's Version)", ArtistName: "Taylor Swift", CatalogID: "1713845538"},
+		{ID: "l.Upl0ad1", Kind: "albums", Name: "Demos", ArtistName: "Me"},
+		{ID: "l.R3d", Kind: "albums", Name: "Red", ArtistName: "Taylor Swift", CatalogID: "1440935467"},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("List(albums) = %+v, want %+v", items, want)
+	}
+
+	items, err = List(context.Background(), "playlists", "token", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Item{
+		{ID: "p.AbCd3fG", Kind: "playlists", Name: "Shared", CatalogID: "pl.u-8aAVZAVCrgb7Ej"},
+		{ID: "p.Priv4te", Kind: "playlists", Name: "Private"},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("List(playlists) = %+v, want %+v", items, want)
+	}
+
+	if _, err := List(context.Background(), "songs", "token", "user"); err == nil {
+		t.Error("List() of a failing section succeeded")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	serve(t, map[string]string{
+		"/v1/me/library/songs/i.B2xYz?include=catalog": `{"data": [
+			{"id": "i.B2xYz", "attributes": {"name": "Style", "artistName": "Taylor Swift"}, "relationships": {"catalog": {"data": [{"id": "1713845540"}]}}}
+		]}`,
+		"/v1/me/library/albums/l.Gone?include=catalog": `{"data": []}`,
+	})
+	it, err := Resolve(context.Background(), "i.B2xYz", "token", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Item{ID: "i.B2xYz", Kind: "songs", Name: "Style", ArtistName: "Taylor Swift", CatalogID: "1713845540"}); it != want {
+		t.Errorf("Resolve() = %+v, want %+v", it, want)
+	}
+	if _, err := Resolve(context.Background(), "1713845540", "token", "user"); err == nil {
+		t.Error("Resolve() of a catalog ID succeeded")
+	}
+	if _, err := Resolve(context.Background(), "l.Gone", "token", "user"); err == nil {
+		t.Error("Resolve() of an empty answer succeeded")
+	}
+	// a 404 from the library says nothing about the storefront
+	_, err = Resolve(context.Background(), "p.Missing", "token", "user")
+	if err == nil || errors.Is(err, failure.ErrNotInStorefront) {
+		t.Errorf("Resolve() of a missing playlist = %v, want a plain error", err)
+	}
+}
+
+func TestPlaylistTracks(t *testing.T) {
+	serve(t, map[string]string{
+		"/v1/me/library/playlists/p.AbCd3fG/tracks?limit=100&offset=0&include=catalog": `{"next": "next", "data": [
+			{"id": "i.1", "attributes": {"name": "Style", "playParams": {"catalogId": "1713845540"}}},
+			{"id": "i.2", "attributes": {"name": "Uploaded demo"}}
+		]}`,
+		"/v1/me/library/playlists/p.AbCd3fG/tracks?limit=100&offset=100&include=catalog": `{"data": [
+			{"id": "i.3", "attributes": {"name": "Red"}, "relationships": {"catalog": {"data": [{"id": "1440935468"}]}}}
+		]}`,
+	})
+	ids, missing, err := PlaylistTracks(context.Background(), "p.AbCd3fG", "token", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"1713845540", "1440935468"}) || missing != 1 {
+		t.Errorf("PlaylistTracks() = %v, %d missing", ids, missing)
+	}
+}
+
+func TestStorefront(t *testing.T) {
+	serve(t, map[string]string{
+		"/v1/me/storefront": `{"data": [{"id": "gb"}]}`,
+	})
+	if sf, err := Storefront(context.Background(), "token", "user"); err != nil || sf != "gb" {
+		t.Errorf("Storefront() = %q, %v", sf, err)
+	}
+}
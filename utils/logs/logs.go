@@ -0,0 +1,90 @@
+// Package logs sets up the leveled log of the downloader and carries the
+// album and track a message is about along with the context.
+package logs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// toStderr is set when the default logger writes to the terminal.
+var toStderr bool
+
+// Setup points the default slog logger at file, appending to it, or at
+// stderr when file is empty. level is debug, info, warn or error and
+// format is text or json, empty ones are info and text. The returned
+// Closer closes the file.
+func Setup(level, file, format string) (io.Closer, error) {
+	if level == "" {
+		level = "info"
+	}
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level: %s, use debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var out io.WriteCloser = nopCloser{os.Stderr}
+	toStderr = file == ""
+	if toStderr {
+		// timestamps only clutter the terminal
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		}
+	} else {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		out.Close()
+		return nil, fmt.Errorf("unknown log format: %s, use text or json", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return out, nil
+}
+
+// Mute drops the messages meant for the terminal until restore is
+// called, for screens that own it. A log file keeps getting them.
+func Mute() (restore func()) {
+	if !toStderr {
+		return func() {}
+	}
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return func() { slog.SetDefault(logger) }
+}
+
+type loggerKey struct{}
+
+// With returns ctx whose logger adds args, such as "album", id, to every
+// message.
+func With(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, loggerKey{}, From(ctx).With(args...))
+}
+
+// From returns the logger of ctx, the default logger when there is none.
+func From(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
@@ -1,12 +1,16 @@
 package lyrics
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 
+	"main/utils/amurl"
+
 	"github.com/beevik/etree"
 )
 
@@ -27,12 +31,12 @@ type SongLyrics struct {
 }
 
 
-func Get(storefront, songId, lrcType, language, lrcFormat, token, mediaUserToken string) (string, error) {
+func Get(ctx context.Context, storefront, songId, lrcType, language, lrcFormat, token, mediaUserToken string) (string, error) {
 	if len(mediaUserToken) < 50 {
 		return "", errors.New("MediaUserToken not set")
 	}
 
-	ttml, err := getSongLyrics(songId, storefront, token, mediaUserToken, lrcType, language)
+	ttml, err := getSongLyrics(ctx, songId, storefront, token, mediaUserToken, lrcType, language)
 	if err != nil {
 		return "", err
 	}
@@ -43,14 +47,15 @@ func Get(storefront, songId, lrcType, language, lrcFormat, token, mediaUserToken
 
 	lrc, err := TtmlToLrc(ttml)
 	if err != nil {
+		slog.Debug("failed to convert lyrics to lrc", "id", songId, "err", err)
 		return "", err
 	}
 
 	return lrc, nil
 }
-func getSongLyrics(songId string, storefront string, token string, userToken string, lrcType string, language string) (string, error) {
-	req, err := http.NewRequest("GET",
-		fmt.Sprintf("https://amp-api.music.apple.com/v1/catalog/%s/songs/%s/%s?l=%s", storefront, songId, lrcType, language), nil)
+func getSongLyrics(ctx context.Context, songId string, storefront string, token string, userToken string, lrcType string, language string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET",
+		amurl.API(fmt.Sprintf("/v1/catalog/%s/songs/%s/%s?l=%s", storefront, songId, lrcType, language)), nil)
 	if err != nil {
 		return "", err
 	}
@@ -69,6 +74,7 @@ func getSongLyrics(songId string, storefront string, token string, userToken str
 	if obj.Data != nil {
 		return obj.Data[0].Attributes.Ttml, nil
 	} else {
+		slog.Debug("no lyrics in response", "id", songId, "type", lrcType, "status", do.Status)
 		return "", errors.New("failed to get lyrics")
 	}
 }
@@ -0,0 +1,174 @@
+package pages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"main/utils/amurl"
+)
+
+// Item is one album, playlist, song or music video listed on a curator,
+// record label or editorial room page.
+type Item struct {
+	Type        string // albums, playlists, songs or music-videos
+	ID          string
+	Name        string
+	ArtistName  string // the curator for playlists
+	ReleaseDate string
+	URL         string
+}
+
+type resource struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Attributes struct {
+		Name        string `json:"name"`
+		ArtistName  string `json:"artistName"`
+		CuratorName string `json:"curatorName"`
+		ReleaseDate string `json:"releaseDate"`
+		URL         string `json:"url"`
+	} `json:"attributes"`
+	Relationships struct {
+		Children struct {
+			Data []resource `json:"data"`
+		} `json:"children"`
+	} `json:"relationships"`
+	Views map[string]page `json:"views"`
+}
+
+type page struct {
+	Next string     `json:"next"`
+	Data []resource `json:"data"`
+}
+
+var downloadable = map[string]bool{"albums": true, "playlists": true, "songs": true, "music-videos": true}
+
+// Curator lists the playlists of a curator, or of an Apple Music curator
+// such as a genre or activity page when apple is set.
+func Curator(ctx context.Context, storefront, id string, apple bool, language, token string) ([]Item, error) {
+	kind := "curators"
+	if apple {
+		kind = "apple-curators"
+	}
+	return list(ctx, fmt.Sprintf("/v1/catalog/%s/%s/%s/playlists?limit=100", storefront, kind, id), language, token)
+}
+
+// labelViews are the record label page sections listed, newest first.
+var labelViews = []string{"latest-releases", "top-releases"}
+
+// RecordLabel lists the latest and top releases of a record label, each
+// release once.
+func RecordLabel(ctx context.Context, storefront, id, language, token string) ([]Item, error) {
+	var obj page
+	if err := get(ctx, fmt.Sprintf("/v1/catalog/%s/record-labels/%s?views=%s", storefront, id, strings.Join(labelViews, ",")), language, token, &obj); err != nil {
+		return nil, err
+	}
+	if len(obj.Data) == 0 {
+		return nil, errors.New("record label not found")
+	}
+	var items []Item
+	seen := make(map[string]bool)
+	for _, name := range labelViews {
+		view := obj.Data[0].Views[name]
+		more, err := list(ctx, view.Next, language, token)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range append(keep(view.Data), more...) {
+			if !seen[item.ID] {
+				seen[item.ID] = true
+				items = append(items, item)
+			}
+		}
+	}
+	return items, nil
+}
+
+// Room lists the contents of an editorial room.
+func Room(ctx context.Context, storefront, id, language, token string) ([]Item, error) {
+	return list(ctx, fmt.Sprintf("/v1/editorial/%s/rooms/%s/contents?limit=100", storefront, id), language, token)
+}
+
+// MultiRoom lists the contents of each room of a multi-room page, in order.
+func MultiRoom(ctx context.Context, storefront, id, language, token string) ([]Item, error) {
+	var obj page
+	if err := get(ctx, fmt.Sprintf("/v1/editorial/%s/multirooms/%s?include=children", storefront, id), language, token, &obj); err != nil {
+		return nil, err
+	}
+	if len(obj.Data) == 0 {
+		return nil, errors.New("multi-room not found")
+	}
+	var items []Item
+	for _, child := range obj.Data[0].Relationships.Children.Data {
+		room, err := Room(ctx, storefront, child.ID, language, token)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, room...)
+	}
+	return items, nil
+}
+
+// list pages through path, keeping what can be downloaded.
+func list(ctx context.Context, path, language, token string) ([]Item, error) {
+	var items []Item
+	for path != "" {
+		var obj page
+		if err := get(ctx, path, language, token, &obj); err != nil {
+			return nil, err
+		}
+		items = append(items, keep(obj.Data)...)
+		path = obj.Next
+	}
+	return items, nil
+}
+
+// keep returns the resources that can be downloaded as items.
+func keep(data []resource) []Item {
+	var items []Item
+	for _, r := range data {
+		if !downloadable[r.Type] {
+			continue
+		}
+		artist := r.Attributes.ArtistName
+		if r.Type == "playlists" {
+			artist = r.Attributes.CuratorName
+		}
+		items = append(items, Item{
+			Type:        r.Type,
+			ID:          r.ID,
+			Name:        r.Attributes.Name,
+			ArtistName:  artist,
+			ReleaseDate: r.Attributes.ReleaseDate,
+			URL:         r.Attributes.URL,
+		})
+	}
+	return items
+}
+
+func get(ctx context.Context, path, language, token string, v any) error {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", amurl.API(path+sep+"l="+language), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+	req.Header.Set("Origin", "https://music.apple.com")
+	do, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer do.Body.Close()
+	if do.StatusCode != http.StatusOK {
+		return errors.New(do.Status)
+	}
+	return json.NewDecoder(do.Body).Decode(v)
+}
@@ -0,0 +1,163 @@
+package pages
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// catalog answers API requests with the testdata file routed to their path
+// and query, and 404 for anything else.
+type catalog struct {
+	t      *testing.T
+	routes map[string]string
+}
+
+func (c catalog) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "amp-api.music.apple.com" || req.Header.Get("Authorization") != "Bearer token" {
+		c.t.Errorf("unexpected request %s", req.URL)
+	}
+	if l := req.URL.Query().Get("l"); l != "en-US" {
+		c.t.Errorf("request %s has language %q", req.URL, l)
+	}
+	resp := &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: http.NoBody, Request: req}
+	name, ok := c.routes[strings.TrimSuffix(req.URL.RequestURI(), "&l=en-US")]
+	if !ok {
+		return resp, nil
+	}
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		return nil, err
+	}
+	resp.StatusCode, resp.Status, resp.Body = http.StatusOK, "200 OK", f
+	return resp, nil
+}
+
+// serve answers the requests of the default client from routes for the
+// rest of the test.
+func serve(t *testing.T, routes map[string]string) {
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = catalog{t, routes}
+	t.Cleanup(func() { http.DefaultClient.Transport = transport })
+}
+
+func names(items []Item) []string {
+	var out []string
+	for _, item := range items {
+		out = append(out, item.Type+" "+item.ID+" "+item.Name+" - "+item.ArtistName)
+	}
+	return out
+}
+
+func TestCurator(t *testing.T) {
+	serve(t, map[string]string{
+		"/v1/catalog/us/curators/1558198883/playlists?limit=100":       "curator-playlists.json",
+		"/v1/catalog/us/curators/1558198883/playlists?offset=2":        "curator-playlists-2.json",
+		"/v1/catalog/us/apple-curators/1526756058/playlists?limit=100": "curator-playlists-2.json",
+	})
+	items, err := Curator(context.Background(), "us", "1558198883", false, "en-US", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"playlists pl.4b364b8b182f4115acbf6deb83bd5222 Pitchfork Selects - Pitchfork",
+		"playlists pl.8d1b7a6c6c2c4b8e9c0b1f1f2e3d4c5b Best New Tracks - Pitchfork",
+		"playlists pl.0a1b2c3d4e5f40718293a4b5c6d7e8f9 Best New Albums - Pitchfork",
+	}
+	if got := names(items); !reflect.DeepEqual(got, want) {
+		t.Errorf("Curator() = %q, want %q", got, want)
+	}
+	if items[0].URL != "https://music.apple.com/us/playlist/pitchfork-selects/pl.4b364b8b182f4115acbf6deb83bd5222" {
+		t.Errorf("Curator() URL = %s", items[0].URL)
+	}
+
+	items, err = Curator(context.Background(), "us", "1526756058", true, "en-US", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Errorf("Curator(apple) listed %d items, want 1", len(items))
+	}
+}
+
+func TestRecordLabel(t *testing.T) {
+	serve(t, map[string]string{
+		"/v1/catalog/us/record-labels/1543411840?views=latest-releases,top-releases": "record-label.json",
+		"/v1/catalog/us/record-labels/1543411840/view/latest-releases?offset=2":      "record-label-latest-2.json",
+	})
+	items, err := RecordLabel(context.Background(), "us", "1543411840", "en-US", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// latest releases with their next page, then the top releases not
+	// listed yet
+	want := []string{
+		"albums 1750307020 THE TORTURED POETS DEPARTMENT: THE ANTHOLOGY - Taylor Swift",
+		"albums 1713845538 1989 (Taylor's Version) - Taylor Swift",
+		"music-videos 1649434004 Anti-Hero - Taylor Swift",
+		"albums 1440935467 Red (Deluxe Edition) - Taylor Swift",
+	}
+	if got := names(items); !reflect.DeepEqual(got, want) {
+		t.Errorf("RecordLabel() = %q, want %q", got, want)
+	}
+}
+
+func TestRoom(t *testing.T) {
+	serve(t, map[string]string{
+		"/v1/editorial/us/rooms/6451502539/contents?limit=100": "room-contents.json",
+	})
+	items, err := Room(context.Background(), "us", "6451502539", "en-US", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the editorial element is not something to download
+	want := []string{
+		"albums 1713845538 1989 (Taylor's Version) - Taylor Swift",
+		"songs 1713845540 Style (Taylor's Version) - Taylor Swift",
+		"music-videos 1649434004 Anti-Hero - Taylor Swift",
+	}
+	if got := names(items); !reflect.DeepEqual(got, want) {
+		t.Errorf("Room() = %q, want %q", got, want)
+	}
+	if items[0].ReleaseDate != "2023-10-27" {
+		t.Errorf("Room() release date = %s", items[0].ReleaseDate)
+	}
+}
+
+func TestMultiRoom(t *testing.T) {
+	serve(t, map[string]string{
+		"/v1/editorial/us/multirooms/6451502530?include=children": "multiroom.json",
+		"/v1/editorial/us/rooms/6451502539/contents?limit=100":    "room-contents.json",
+		"/v1/editorial/us/rooms/6451502540/contents?limit=100":    "room-contents-2.json",
+	})
+	items, err := MultiRoom(context.Background(), "us", "6451502530", "en-US", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"albums 1713845538 1989 (Taylor's Version) - Taylor Swift",
+		"songs 1713845540 Style (Taylor's Version) - Taylor Swift",
+		"music-videos 1649434004 Anti-Hero - Taylor Swift",
+		"playlists pl.4b364b8b182f4115acbf6deb83bd5222 Taylor Swift Essentials - Apple Music Pop",
+	}
+	if got := names(items); !reflect.DeepEqual(got, want) {
+		t.Errorf("MultiRoom() = %q, want %q", got, want)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	serve(t, nil)
+	if _, err := Room(context.Background(), "us", "1", "en-US", "token"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Room() of a missing room = %v, want 404", err)
+	}
+	if _, err := MultiRoom(context.Background(), "us", "1", "en-US", "token"); err == nil {
+		t.Error("MultiRoom() of a missing multi-room succeeded")
+	}
+	if _, err := RecordLabel(context.Background(), "us", "1", "en-US", "token"); err == nil {
+		t.Error("RecordLabel() of a missing label succeeded")
+	}
+}
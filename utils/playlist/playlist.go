@@ -0,0 +1,164 @@
+package playlist
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"main/utils/jsonfile"
+)
+
+// ManifestName is the manifest file kept in a synced playlist's folder.
+const ManifestName = ".sync.json"
+
+// Manifest maps the catalog ID of each track of a synced playlist to its
+// file, so a later sync can tell new, moved and removed tracks apart.
+type Manifest struct {
+	Total  int              `json:"total"`
+	Tracks map[string]Track `json:"tracks"`
+}
+
+// Track is where a synced track was saved and at which position.
+type Track struct {
+	Path   string `json:"path"` // relative to the playlist folder
+	Number int    `json:"number"`
+}
+
+// Load reads the manifest of folder. A missing manifest is an empty one.
+func Load(folder string) (*Manifest, error) {
+	m := &Manifest{Tracks: make(map[string]Track)}
+	data, err := os.ReadFile(filepath.Join(folder, ManifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Tracks == nil {
+		m.Tracks = make(map[string]Track)
+	}
+	return m, nil
+}
+
+// Save writes the manifest of folder through a temporary file.
+func (m *Manifest) Save(folder string) error {
+	return jsonfile.Write(filepath.Join(folder, ManifestName), m)
+}
+
+// Removed returns the IDs of m that are not in ids, sorted.
+func (m *Manifest) Removed(ids []string) []string {
+	current := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		current[id] = true
+	}
+	var removed []string
+	for id := range m.Tracks {
+		if !current[id] {
+			removed = append(removed, id)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// Formats are the playlist file formats Write supports.
+var Formats = []string{"m3u8", "pls", "xspf"}
+
+// Entry is one track of an exported playlist.
+type Entry struct {
+	Path     string // relative to the playlist file
+	Title    string
+	Artist   string
+	Duration time.Duration
+}
+
+// Write writes entries to path in format, one of Formats. title names the
+// playlist where the format has a place for it.
+func Write(path, format, title string, entries []Entry) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	switch format {
+	case "m3u8":
+		writeM3U8(w, entries)
+	case "pls":
+		writePLS(w, entries)
+	case "xspf":
+		if err := writeXSPF(w, title, entries); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown playlist format: %s", format)
+	}
+	return w.Flush()
+}
+
+// writeM3U8 writes an extended M3U playlist.
+func writeM3U8(w io.Writer, entries []Entry) {
+	fmt.Fprintln(w, "#EXTM3U")
+	for _, e := range entries {
+		fmt.Fprintf(w, "#EXTINF:%d,%s - %s\n", int(e.Duration.Seconds()), e.Artist, e.Title)
+		fmt.Fprintln(w, filepath.ToSlash(e.Path))
+	}
+}
+
+// writePLS writes a version 2 PLS playlist.
+func writePLS(w io.Writer, entries []Entry) {
+	fmt.Fprintln(w, "[playlist]")
+	for i, e := range entries {
+		fmt.Fprintf(w, "File%d=%s\n", i+1, filepath.ToSlash(e.Path))
+		fmt.Fprintf(w, "Title%d=%s - %s\n", i+1, e.Artist, e.Title)
+		fmt.Fprintf(w, "Length%d=%d\n", i+1, int(e.Duration.Seconds()))
+	}
+	fmt.Fprintf(w, "NumberOfEntries=%d\n", len(entries))
+	fmt.Fprintln(w, "Version=2")
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title"`
+	Creator  string `xml:"creator"`
+	Duration int64  `xml:"duration"` // milliseconds
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version int         `xml:"version,attr"`
+	Title   string      `xml:"title"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+// writeXSPF writes an XSPF playlist. Locations are relative URIs.
+func writeXSPF(w io.Writer, title string, entries []Entry) error {
+	list := xspfPlaylist{Version: 1, Title: title}
+	for _, e := range entries {
+		list.Tracks = append(list.Tracks, xspfTrack{
+			Location: (&url.URL{Path: filepath.ToSlash(e.Path)}).String(),
+			Title:    e.Title,
+			Creator:  e.Artist,
+			Duration: e.Duration.Milliseconds(),
+		})
+	}
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(list); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
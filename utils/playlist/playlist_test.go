@@ -0,0 +1,81 @@
+package playlist
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var entries = []Entry{
+	{Path: "01. Welcome To New York.m4a", Title: "Welcome To New York (Taylor's Version)", Artist: "Taylor Swift", Duration: 212600 * time.Millisecond},
+	{Path: filepath.Join("Disc 2", "03. Style & Grace #1.m4a"), Title: "Style", Artist: "Taylor Swift", Duration: 231 * time.Second},
+	{Path: "04. Ça ira.m4a", Title: "Ça ira", Artist: "Édith Piaf", Duration: 0},
+}
+
+func TestWrite(t *testing.T) {
+	for _, format := range Formats {
+		t.Run(format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "list."+format)
+			if err := Write(path, format, "1989 & More", entries); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", "list."+format)
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("Write(%s) =\n%s\nwant\n%s", format, got, want)
+			}
+		})
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	if err := Write(filepath.Join(t.TempDir(), "list.wpl"), "wpl", "", entries); err == nil {
+		t.Error("Write(wpl) succeeded")
+	}
+}
+
+func TestManifest(t *testing.T) {
+	folder := t.TempDir()
+	m, err := Load(folder)
+	if err != nil {
+		t.Fatalf("Load() without a manifest: %v", err)
+	}
+	if len(m.Tracks) != 0 {
+		t.Errorf("new manifest has tracks: %v", m.Tracks)
+	}
+	m.Total = 3
+	m.Tracks["1713845540"] = Track{Path: "01. Style.m4a", Number: 1}
+	m.Tracks["1440935468"] = Track{Path: "02. Red.m4a", Number: 2}
+	m.Tracks["1750307021"] = Track{Path: "03. Fortnight.m4a", Number: 3}
+	if err := m.Save(folder); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, m) {
+		t.Errorf("Load() after Save() = %+v, want %+v", loaded, m)
+	}
+	// the playlist now has Red and a new song
+	if got := loaded.Removed([]string{"1440935468", "1649434004"}); !reflect.DeepEqual(got, []string{"1713845540", "1750307021"}) {
+		t.Errorf("Removed() = %v", got)
+	}
+}
@@ -0,0 +1,83 @@
+package progress
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"main/utils/structs"
+)
+
+// JSON writes one JSON object per event to w:
+//
+//	{"event":"track-started","track":1,"total":12,"id":"...","name":"..."}
+//	{"event":"stage","stage":"download"}
+//	{"event":"bytes","stage":"download","done":1048576,"total":31457280}
+//	{"event":"track-finished","result":{...}}
+//	{"event":"warning","message":"..."}
+//
+// Every object also has its time. Byte counts are written at most four
+// times a second, and once more when a stage completes.
+type JSON struct {
+	w     io.Writer
+	mu    sync.Mutex
+	stage Stage
+	last  time.Time
+}
+
+type jsonEvent struct {
+	Event   string               `json:"event"`
+	Time    time.Time            `json:"time"`
+	Track   int                  `json:"track,omitempty"`
+	Total   int64                `json:"total,omitempty"`
+	ID      string               `json:"id,omitempty"`
+	Name    string               `json:"name,omitempty"`
+	Stage   Stage                `json:"stage,omitempty"`
+	Done    int64                `json:"done,omitempty"`
+	Result  *structs.TrackResult `json:"result,omitempty"`
+	Message string               `json:"message,omitempty"`
+}
+
+func (j *JSON) TrackStarted(track Track) {
+	j.write(jsonEvent{Event: "track-started", Track: track.Number, Total: int64(track.Total), ID: track.ID, Name: track.Name})
+}
+
+func (j *JSON) BytesDownloaded(done, total int64) {
+	j.mu.Lock()
+	now := time.Now()
+	if done != total && now.Sub(j.last) < 250*time.Millisecond {
+		j.mu.Unlock()
+		return
+	}
+	j.last = now
+	stage := j.stage
+	j.mu.Unlock()
+	j.write(jsonEvent{Event: "bytes", Stage: stage, Done: done, Total: total})
+}
+
+func (j *JSON) StageChanged(stage Stage) {
+	j.mu.Lock()
+	j.stage, j.last = stage, time.Time{}
+	j.mu.Unlock()
+	j.write(jsonEvent{Event: "stage", Stage: stage})
+}
+
+func (j *JSON) TrackFinished(result structs.TrackResult) {
+	j.write(jsonEvent{Event: "track-finished", Result: &result})
+}
+
+func (j *JSON) Warning(message string) {
+	j.write(jsonEvent{Event: "warning", Message: message})
+}
+
+func (j *JSON) write(e jsonEvent) {
+	e.Time = time.Now()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.w.Write(append(data, '\n'))
+}
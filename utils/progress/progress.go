@@ -0,0 +1,94 @@
+// Package progress carries what a download is doing to an Observer: a bar
+// on the terminal, JSON lines for programs driving the downloader, or
+// nothing at all.
+package progress
+
+import (
+	"context"
+	"io"
+
+	"main/utils/structs"
+)
+
+// Stage is the step a track is at.
+type Stage string
+
+const (
+	Download Stage = "download"
+	Decrypt  Stage = "decrypt"
+	Tag      Stage = "tag"
+)
+
+// Track is a track about to be saved.
+type Track struct {
+	Number int
+	Total  int
+	ID     string
+	Name   string
+}
+
+// Observer receives the events of a download, one track at a time.
+type Observer interface {
+	TrackStarted(track Track)
+	// BytesDownloaded reports done of total bytes handled in the current
+	// stage. total is -1 when it is not known.
+	BytesDownloaded(done, total int64)
+	StageChanged(stage Stage)
+	TrackFinished(result structs.TrackResult)
+	Warning(message string)
+}
+
+// New returns the observer named by --progress: bar, json or quiet.
+// JSON lines go to w.
+func New(name string, w io.Writer) (Observer, bool) {
+	switch name {
+	case "", "bar":
+		return &Terminal{}, true
+	case "json":
+		return &JSON{w: w}, true
+	case "quiet":
+		return Quiet{}, true
+	}
+	return nil, false
+}
+
+type observerKey struct{}
+
+// With returns ctx carrying obs, for the downloads run under it.
+func With(ctx context.Context, obs Observer) context.Context {
+	return context.WithValue(ctx, observerKey{}, obs)
+}
+
+// From returns the observer carried by ctx, Quiet when there is none.
+func From(ctx context.Context) Observer {
+	if obs, ok := ctx.Value(observerKey{}).(Observer); ok && obs != nil {
+		return obs
+	}
+	return Quiet{}
+}
+
+// Writer counts the bytes written to it as BytesDownloaded of total.
+func Writer(obs Observer, total int64) io.Writer {
+	return &counter{obs: obs, total: total}
+}
+
+type counter struct {
+	obs   Observer
+	total int64
+	done  int64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	c.done += int64(len(p))
+	c.obs.BytesDownloaded(c.done, c.total)
+	return len(p), nil
+}
+
+// Quiet drops every event.
+type Quiet struct{}
+
+func (Quiet) TrackStarted(Track)                {}
+func (Quiet) BytesDownloaded(int64, int64)      {}
+func (Quiet) StageChanged(Stage)                {}
+func (Quiet) TrackFinished(structs.TrackResult) {}
+func (Quiet) Warning(string)                    {}
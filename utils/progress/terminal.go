@@ -0,0 +1,91 @@
+package progress
+
+import (
+	"fmt"
+	"sync"
+
+	"main/utils/structs"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// Terminal prints the track headers and a bar for each download and
+// decrypt stage to stdout. Warnings are left to the log.
+type Terminal struct {
+	mu    sync.Mutex
+	stage Stage
+	bar   *progressbar.ProgressBar
+}
+
+var descriptions = map[Stage]string{
+	Download: "Downloading...",
+	Decrypt:  "Decrypting...",
+}
+
+func (t *Terminal) TrackStarted(track Track) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clear()
+	t.stage = ""
+	fmt.Printf("Track %d of %d:\n", track.Number, track.Total)
+}
+
+func (t *Terminal) BytesDownloaded(done, total int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.bar == nil {
+		t.bar = progressbar.NewOptions64(
+			total,
+			progressbar.OptionClearOnFinish(),
+			progressbar.OptionSetElapsedTime(false),
+			progressbar.OptionSetPredictTime(false),
+			progressbar.OptionShowElapsedTimeOnFinish(),
+			progressbar.OptionShowCount(),
+			progressbar.OptionEnableColorCodes(true),
+			progressbar.OptionShowBytes(true),
+			progressbar.OptionSetDescription(descriptions[t.stage]),
+			progressbar.OptionSetTheme(progressbar.Theme{
+				Saucer:        "",
+				SaucerHead:    "",
+				SaucerPadding: "",
+				BarStart:      "",
+				BarEnd:        "",
+			}),
+		)
+	}
+	t.bar.Set64(done)
+}
+
+// StageChanged ends the bar of the last stage, which has then succeeded.
+func (t *Terminal) StageChanged(stage Stage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clear()
+	switch t.stage {
+	case Download:
+		if stage != Download {
+			fmt.Println("Downloaded")
+		}
+	case Decrypt:
+		fmt.Println("Decrypted")
+	}
+	t.stage = stage
+}
+
+func (t *Terminal) TrackFinished(result structs.TrackResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clear()
+	t.stage = ""
+}
+
+func (t *Terminal) Warning(message string) {}
+
+// clear removes the bar from the screen. The caller holds mu.
+func (t *Terminal) clear() {
+	if t.bar != nil {
+		t.bar.Clear()
+		t.bar.Exit()
+		t.bar = nil
+	}
+}
@@ -0,0 +1,215 @@
+package quality
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+// Variant is one audio stream of a master playlist.
+type Variant struct {
+	Codec      string `json:"codec"`                 // CODECS attribute: alac, ec-3, ac-3 or mp4a.40.2
+	Channels   int    `json:"channels,omitempty"`    // from the CHANNELS attribute of the group, 0 when missing
+	Atmos      bool   `json:"atmos,omitempty"`       // CHANNELS ends in /JOC
+	SampleRate int    `json:"sample_rate,omitempty"` // Hz, only set for alac
+	BitDepth   int    `json:"bit_depth,omitempty"`   // only set for alac
+	Bitrate    int    `json:"bitrate,omitempty"`     // kbps, from the group ID for lossy streams
+	Binaural   bool   `json:"binaural,omitempty"`
+	Downmix    bool   `json:"downmix,omitempty"`
+	GroupID    string `json:"group_id"`  // the AUDIO group, e.g. audio-alac-stereo-96000-24
+	URI        string `json:"-"`         // absolute media playlist URL
+	Bandwidth  int    `json:"bandwidth"` // bits per second
+}
+
+var (
+	mediaTag     = regexp.MustCompile(`^#EXT-X-MEDIA:(.*)$`)
+	groupAttr    = regexp.MustCompile(`GROUP-ID="([^"]*)"`)
+	channelsAttr = regexp.MustCompile(`CHANNELS="([^"]*)"`)
+)
+
+// Fetch downloads the master playlist at masterUrl and parses it.
+func Fetch(ctx context.Context, masterUrl string) ([]Variant, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", masterUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMaster(masterUrl, string(body))
+}
+
+// ParseMaster parses a master playlist fetched from masterUrl. Variants are
+// sorted by average bandwidth, highest first.
+func ParseMaster(masterUrl string, body string) ([]Variant, error) {
+	base, err := url.Parse(masterUrl)
+	if err != nil {
+		return nil, err
+	}
+	from, listType, err := m3u8.DecodeFrom(strings.NewReader(body), true)
+	if err != nil || listType != m3u8.MASTER {
+		return nil, errors.New("m3u8 not of master type")
+	}
+	master := from.(*m3u8.MasterPlaylist)
+	sort.SliceStable(master.Variants, func(i, j int) bool {
+		return master.Variants[i].AverageBandwidth > master.Variants[j].AverageBandwidth
+	})
+	channels := groupChannels(body)
+	var variants []Variant
+	for _, mv := range master.Variants {
+		uri, err := base.Parse(mv.URI)
+		if err != nil {
+			return nil, err
+		}
+		v := Variant{
+			Codec:     mv.Codecs,
+			GroupID:   mv.Audio,
+			URI:       uri.String(),
+			Bandwidth: int(mv.Bandwidth),
+		}
+		if ch, ok := channels[mv.Audio]; ok {
+			count, _, _ := strings.Cut(ch, "/")
+			v.Channels, _ = strconv.Atoi(count)
+			v.Atmos = strings.HasSuffix(ch, "/JOC")
+		}
+		parseGroupID(&v)
+		variants = append(variants, v)
+	}
+	return variants, nil
+}
+
+// groupChannels maps audio group IDs to their CHANNELS attribute.
+func groupChannels(body string) map[string]string {
+	channels := make(map[string]string)
+	for _, line := range strings.Split(body, "\n") {
+		m := mediaTag.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		group := groupAttr.FindStringSubmatch(m[1])
+		ch := channelsAttr.FindStringSubmatch(m[1])
+		if group != nil && ch != nil {
+			channels[group[1]] = ch[1]
+		}
+	}
+	return channels
+}
+
+// parseGroupID fills in what Apple encodes in the group ID:
+//
+//	audio-alac-stereo-96000-24   sample rate and bit depth
+//	audio-atmos-2768             bitrate, with a leading 2
+//	audio-ac3-640                bitrate
+//	audio-stereo-256[-binaural]  bitrate
+func parseGroupID(v *Variant) {
+	split := strings.Split(v.GroupID, "-")
+	last, _ := strconv.Atoi(split[len(split)-1])
+	switch v.Codec {
+	case "alac":
+		v.BitDepth = last
+		if len(split) >= 2 {
+			v.SampleRate, _ = strconv.Atoi(split[len(split)-2])
+		}
+		if v.Channels == 0 {
+			v.Channels = 2
+		}
+	case "ec-3":
+		v.Bitrate = last % 1000
+		if strings.Contains(v.GroupID, "atmos") {
+			v.Atmos = true
+		}
+	case "ac-3":
+		v.Bitrate = last
+	case "mp4a.40.2":
+		if len(split) >= 3 {
+			v.Bitrate, _ = strconv.Atoi(split[2])
+		}
+		v.Binaural = strings.HasSuffix(v.GroupID, "-binaural")
+		v.Downmix = strings.HasSuffix(v.GroupID, "-downmix")
+		if v.Channels == 0 {
+			v.Channels = 2
+		}
+	}
+}
+
+// Kind names the stream the way quality-preference does: atmos,
+// dolby-audio, alac, aac, aac-binaural or aac-downmix. Hi-res ALAC is
+// still "alac", see HiRes.
+func (v Variant) Kind() string {
+	switch v.Codec {
+	case "ec-3":
+		if v.Atmos {
+			return "atmos"
+		}
+		return "ec-3"
+	case "ac-3":
+		return "dolby-audio"
+	case "alac":
+		return "alac"
+	case "mp4a.40.2":
+		if v.Binaural {
+			return "aac-binaural"
+		}
+		if v.Downmix {
+			return "aac-downmix"
+		}
+		return "aac"
+	}
+	return v.Codec
+}
+
+// HiRes reports whether v is ALAC above 48 kHz.
+func (v Variant) HiRes() bool {
+	return v.Codec == "alac" && v.SampleRate > 48000
+}
+
+// Label is the {Quality} value used in folder and file names,
+// e.g. 24B-96.0kHz, 2768 kbps or 256 kbps. Existing libraries are named
+// this way, so lossy streams keep the number of the group ID as it is.
+func (v Variant) Label() string {
+	switch v.Codec {
+	case "alac":
+		return fmt.Sprintf("%dB-%.1fkHz", v.BitDepth, float64(v.SampleRate)/1000.0)
+	case "mp4a.40.2":
+		if v.Bitrate == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%d kbps", v.Bitrate)
+	}
+	split := strings.Split(v.GroupID, "-")
+	return split[len(split)-1] + " kbps"
+}
+
+// Describe is a one-line summary for console output,
+// e.g. "ALAC | 2 Channel | 24-bit/96 kHz".
+func (v Variant) Describe() string {
+	switch v.Codec {
+	case "alac":
+		return fmt.Sprintf("ALAC | %d Channel | %d-bit/%d kHz", v.Channels, v.BitDepth, v.SampleRate/1000)
+	case "ec-3":
+		return fmt.Sprintf("E-AC-3 | %d Channel | %d kbps", v.Channels, v.Bitrate)
+	case "ac-3":
+		return fmt.Sprintf("AC-3 | %d Channel | %d kbps", v.Channels, v.Bitrate)
+	case "mp4a.40.2":
+		return fmt.Sprintf("AAC | %d Channel | %d kbps", v.Channels, v.Bitrate)
+	}
+	return v.Codec
+}
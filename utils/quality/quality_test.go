@@ -0,0 +1,113 @@
+package quality
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const masterUrl = "https://aod.itunes.apple.com/itunes-assets/HLSMusic/master.m3u8"
+
+// readMaster parses testdata/name as if fetched from masterUrl.
+func readMaster(t *testing.T, name string) []Variant {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	variants, err := ParseMaster(masterUrl, string(body))
+	if err != nil {
+		t.Fatalf("ParseMaster(%s): %v", name, err)
+	}
+	return variants
+}
+
+func TestParseMaster(t *testing.T) {
+	type want struct {
+		group      string
+		kind       string
+		channels   int
+		sampleRate int
+		bitDepth   int
+		bitrate    int
+		hiRes      bool
+		label      string
+	}
+	tests := []struct {
+		file string
+		want []want // in order of average bandwidth
+	}{
+		{"alac-hires.m3u8", []want{
+			{"audio-alac-stereo-192000-24", "alac", 2, 192000, 24, 0, true, "24B-192.0kHz"},
+			{"audio-alac-stereo-96000-24", "alac", 2, 96000, 24, 0, true, "24B-96.0kHz"},
+			{"audio-alac-stereo-48000-24", "alac", 2, 48000, 24, 0, false, "24B-48.0kHz"},
+			{"audio-alac-stereo-44100-16", "alac", 2, 44100, 16, 0, false, "16B-44.1kHz"},
+			{"audio-stereo-256", "aac", 2, 0, 0, 256, false, "256 kbps"},
+			{"audio-HE-stereo-64", "mp4a.40.5", 2, 0, 0, 0, false, "64 kbps"},
+		}},
+		{"atmos.m3u8", []want{
+			{"audio-alac-stereo-48000-24", "alac", 2, 48000, 24, 0, false, "24B-48.0kHz"},
+			{"audio-atmos-2768", "atmos", 16, 0, 0, 768, false, "2768 kbps"},
+			{"audio-ac3-640", "dolby-audio", 6, 0, 0, 640, false, "640 kbps"},
+			{"audio-atmos-2448", "atmos", 16, 0, 0, 448, false, "2448 kbps"},
+			{"audio-stereo-256", "aac", 2, 0, 0, 256, false, "256 kbps"},
+			{"audio-stereo-256-binaural", "aac-binaural", 2, 0, 0, 256, false, "256 kbps"},
+			{"audio-stereo-256-downmix", "aac-downmix", 2, 0, 0, 256, false, "256 kbps"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			variants := readMaster(t, tt.file)
+			if len(variants) != len(tt.want) {
+				t.Fatalf("got %d variants, want %d", len(variants), len(tt.want))
+			}
+			for i, w := range tt.want {
+				v := variants[i]
+				got := want{v.GroupID, v.Kind(), v.Channels, v.SampleRate, v.BitDepth, v.Bitrate, v.HiRes(), v.Label()}
+				if got != w {
+					t.Errorf("variant %d = %+v, want %+v", i, got, w)
+				}
+				if !strings.HasPrefix(v.URI, strings.TrimSuffix(masterUrl, "master.m3u8")+"P") {
+					t.Errorf("variant %d URI %s is not next to the master playlist", i, v.URI)
+				}
+			}
+		})
+	}
+}
+
+func TestParseMasterRejectsMediaPlaylists(t *testing.T) {
+	media := "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10,\nsegment.mp4\n#EXT-X-ENDLIST\n"
+	if _, err := ParseMaster(masterUrl, media); err == nil {
+		t.Error("ParseMaster accepted a media playlist")
+	}
+}
+
+func TestParseGroupID(t *testing.T) {
+	tests := []struct {
+		codec   string
+		group   string
+		want    Variant
+		comment string
+	}{
+		{"alac", "audio-alac-stereo-96000-24", Variant{SampleRate: 96000, BitDepth: 24, Channels: 2}, "rate and depth"},
+		{"alac", "audio-alac-stereo-44100-16", Variant{SampleRate: 44100, BitDepth: 16, Channels: 2}, "CD quality"},
+		{"ec-3", "audio-atmos-2768", Variant{Bitrate: 768, Atmos: true}, "leading 2 dropped"},
+		{"ec-3", "audio-atmos-2448", Variant{Bitrate: 448, Atmos: true}, "lower atmos"},
+		{"ac-3", "audio-ac3-640", Variant{Bitrate: 640}, "dolby audio"},
+		{"mp4a.40.2", "audio-stereo-256", Variant{Bitrate: 256, Channels: 2}, "plain aac"},
+		{"mp4a.40.2", "audio-stereo-256-binaural", Variant{Bitrate: 256, Channels: 2, Binaural: true}, "binaural"},
+		{"mp4a.40.2", "audio-stereo-256-downmix", Variant{Bitrate: 256, Channels: 2, Downmix: true}, "downmix"},
+		{"mp4a.40.5", "audio-HE-stereo-64", Variant{}, "HE-AAC is not parsed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.comment, func(t *testing.T) {
+			v := Variant{Codec: tt.codec, GroupID: tt.group}
+			parseGroupID(&v)
+			tt.want.Codec, tt.want.GroupID = tt.codec, tt.group
+			if v != tt.want {
+				t.Errorf("parseGroupID(%s) = %+v, want %+v", tt.group, v, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package quality
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// Columns are the formats of a quality report, in order.
+var Columns = []string{"AAC", "Lossless", "Hi-Res", "Atmos", "Dolby Audio"}
+
+// TrackReport is one row of a quality report. Formats maps a column to the
+// best variant of that format; missing columns are not available.
+type TrackReport struct {
+	Number  int                `json:"number"`
+	Name    string             `json:"name"`
+	ID      string             `json:"id"`
+	Formats map[string]Variant `json:"formats"`
+}
+
+// AlbumReport is the quality report of an album or playlist.
+type AlbumReport struct {
+	Name    string        `json:"name"`
+	ID      string        `json:"id"`
+	Tracks  []TrackReport `json:"tracks"`
+	Rollups []string      `json:"rollups"`
+}
+
+// Report is the matrix of one album, playlist or artist discography, with
+// rollups over all of its tracks.
+type Report struct {
+	Name    string        `json:"name"`
+	ID      string        `json:"id"`
+	Albums  []AlbumReport `json:"albums"`
+	Rollups []string      `json:"rollups"`
+}
+
+// ReportFormats are the outputs of WriteReport.
+var ReportFormats = []string{"table", "csv", "json"}
+
+// Best returns the best variant of each report column.
+func Best(variants []Variant) map[string]Variant {
+	best := make(map[string]Variant)
+	for _, v := range variants {
+		var column string
+		switch {
+		case v.Codec == "mp4a.40.2":
+			column = "AAC"
+		case v.HiRes():
+			column = "Hi-Res"
+		case v.Codec == "alac":
+			column = "Lossless"
+		case v.Kind() == "atmos":
+			column = "Atmos"
+		case v.Codec == "ac-3":
+			column = "Dolby Audio"
+		default:
+			continue
+		}
+		if current, ok := best[column]; !ok || v.better(current) {
+			best[column] = v
+		}
+	}
+	return best
+}
+
+// better orders variants of the same column.
+func (v Variant) better(other Variant) bool {
+	if v.Codec == "alac" {
+		if v.SampleRate != other.SampleRate {
+			return v.SampleRate > other.SampleRate
+		}
+		return v.BitDepth > other.BitDepth
+	}
+	return v.Bitrate > other.Bitrate
+}
+
+// Short is the cell text of a report, e.g. 24/192 or 768 kbps.
+func (v Variant) Short() string {
+	if v.Codec == "alac" {
+		return fmt.Sprintf("%d/%d", v.BitDepth, v.SampleRate/1000)
+	}
+	return fmt.Sprintf("%d kbps", v.Bitrate)
+}
+
+// Rollup fills in a.Rollups, one line per available column,
+// e.g. "12/14 tracks Hi-Res 24/192".
+func (a *AlbumReport) Rollup() {
+	a.Rollups = rollup(a.Tracks)
+}
+
+// Rollup fills in the rollups of r and of each of its albums.
+func (r *Report) Rollup() {
+	var tracks []TrackReport
+	for i := range r.Albums {
+		r.Albums[i].Rollup()
+		tracks = append(tracks, r.Albums[i].Tracks...)
+	}
+	r.Rollups = rollup(tracks)
+}
+
+func rollup(tracks []TrackReport) []string {
+	var rollups []string
+	for _, column := range Columns {
+		var best Variant
+		count := 0
+		for _, track := range tracks {
+			if v, ok := track.Formats[column]; ok {
+				if count == 0 || v.better(best) {
+					best = v
+				}
+				count++
+			}
+		}
+		if count > 0 {
+			rollups = append(rollups, fmt.Sprintf("%d/%d tracks %s %s", count, len(tracks), column, best.Short()))
+		}
+	}
+	return rollups
+}
+
+// WriteReport writes reports to w as a table, csv or json. A table is one
+// matrix per report, with an Album column when it has several albums.
+func WriteReport(w io.Writer, reports []Report, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(reports)
+	case "csv":
+		out := csv.NewWriter(w)
+		out.Write(append([]string{"Report", "Report ID", "Album", "Album ID", "#", "Track", "Track ID"}, Columns...))
+		for _, report := range reports {
+			for _, album := range report.Albums {
+				for _, track := range album.Tracks {
+					out.Write(append([]string{report.Name, report.ID, album.Name, album.ID, strconv.Itoa(track.Number), track.Name, track.ID}, cells(track)...))
+				}
+			}
+		}
+		out.Flush()
+		return out.Error()
+	case "table", "":
+		for _, report := range reports {
+			fmt.Fprintf(w, "%s (%s)\n", report.Name, report.ID)
+			several := len(report.Albums) > 1
+			header := append([]string{"#", "Track"}, Columns...)
+			if several {
+				header = append([]string{"Album"}, header...)
+			}
+			table := tablewriter.NewWriter(w)
+			table.SetHeader(header)
+			table.SetRowLine(false)
+			if several {
+				table.SetAutoMergeCellsByColumnIndex([]int{0})
+			}
+			for _, album := range report.Albums {
+				for _, track := range album.Tracks {
+					row := append([]string{strconv.Itoa(track.Number), track.Name}, cells(track)...)
+					if several {
+						row = append([]string{album.Name}, row...)
+					}
+					table.Append(row)
+				}
+			}
+			table.Render()
+			if several {
+				for _, album := range report.Albums {
+					if len(album.Rollups) > 0 {
+						fmt.Fprintf(w, "%s: %s\n", album.Name, strings.Join(album.Rollups, "  |  "))
+					}
+				}
+			}
+			if len(report.Rollups) > 0 {
+				fmt.Fprintln(w, strings.Join(report.Rollups, "  |  "))
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown report format: %s, use %s", format, strings.Join(ReportFormats, ", "))
+}
+
+func cells(track TrackReport) []string {
+	var row []string
+	for _, column := range Columns {
+		if v, ok := track.Formats[column]; ok {
+			row = append(row, v.Short())
+		} else {
+			row = append(row, "-")
+		}
+	}
+	return row
+}
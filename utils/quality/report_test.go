@@ -0,0 +1,108 @@
+package quality
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testReport is an artist report of two albums: 1989 with a Hi-Res track
+// and an Atmos track, and Red with a track only in AAC.
+func testReport(t *testing.T) Report {
+	t.Helper()
+	aac := Variant{Codec: "mp4a.40.2", GroupID: "audio-stereo-256", Bitrate: 256, Channels: 2}
+	report := Report{Name: "Taylor Swift", ID: "159260351", Albums: []AlbumReport{
+		{Name: "1989 (Taylor's Version)", ID: "1713845538", Tracks: []TrackReport{
+			{Number: 1, Name: "Welcome To New York", ID: "1713845539", Formats: Best(readMaster(t, "alac-hires.m3u8"))},
+			{Number: 2, Name: "Blank Space", ID: "1713845541", Formats: Best(readMaster(t, "atmos.m3u8"))},
+		}},
+		{Name: "Red", ID: "1440935467", Tracks: []TrackReport{
+			{Number: 1, Name: "State Of Grace", ID: "1440935468", Formats: map[string]Variant{"AAC": aac}},
+		}},
+	}}
+	report.Rollup()
+	return report
+}
+
+func TestBest(t *testing.T) {
+	tests := []struct {
+		file string
+		want map[string]string
+	}{
+		// HE-AAC is left out, the Hi-Res and Lossless columns split the ALAC variants
+		{"alac-hires.m3u8", map[string]string{"AAC": "256 kbps", "Lossless": "24/48", "Hi-Res": "24/192"}},
+		{"atmos.m3u8", map[string]string{"AAC": "256 kbps", "Lossless": "24/48", "Atmos": "768 kbps", "Dolby Audio": "640 kbps"}},
+	}
+	for _, tt := range tests {
+		got := make(map[string]string)
+		for column, v := range Best(readMaster(t, tt.file)) {
+			got[column] = v.Short()
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Best(%s) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestRollup(t *testing.T) {
+	report := testReport(t)
+	tests := []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{"1989", report.Albums[0].Rollups, []string{
+			"2/2 tracks AAC 256 kbps",
+			"2/2 tracks Lossless 24/48",
+			"1/2 tracks Hi-Res 24/192",
+			"1/2 tracks Atmos 768 kbps",
+			"1/2 tracks Dolby Audio 640 kbps",
+		}},
+		{"Red", report.Albums[1].Rollups, []string{"1/1 tracks AAC 256 kbps"}},
+		{"artist", report.Rollups, []string{
+			"3/3 tracks AAC 256 kbps",
+			"2/3 tracks Lossless 24/48",
+			"1/3 tracks Hi-Res 24/192",
+			"1/3 tracks Atmos 768 kbps",
+			"1/3 tracks Dolby Audio 640 kbps",
+		}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s rollups = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	reports := []Report{testReport(t)}
+	for _, format := range ReportFormats {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteReport(&buf, reports, format); err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", "report."+format)
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != string(want) {
+				t.Errorf("WriteReport(%s) =\n%s\nwant\n%s", format, buf.String(), want)
+			}
+		})
+	}
+	if err := WriteReport(&bytes.Buffer{}, reports, "xml"); err == nil {
+		t.Error("WriteReport(xml) succeeded")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
@@ -17,8 +18,10 @@ import (
 	"github.com/grafov/m3u8"
 
 	"encoding/binary"
-	"github.com/schollz/progressbar/v3"
 
+	"main/utils/failure"
+	"main/utils/logs"
+	"main/utils/progress"
 	"main/utils/structs"
 )
 const prefetchKey = "skd://itunes.apple.com/P000000000/s1/e1"
@@ -36,7 +39,6 @@ func (b *TimedResponseBody) Read(p []byte) (int, error) {
 	if err != nil {
 		return n, err
 	}
-	// fmt.Printf("Read %d bytes, buffer size %d bytes", n, len(p))
 	if n >= b.threshold {
 		b.timer.Reset(b.timeout)
 	}
@@ -44,15 +46,19 @@ func (b *TimedResponseBody) Read(p []byte) (int, error) {
 }
 
 
-func Run(adamId string, playlistUrl string, outfile string, Config structs.ConfigSet) error {
+// Run downloads and decrypts the song at playlistUrl into outfile. When
+// ctx is cancelled it stops and removes what was written of outfile.
+// Progress goes to the observer carried by ctx.
+func Run(ctx context.Context, adamId string, playlistUrl string, outfile string, Config structs.ConfigSet) error {
 	var err error
+	obs := progress.From(ctx)
 	var optstimeout uint
 	optstimeout = 0
 	timeout := time.Duration(optstimeout * uint(time.Millisecond))
 	header := make(http.Header)
 
 	// request media playlist
-	req, err := http.NewRequest("GET", playlistUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", playlistUrl, nil)
 	if err != nil {
 		return err
 	}
@@ -87,7 +93,7 @@ func Run(adamId string, playlistUrl string, outfile string, Config structs.Confi
 	}
 
 	// request mp4
-	ctx, cancel := context.WithCancelCause(context.Background())
+	ctx, cancel := context.WithCancelCause(ctx)
 	defer cancel(nil)
 	req, err = http.NewRequestWithContext(ctx, "GET", fileUrl.String(), nil)
 	if err != nil {
@@ -120,27 +126,12 @@ func Run(adamId string, playlistUrl string, outfile string, Config structs.Confi
 		defer do.Body.Close()
 		if do.ContentLength < int64(Config.MaxMemoryLimit * 1024 * 1024) {
 			var buffer bytes.Buffer
-			bar := progressbar.NewOptions64(
-				do.ContentLength,
-				progressbar.OptionClearOnFinish(),
-				progressbar.OptionSetElapsedTime(false),
-				progressbar.OptionSetPredictTime(false),
-				progressbar.OptionShowElapsedTimeOnFinish(),
-				progressbar.OptionShowCount(),
-				progressbar.OptionEnableColorCodes(true),
-				progressbar.OptionShowBytes(true),
-				progressbar.OptionSetDescription("Downloading..."),
-				progressbar.OptionSetTheme(progressbar.Theme{
-					Saucer:        "",
-					SaucerHead:    "",
-					SaucerPadding: "",
-					BarStart:      "",
-					BarEnd:        "",
-				}),
-			)
-			io.Copy(io.MultiWriter(&buffer, bar), do.Body)
+			obs.StageChanged(progress.Download)
+			_, err = io.Copy(io.MultiWriter(&buffer, progress.Writer(obs, do.ContentLength)), do.Body)
+			if err != nil {
+				return err
+			}
 			body = &buffer
-			fmt.Print("Downloaded\n")
 		} else {
 			body = do.Body
 		}
@@ -151,23 +142,34 @@ func Run(adamId string, playlistUrl string, outfile string, Config structs.Confi
 	// connect to decryptor
 	//addr := fmt.Sprintf("127.0.0.1:10020")
 	addr := Config.DecryptM3u8Port
-	conn, err := net.Dial("tcp", addr)
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return err
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %v", failure.ErrDecryptorUnavailable, err)
 	}
-	//fmt.Print("Decrypting...\n")
+	logs.From(ctx).Debug("decrypting", "id", adamId, "bytes", totalLen, "decryptor", addr)
 	defer Close(conn)
+	// unblock a read from the decryptor when ctx is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
 
-	err = downloadAndDecryptFile(conn, body, outfile, adamId, segments, totalLen, Config)
+	err = downloadAndDecryptFile(ctx, conn, body, outfile, adamId, segments, totalLen, Config)
 	if err != nil {
+		// a partly written file would pass for a downloaded one
+		os.Remove(outfile)
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
 		return err
 	}
-	fmt.Print("Decrypted\n")
 	return nil
 }
 
-func downloadAndDecryptFile(conn io.ReadWriter, in io.Reader, outfile string,
+func downloadAndDecryptFile(ctx context.Context, conn io.ReadWriter, in io.Reader, outfile string,
 	adamId string, playlistSegments []*m3u8.MediaSegment, totalLen int64, Config structs.ConfigSet) error {
+	obs := progress.From(ctx)
 	var buffer bytes.Buffer
 	var outBuf *bufio.Writer
 	MaxMemorySize := int64(Config.MaxMemoryLimit * 1024 * 1024)
@@ -197,7 +199,8 @@ func downloadAndDecryptFile(conn io.ReadWriter, in io.Reader, outfile string,
 	err = sanitizeInit(init)
 	if err != nil {
 		// errors returned by sanitizeInit are non-fatal
-		fmt.Printf("Warning: unable to sanitize init completely: %s\n", err)
+		logs.From(ctx).Warn("unable to sanitize init completely", "err", err)
+		obs.Warning(fmt.Sprintf("unable to sanitize init completely: %s", err))
 	}
 	err = init.Encode(outBuf)
 	if err != nil {
@@ -205,31 +208,12 @@ func downloadAndDecryptFile(conn io.ReadWriter, in io.Reader, outfile string,
 	}
 
 	// 'segment' in m3u8 == 'fragment' in mp4ff
-	//fmt.Println("Starting decryption...")
-	bar := progressbar.NewOptions64(totalLen,
-		progressbar.OptionClearOnFinish(),
-		progressbar.OptionSetElapsedTime(false),
-		progressbar.OptionSetPredictTime(false),
-		progressbar.OptionShowElapsedTimeOnFinish(),
-		progressbar.OptionShowCount(),
-		progressbar.OptionEnableColorCodes(true),
-		progressbar.OptionShowBytes(true),
-		progressbar.OptionSetDescription("Decrypting..."),
-		progressbar.OptionSetTheme(progressbar.Theme{
-			Saucer:        "",
-			SaucerHead:    "",
-			SaucerPadding: "",
-			BarStart:      "",
-			BarEnd:        "",
-		}),
-	)
-	bar.Add64(int64(offset))
+	obs.StageChanged(progress.Decrypt)
+	obs.BytesDownloaded(int64(offset), totalLen)
 	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
 	for i := 0; ; i++ {
 		var frag *mp4.Fragment
-		rawoffset := offset
 		frag, offset, err = ReadNextFragment(inBuf, offset)
-		rawoffset = offset - rawoffset
 		if err != nil {
 			return err
 		}
@@ -237,11 +221,6 @@ func downloadAndDecryptFile(conn io.ReadWriter, in io.Reader, outfile string,
 			// check offset against Content-Length?
 			break
 		}
-		// print progress
-
-		// if totalLen > 0 {
-		// 	fmt.Printf("%.2f%% of %d bytes\n", 100*float32(offset)/float32(totalLen), totalLen)
-		// }
 		segment := playlistSegments[i]
 		if segment == nil {
 			return errors.New("segment number out of sync")
@@ -267,7 +246,7 @@ func downloadAndDecryptFile(conn io.ReadWriter, in io.Reader, outfile string,
 		if err != nil {
 			return err
 		}
-		bar.Add64(int64(rawoffset))
+		obs.BytesDownloaded(int64(offset), totalLen)
 	}
 	err = outBuf.Flush()
 	if err != nil {
@@ -394,7 +373,6 @@ func ReadNextFragment(r io.Reader, offset uint64) (*mp4.Fragment, uint64, error)
 			return nil, offset, err
 		}
 		boxType := box.Type()
-		// fmt.Printf("processing %s, box starts @ offset %d\n", boxType, offset)
 		offset += box.Size()
 		if boxType == "moof" || boxType == "emsg" || boxType == "prft" {
 			frag.AddChild(box)
@@ -404,7 +382,7 @@ func ReadNextFragment(r io.Reader, offset uint64) (*mp4.Fragment, uint64, error)
 			frag.AddChild(box)
 			break
 		}
-		fmt.Printf("ignoring a %s box found mid-stream", boxType)
+		slog.Warn("ignoring a box found mid-stream", "box", boxType)
 	}
 	// only 1 mdat box in fragment, meaning that the box doesn't have a preceding moof box
 	if frag.Moof == nil {
@@ -5,7 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"github.com/gospider007/requests"
-	"log/slog"
+	"main/utils/logs"
 	"main/utils/runv3/cdm"
 )
 
@@ -22,17 +22,17 @@ func (w *Key) GetKey(ctx context.Context, licenseServerURL string, PSSH string,
 	initData, err := base64.StdEncoding.DecodeString(PSSH)
 	var keybt []byte
 	if err != nil {
-		slog.Error("pssh decode error: %v", err)
+		logs.From(ctx).Error("pssh decode error", "err", err)
 		return "", keybt, err
 	}
 	cdm, err := wv.NewDefaultCDM(initData)
 	if err != nil {
-		slog.Error("cdm init error: %v", err)
+		logs.From(ctx).Error("cdm init error", "err", err)
 		return "", keybt, err
 	}
 	licenseRequest, err := cdm.GetLicenseRequest()
 	if err != nil {
-		slog.Error("license request error: %v", err)
+		logs.From(ctx).Error("license request error", "err", err)
 		return "", keybt, err
 	}
 	var response *requests.Response
@@ -47,7 +47,7 @@ func (w *Key) GetKey(ctx context.Context, licenseServerURL string, PSSH string,
 	}
 
 	if err != nil {
-		slog.Error("license request error: %s", err)
+		logs.From(ctx).Error("license request error", "err", err)
 		return "", keybt, err
 	}
 	var licenseResponse []byte
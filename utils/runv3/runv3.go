@@ -11,6 +11,9 @@ import (
 	//"log/slog"
 	cdm "main/utils/runv3/cdm"
 	key "main/utils/runv3/key"
+	"main/utils/failure"
+	"main/utils/logs"
+	"main/utils/progress"
 	"os"
 
 	"bytes"
@@ -26,7 +29,6 @@ import (
 	"strings"
 
 	"github.com/grafov/m3u8"
-	"github.com/schollz/progressbar/v3"
 )
 
 type PlaybackLicense struct {
@@ -91,7 +93,7 @@ func BeforeRequest(cl *requests.Client, preCtx context.Context, method string, h
 	options[0].Json = jsondata
 	resp, err = cl.Request(preCtx, method, href, options...)
 	if err != nil {
-		fmt.Println(err)
+		logs.From(preCtx).Error("license request failed", "err", err)
 	}
 
 	return
@@ -111,19 +113,19 @@ func AfterRequest(Response *requests.Response) ([]byte, error) {
 	}
 	return License, nil
 }
-func GetWebplayback(adamId string, authtoken string, mutoken string, mvmode bool) (string, string, error) {
+func GetWebplayback(ctx context.Context, adamId string, authtoken string, mutoken string, mvmode bool) (string, string, error) {
 	url := "https://play.music.apple.com/WebObjects/MZPlay.woa/wa/webPlayback"
 	postData := map[string]string{
 		"salableAdamId": adamId,
 	}
 	jsonData, err := json.Marshal(postData)
 	if err != nil {
-		fmt.Println("Error encoding JSON:", err)
+		logs.From(ctx).Error("failed to encode webPlayback request", "err", err)
 		return "", "", err
 	}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(jsonData)))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer([]byte(jsonData)))
 	if err != nil {
-		fmt.Println("Error creating request:", err)
+		logs.From(ctx).Error("failed to create webPlayback request", "err", err)
 		return "", "", err
 	}
 	req.Header.Set("Content-Type", "application/json")
@@ -138,15 +140,18 @@ func GetWebplayback(adamId string, authtoken string, mutoken string, mvmode bool
 	// 发送请求
 	//resp, err := client.Do(req)
 	if err != nil {
-		fmt.Println("Error sending request:", err)
+		logs.From(ctx).Error("webPlayback request failed", "err", err)
 		return "", "", err
 	}
 	defer resp.Body.Close()
-	//fmt.Println("Response Status:", resp.Status)
+	if resp.StatusCode != http.StatusOK {
+		logs.From(ctx).Error("webPlayback request failed", "status", resp.Status)
+		return "", "", failure.Response(resp)
+	}
 	obj := new(Songlist)
 	err = json.NewDecoder(resp.Body).Decode(&obj)
 	if err != nil {
-		fmt.Println("json err:", err)
+		logs.From(ctx).Error("failed to decode webPlayback response", "err", err)
 		return "", "", err
 	}
 	if len(obj.List) > 0 {
@@ -156,7 +161,7 @@ func GetWebplayback(adamId string, authtoken string, mutoken string, mvmode bool
 		// 遍历 Assets
 		for i, _ := range obj.List[0].Assets {
 			if obj.List[0].Assets[i].Flavor == "28:ctrp256" {
-				kidBase64, fileurl, err := extractKidBase64(obj.List[0].Assets[i].URL, false)
+				kidBase64, fileurl, err := extractKidBase64(ctx, obj.List[0].Assets[i].URL, false)
 				if err != nil {
 					return "", "", err
 				}
@@ -180,8 +185,12 @@ type Songlist struct {
 	Status int `json:"status"`
 }
 
-func extractKidBase64(b string, mvmode bool) (string, string, error) {
-	resp, err := http.Get(b)
+func extractKidBase64(ctx context.Context, b string, mvmode bool) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", b, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", "", err
 	}
@@ -211,11 +220,11 @@ func extractKidBase64(b string, mvmode bool) (string, string, error) {
 			urlBuilder.WriteString("/")
 			urlBuilder.WriteString(mediaPlaylist.Map.URI)
 			//fileurl = b[:lastSlashIndex] + "/" + mediaPlaylist.Map.URI
-			//fmt.Println("Extracted URI:", mediaPlaylist.Map.URI)
+			logs.From(ctx).Debug("init segment", "uri", mediaPlaylist.Map.URI)
 			if mvmode {
 				for _, segment := range mediaPlaylist.Segments {
 					if segment != nil {
-						//fmt.Println("Extracted URI:", segment.URI)
+						logs.From(ctx).Debug("segment", "uri", segment.URI)
 						urlBuilder.WriteString(";")
 						urlBuilder.WriteString(b[:lastSlashIndex])
 						urlBuilder.WriteString("/")
@@ -225,66 +234,56 @@ func extractKidBase64(b string, mvmode bool) (string, string, error) {
 				}
 			}
 		} else {
-			fmt.Println("No key information found")
+			logs.From(ctx).Warn("no key information found", "url", b)
 		}
 	} else {
-		fmt.Println("Not a media playlist")
+		logs.From(ctx).Warn("not a media playlist", "url", b)
 	}
 	return kidbase64, urlBuilder.String(), nil
 }
-func extsong(b string) bytes.Buffer {
-	resp, err := http.Get(b)
+func extsong(ctx context.Context, b string) (bytes.Buffer, error) {
+	var buffer bytes.Buffer
+	req, err := http.NewRequestWithContext(ctx, "GET", b, nil)
+	if err != nil {
+		return buffer, err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		fmt.Printf("下载文件失败: %v\n", err)
+		logs.From(ctx).Error("failed to download song", "err", err)
+		return buffer, err
 	}
 	defer resp.Body.Close()
-	var buffer bytes.Buffer
-	bar := progressbar.NewOptions64(
-		resp.ContentLength,
-		progressbar.OptionClearOnFinish(),
-		progressbar.OptionSetElapsedTime(false),
-		progressbar.OptionSetPredictTime(false),
-		progressbar.OptionShowElapsedTimeOnFinish(),
-		progressbar.OptionShowCount(),
-		progressbar.OptionEnableColorCodes(true),
-		progressbar.OptionShowBytes(true),
-		progressbar.OptionSetDescription("Downloading..."),
-		progressbar.OptionSetTheme(progressbar.Theme{
-			Saucer:        "",
-			SaucerHead:    "",
-			SaucerPadding: "",
-			BarStart:      "",
-			BarEnd:        "",
-		}),
-	)
-	io.Copy(io.MultiWriter(&buffer, bar), resp.Body)
-	return buffer
+	obs := progress.From(ctx)
+	obs.StageChanged(progress.Download)
+	_, err = io.Copy(io.MultiWriter(&buffer, progress.Writer(obs, resp.ContentLength)), resp.Body)
+	return buffer, err
 }
-func Run(adamId string, trackpath string, authtoken string, mutoken string, mvmode bool) (string, error) {
+// Run gets the key of a song and saves it decrypted into trackpath, or for
+// music videos returns the key and segment URLs for ExtMvData.
+func Run(ctx context.Context, adamId string, trackpath string, authtoken string, mutoken string, mvmode bool) (string, error) {
 	var keystr string //for mv key
 	var fileurl string
 	var kidBase64 string
 	var err error
 	if mvmode {
-		kidBase64, fileurl, err = extractKidBase64(trackpath, true)
+		kidBase64, fileurl, err = extractKidBase64(ctx, trackpath, true)
 		if err != nil {
 			return "", err
 		}
 	} else {
-		fileurl, kidBase64, err = GetWebplayback(adamId, authtoken, mutoken, false)
+		fileurl, kidBase64, err = GetWebplayback(ctx, adamId, authtoken, mutoken, false)
 		if err != nil {
 			return "", err
 		}
 	}
-	ctx := context.Background()
 	ctx = context.WithValue(ctx, "pssh", kidBase64)
 	ctx = context.WithValue(ctx, "adamId", adamId)
 	pssh, err := getPSSH("", kidBase64)
-	//fmt.Println(pssh)
 	if err != nil {
-		fmt.Println(err)
+		logs.From(ctx).Error("failed to build pssh", "err", err)
 		return "", err
 	}
+	logs.From(ctx).Debug("pssh", "pssh", pssh)
 	headers := map[string]interface{}{
 		"authorization":            "Bearer " + authtoken,
 		"x-apple-music-user-token": mutoken,
@@ -300,92 +299,94 @@ func Run(adamId string, trackpath string, authtoken string, mutoken string, mvmo
 	key.CdmInit()
 	keystr, keybt, err := key.GetKey(ctx, "https://play.itunes.apple.com/WebObjects/MZPlay.woa/wa/acquireWebPlaybackLicense", pssh, nil)
 	if err != nil {
-		fmt.Println(err)
+		logs.From(ctx).Error("failed to get key", "err", err)
 		return "", err
 	}
 	if mvmode {
 		keyAndUrls := "1:" + keystr + ";" + fileurl
 		return keyAndUrls, nil
 	}
-	body := extsong(fileurl)
-	fmt.Print("Downloaded\n")
+	body, err := extsong(ctx, fileurl)
+	if err != nil {
+		return "", err
+	}
+	progress.From(ctx).StageChanged(progress.Decrypt)
 	//bodyReader := bytes.NewReader(body)
 	var buffer bytes.Buffer
 
 	err = DecryptMP4(&body, keybt, &buffer)
 	if err != nil {
-		fmt.Print("Decryption failed\n")
 		return "", err
-	} else {
-		fmt.Print("Decrypted\n")
 	}
 	// create output file
 	ofh, err := os.Create(trackpath)
 	if err != nil {
-		fmt.Printf("创建文件失败: %v\n", err)
+		logs.From(ctx).Error("failed to create file", "path", trackpath, "err", err)
 		return "", err
 	}
 	defer ofh.Close()
 
 	_, err = ofh.Write(buffer.Bytes())
 	if err != nil {
-		fmt.Printf("写入文件失败: %v\n", err)
+		logs.From(ctx).Error("failed to write file", "path", trackpath, "err", err)
+		ofh.Close()
+		os.Remove(trackpath)
 		return "", err
 	}
 	return "", nil
 }
 
-func ExtMvData(keyAndUrls string, savePath string) error {
+// ExtMvData downloads the segments listed by Run and decrypts them into
+// savePath with mp4decrypt.
+func ExtMvData(ctx context.Context, keyAndUrls string, savePath string) error {
 	segments := strings.Split(keyAndUrls, ";")
 	key := segments[0]
-	//fmt.Println(key)
 	urls := segments[1:]
 	tempFile, err := os.CreateTemp("", "enc_mv_data-*.mp4")
 	if err != nil {
-		fmt.Printf("创建文件失败：%v\n", err)
+		logs.From(ctx).Error("failed to create temporary file", "err", err)
 		return err
 	}
 	defer tempFile.Close()
 	defer os.Remove(tempFile.Name())
 
 	// 依次下载每个链接并写入文件
-	bar := progressbar.DefaultBytes(
-		-1,
-		"Downloading...",
-	)
-	barWriter := io.MultiWriter(tempFile, bar)
+	obs := progress.From(ctx)
+	obs.StageChanged(progress.Download)
+	barWriter := io.MultiWriter(tempFile, progress.Writer(obs, -1))
 	for _, url := range urls {
-		resp, err := http.Get(url)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 		if err != nil {
-			fmt.Printf("下载链接 %s 失败：%v\n", url, err)
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			logs.From(ctx).Error("failed to download segment", "url", url, "err", err)
 			return err
 		}
 		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("链接 %s 响应失败：%v\n", url, resp.Status)
+			logs.From(ctx).Error("failed to download segment", "url", url, "status", resp.Status)
 			return errors.New(resp.Status)
 		}
 		// 将响应体写入输出文件
 		_, err = io.Copy(barWriter, resp.Body)
 		defer resp.Body.Close() // 注意及时关闭响应体，避免资源泄露
 		if err != nil {
-			fmt.Printf("写入文件失败：%v\n", err)
+			logs.From(ctx).Error("failed to write segment", "url", url, "err", err)
 			return err
 		}
-
-		//fmt.Printf("第 %d 个链接 %s 下载并写入完成\n", idx+1, url)
+		logs.From(ctx).Debug("segment saved", "url", url)
 	}
 	tempFile.Close()
-	fmt.Println("\nDownloaded.")
+	obs.StageChanged(progress.Decrypt)
 
-	cmd1 := exec.Command("mp4decrypt", "--key", key, tempFile.Name(), filepath.Base(savePath))
+	cmd1 := exec.CommandContext(ctx, "mp4decrypt", "--key", key, tempFile.Name(), filepath.Base(savePath))
 	cmd1.Dir = filepath.Dir(savePath) //设置mp4decrypt的工作目录以解决中文路径错误
 	outlog, err := cmd1.CombinedOutput()
 	if err != nil {
-		fmt.Printf("Decrypt failed: %v\n", err)
-		fmt.Printf("Output:\n%s\n", outlog)
+		logs.From(ctx).Error("mp4decrypt failed", "err", err, "output", string(outlog))
+		os.Remove(savePath)
 		return err
-	} else {
-		fmt.Println("Decrypted.")
 	}
 	return nil
 }
@@ -0,0 +1,128 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"main/utils/amurl"
+	"main/utils/failure"
+)
+
+// Types are the result types of a catalog search, in display order.
+var Types = []string{"songs", "albums", "artists", "playlists", "music-videos"}
+
+// Result is one item found by Search.
+type Result struct {
+	Type        string // one of Types
+	ID          string
+	Name        string
+	ArtistName  string // the curator for playlists
+	ReleaseDate string
+	URL         string
+}
+
+type resource struct {
+	ID         string `json:"id"`
+	Attributes struct {
+		Name        string `json:"name"`
+		ArtistName  string `json:"artistName"`
+		CuratorName string `json:"curatorName"`
+		ReleaseDate string `json:"releaseDate"`
+		URL         string `json:"url"`
+	} `json:"attributes"`
+}
+
+// Search looks term up in the catalog of storefront, up to limit results
+// of each of types.
+func Search(ctx context.Context, storefront, term string, types []string, limit int, language, token string) ([]Result, error) {
+	query := url.Values{}
+	query.Set("term", term)
+	query.Set("types", strings.Join(types, ","))
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("l", language)
+	req, err := http.NewRequestWithContext(ctx, "GET", amurl.API(fmt.Sprintf("/v1/catalog/%s/search?%s", storefront, query.Encode())), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+	req.Header.Set("Origin", "https://music.apple.com")
+	do, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer do.Body.Close()
+	if do.StatusCode != http.StatusOK {
+		return nil, failure.Response(do)
+	}
+	var obj struct {
+		Results map[string]struct {
+			Data []resource `json:"data"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(do.Body).Decode(&obj); err != nil {
+		return nil, err
+	}
+	var results []Result
+	for _, t := range Types {
+		if !contains(types, t) {
+			continue
+		}
+		for _, r := range obj.Results[t].Data {
+			artist := r.Attributes.ArtistName
+			if t == "playlists" {
+				artist = r.Attributes.CuratorName
+			}
+			results = append(results, Result{
+				Type:        t,
+				ID:          r.ID,
+				Name:        r.Attributes.Name,
+				ArtistName:  artist,
+				ReleaseDate: r.Attributes.ReleaseDate,
+				URL:         r.Attributes.URL,
+			})
+		}
+	}
+	return results, nil
+}
+
+// Pick is what a script gets without a prompt: the first result, or with
+// exact the first one named term, ignoring case.
+func Pick(results []Result, term string, exact bool) (Result, bool) {
+	for _, r := range results {
+		if !exact || strings.EqualFold(strings.TrimSpace(r.Name), strings.TrimSpace(term)) {
+			return r, true
+		}
+	}
+	return Result{}, false
+}
+
+// ParseTypes accepts types in singular or plural, e.g. "song,album".
+func ParseTypes(list []string) ([]string, error) {
+	var types []string
+	for _, t := range list {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if !strings.HasSuffix(t, "s") {
+			t += "s"
+		}
+		if !contains(Types, t) {
+			return nil, fmt.Errorf("unknown search type: %s, use %s", t, strings.Join(Types, ", "))
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"main/utils/amurl"
+	"main/utils/failure"
+)
+
+const answer = `{"results": {
+	"albums": {"data": [
+		{"id": "1713845538", "attributes": {"name": "1989 (Taylor's Version)", "artistName": "Taylor Swift", "releaseDate": "2023-10-27", "url": "https://music.apple.com/us/album/1989-taylors-version/1713845538"}}
+	]},
+	"songs": {"data": [
+		{"id": "1713845540", "attributes": {"name": "Style (Taylor's Version)", "artistName": "Taylor Swift", "releaseDate": "2014-10-27"}},
+		{"id": "1440935468", "attributes": {"name": "Style", "artistName": "Taylor Swift"}}
+	]},
+	"playlists": {"data": [
+		{"id": "pl.4b364b8b182f4115acbf6deb83bd5222", "attributes": {"name": "Taylor Swift Essentials", "curatorName": "Apple Music Pop"}}
+	]},
+	"artists": {"data": [
+		{"id": "159260351", "attributes": {"name": "Taylor Swift"}}
+	]}
+}}`
+
+func TestSearch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/catalog/xx/search" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		if r.URL.Path != "/v1/catalog/us/search" || q.Get("term") != "style & red" || q.Get("types") != "playlists,songs,albums" || q.Get("limit") != "5" || q.Get("l") != "en-US" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("request without the token")
+		}
+		fmt.Fprint(w, answer)
+	}))
+	defer srv.Close()
+	amurl.APIBase = srv.URL
+	defer func() { amurl.APIBase = amurl.DefaultAPIBase }()
+
+	results, err := Search(context.Background(), "us", "style & red", []string{"playlists", "songs", "albums"}, 5, "en-US", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in the order of Types, artists were not asked for
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Type+" "+r.ID+" "+r.Name+" - "+r.ArtistName)
+	}
+	want := []string{
+		"songs 1713845540 Style (Taylor's Version) - Taylor Swift",
+		"songs 1440935468 Style - Taylor Swift",
+		"albums 1713845538 1989 (Taylor's Version) - Taylor Swift",
+		"playlists pl.4b364b8b182f4115acbf6deb83bd5222 Taylor Swift Essentials - Apple Music Pop",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Search() = %q, want %q", got, want)
+	}
+	if results[2].URL != "https://music.apple.com/us/album/1989-taylors-version/1713845538" || results[0].ReleaseDate != "2014-10-27" {
+		t.Errorf("Search() lost the URL or date: %+v", results)
+	}
+
+	_, err = Search(context.Background(), "xx", "style", Types, 5, "en-US", "token")
+	if err == nil || errors.Is(err, failure.ErrNotInStorefront) {
+		t.Errorf("Search() of a 404 = %v, want a plain error", err)
+	}
+}
+
+func TestPick(t *testing.T) {
+	results := []Result{
+		{Type: "songs", ID: "1713845540", Name: "Style (Taylor's Version)"},
+		{Type: "songs", ID: "1440935468", Name: "Style "},
+		{Type: "albums", ID: "1713845538", Name: "1989 (Taylor's Version)"},
+	}
+	tests := []struct {
+		term   string
+		exact  bool
+		wantID string
+		ok     bool
+	}{
+		{"style", false, "1713845540", true},
+		{"style", true, "1440935468", true},
+		{" STYLE ", true, "1440935468", true},
+		{"1989 (taylor's version)", true, "1713845538", true},
+		{"1989", true, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := Pick(results, tt.term, tt.exact)
+		if ok != tt.ok || got.ID != tt.wantID {
+			t.Errorf("Pick(%q, exact %v) = %s, %v, want %s, %v", tt.term, tt.exact, got.ID, ok, tt.wantID, tt.ok)
+		}
+	}
+	if _, ok := Pick(nil, "style", false); ok {
+		t.Error("Pick() of no results picked one")
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	tests := []struct {
+		list []string
+		want []string
+	}{
+		{nil, nil},
+		{[]string{"song", "Album"}, []string{"songs", "albums"}},
+		{[]string{" artists ", "music-video", "playlist"}, []string{"artists", "music-videos", "playlists"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseTypes(tt.list)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseTypes(%q) = %q, %v, want %q", tt.list, got, err, tt.want)
+		}
+	}
+	if _, err := ParseTypes([]string{"song", "station"}); err == nil {
+		t.Error("ParseTypes() accepted station")
+	}
+}
@@ -0,0 +1,177 @@
+package selector
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Option is one row the user chooses from. Options are numbered from 1 in
+// the order given.
+type Option struct {
+	Label  string // shown in the preview and matched by /text filters
+	Rating string // explicit, clean or ""
+}
+
+// Help describes the input Parse accepts.
+const Help = "numbers and ranges like 1,3,5-7 (5- to the end, -5 from the start), !3 or !2-4 to leave out, /text to match names, explicit or clean, all"
+
+// Parse returns the indexes of options chosen by input, in option order.
+// Terms are separated by commas or spaces:
+//
+//	all        every option
+//	3  2-5     an option or a range; 5- runs to the end, -5 starts at 1
+//	/live      options whose label contains "live", ignoring case
+//	explicit   options rated explicit (or clean)
+//	!3 !/live  any term with ! leaves those options out
+//
+// Input made only of ! terms starts from all options. A leading - is
+// always an open range, never an exclusion.
+func Parse(input string, options []Option) ([]int, error) {
+	include := make(map[int]bool)
+	exclude := make(map[int]bool)
+	included := false
+	var errs []error
+	for _, term := range strings.FieldsFunc(input, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		set := include
+		if strings.HasPrefix(term, "!") {
+			set, term = exclude, term[1:]
+		} else {
+			included = true
+		}
+		matched, err := match(term, options)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, i := range matched {
+			set[i] = true
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if !included {
+		for i := range options {
+			include[i] = true
+		}
+	}
+	var picked []int
+	for i := range include {
+		if !exclude[i] {
+			picked = append(picked, i)
+		}
+	}
+	sort.Ints(picked)
+	return picked, nil
+}
+
+// match returns the indexes of options one term names.
+func match(term string, options []Option) ([]int, error) {
+	var matched []int
+	lower := strings.ToLower(term)
+	switch {
+	case term == "":
+		return nil, errors.New("empty term")
+	case lower == "all":
+		for i := range options {
+			matched = append(matched, i)
+		}
+	case lower == "explicit" || lower == "clean":
+		for i, o := range options {
+			if o.Rating == lower {
+				matched = append(matched, i)
+			}
+		}
+	case strings.HasPrefix(term, "/"):
+		text := strings.ToLower(term[1:])
+		if text == "" {
+			return nil, errors.New("empty filter: /")
+		}
+		for i, o := range options {
+			if strings.Contains(strings.ToLower(o.Label), text) {
+				matched = append(matched, i)
+			}
+		}
+	default:
+		start, end, err := bounds(term, len(options))
+		if err != nil {
+			return nil, err
+		}
+		for i := start; i <= end; i++ {
+			matched = append(matched, i-1)
+		}
+	}
+	return matched, nil
+}
+
+// bounds reads a number or range of options numbered 1 to count.
+func bounds(term string, count int) (int, int, error) {
+	from, to, isRange := strings.Cut(term, "-")
+	if !isRange {
+		to = from
+	}
+	if isRange && from == "" && to == "" {
+		return 0, 0, fmt.Errorf("invalid option: %s", term)
+	}
+	if isRange && from == "" {
+		from = "1"
+	}
+	if isRange && to == "" {
+		to = strconv.Itoa(count)
+	}
+	start, err1 := strconv.Atoi(from)
+	end, err2 := strconv.Atoi(to)
+	if err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("invalid option: %s", term)
+	}
+	if start > end {
+		return 0, 0, fmt.Errorf("reversed range: %s", term)
+	}
+	if start < 1 || end > count {
+		return 0, 0, fmt.Errorf("out of range 1-%d: %s", count, term)
+	}
+	return start, end, nil
+}
+
+// Prompt asks for a choice among options until the input parses and the
+// preview of the result is confirmed. It returns nil when in ends.
+func Prompt(in *bufio.Reader, out io.Writer, what string, options []Option) []int {
+	cyanColor := color.New(color.FgCyan)
+	for {
+		fmt.Fprintf(out, "Please select from the %s above (%s)\n", what, Help)
+		cyanColor.Fprint(out, "Enter your choice: ")
+		input, err := in.ReadString('\n')
+		if err != nil && strings.TrimSpace(input) == "" {
+			return nil
+		}
+		picked, perr := Parse(strings.TrimSpace(input), options)
+		if perr != nil {
+			fmt.Fprintln(out, perr)
+			continue
+		}
+		if len(picked) == 0 {
+			fmt.Fprintln(out, "Nothing selected.")
+			continue
+		}
+		fmt.Fprintf(out, "You have selected %d of %d:\n", len(picked), len(options))
+		for _, i := range picked {
+			fmt.Fprintf(out, "  %d. %s\n", i+1, options[i].Label)
+		}
+		cyanColor.Fprint(out, "Continue? [Y/n] ")
+		answer, err := in.ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer == "" || answer == "y" || answer == "yes" {
+			return picked
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package selector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var options = []Option{
+	{Label: "Intro", Rating: ""},
+	{Label: "Song One", Rating: "explicit"},
+	{Label: "Song One", Rating: "clean"},
+	{Label: "Song Two (Live)", Rating: "explicit"},
+	{Label: "Song Three", Rating: "clean"},
+	{Label: "Live at the Hollywood Bowl", Rating: ""},
+	{Label: "Outro", Rating: ""},
+	{Label: "Bonus Track", Rating: "explicit"},
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []int
+	}{
+		{"1,3,5-7", []int{0, 2, 4, 5, 6}},
+		{"1 3 5-7", []int{0, 2, 4, 5, 6}},
+		{"1, 3,\t5-7", []int{0, 2, 4, 5, 6}},
+		{"7,1,1", []int{0, 6}},
+		{"5-", []int{4, 5, 6, 7}},
+		{"-5", []int{0, 1, 2, 3, 4}},
+		{"8-8", []int{7}},
+		{"all", []int{0, 1, 2, 3, 4, 5, 6, 7}},
+		{"ALL", []int{0, 1, 2, 3, 4, 5, 6, 7}},
+		{"!3", []int{0, 1, 3, 4, 5, 6, 7}},
+		{"!2-4", []int{0, 4, 5, 6, 7}},
+		{"!2-4 !/bonus", []int{0, 4, 5, 6}},
+		{"1-5 !3", []int{0, 1, 3, 4}},
+		{"/live", []int{3, 5}},
+		{"/LIVE", []int{3, 5}},
+		{"/song", []int{1, 2, 3, 4}},
+		{"/nothing", []int{}},
+		{"explicit", []int{1, 3, 7}},
+		{"clean", []int{2, 4}},
+		{"explicit !/live", []int{1, 7}},
+		{"all !explicit", []int{0, 2, 4, 5, 6}},
+		{"", []int{0, 1, 2, 3, 4, 5, 6, 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input, options)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.input, err)
+			}
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"5-2", "reversed range"},
+		{"0", "out of range"},
+		{"9", "out of range"},
+		{"3-9", "out of range"},
+		{"9-", "reversed range"},
+		{"!9", "out of range"},
+		{"!", "empty term"},
+		{"1 !", "empty term"},
+		{"/", "empty filter"},
+		{"-", "invalid option"},
+		{"1 - 3", "invalid option"},
+		{"two", "invalid option"},
+		{"1-2-3", "invalid option"},
+		{"0 9", "out of range 1-8: 0\nout of range 1-8: 9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input, options)
+			if err == nil {
+				t.Fatalf("Parse(%q) = %v, want an error", tt.input, got)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse(%q) error %q does not mention %q", tt.input, err, tt.want)
+			}
+		})
+	}
+}
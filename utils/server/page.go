@@ -0,0 +1,61 @@
+package server
+
+// page is the web UI served at /, a form to queue a URL and the queue
+// refreshed every few seconds.
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Apple Music Downloader</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+input[type=text] { width: 40em; }
+table { border-collapse: collapse; margin-top: 1em; }
+td, th { border-bottom: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; }
+.tracks { font-size: small; color: #555; }
+</style>
+</head>
+<body>
+<h1>Apple Music Downloader</h1>
+<form id="add">
+<input type="text" id="url" placeholder="https://music.apple.com/...">
+<label><input type="checkbox" id="atmos"> Atmos</label>
+<label><input type="checkbox" id="aac"> AAC</label>
+<input type="text" id="tracks" placeholder="tracks, e.g. 1,3,5" style="width: 10em">
+<button>Queue</button>
+</form>
+<table>
+<thead><tr><th>#</th><th>URL</th><th>State</th><th>Tracks</th><th></th></tr></thead>
+<tbody id="jobs"></tbody>
+</table>
+<script>
+const text = s => { const d = document.createElement("div"); d.textContent = s; return d.innerHTML; };
+async function refresh() {
+  const jobs = await (await fetch("/api/jobs")).json();
+  document.getElementById("jobs").innerHTML = jobs.reverse().map(j => {
+    const tracks = (j.tracks || []).map(t => text(t.number + ". " + t.name + ": " + t.status + (t.error ? " (" + t.error + ")" : ""))).join("<br>");
+    const cancel = j.state === "queued" || j.state === "running" ? '<button onclick="cancelJob(\'' + j.id + '\')">Cancel</button>' : "";
+    return "<tr><td>" + j.id + "</td><td>" + text(j.url) + "</td><td>" + j.state + (j.error ? ": " + text(j.error) : "") +
+      '</td><td class="tracks">' + tracks + "</td><td>" + cancel + "</td></tr>";
+  }).join("");
+}
+async function cancelJob(id) {
+  await fetch("/api/jobs/" + id, { method: "DELETE" });
+  refresh();
+}
+document.getElementById("add").onsubmit = async e => {
+  e.preventDefault();
+  const tracks = document.getElementById("tracks").value.split(",").map(s => parseInt(s)).filter(n => n > 0);
+  await fetch("/api/jobs", { method: "POST", body: JSON.stringify({
+    url: document.getElementById("url").value,
+    options: { atmos: document.getElementById("atmos").checked, aac: document.getElementById("aac").checked, tracks: tracks },
+  }) });
+  document.getElementById("url").value = "";
+  refresh();
+};
+refresh();
+setInterval(refresh, 3000);
+</script>
+</body>
+</html>
+`
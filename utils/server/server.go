@@ -0,0 +1,315 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"main/utils/jsonfile"
+	"main/utils/structs"
+)
+
+// Job states.
+const (
+	Queued    = "queued"
+	Running   = "running"
+	Done      = "done"
+	Failed    = "failed"
+	Cancelled = "cancelled"
+)
+
+// Options are the download settings of one job.
+type Options struct {
+	Atmos  bool  `json:"atmos,omitempty"`
+	AAC    bool  `json:"aac,omitempty"`
+	Tracks []int `json:"tracks,omitempty"` // track numbers of an album or playlist, all when empty
+}
+
+// Job is one URL queued for download.
+type Job struct {
+	ID      string                `json:"id"`
+	URL     string                `json:"url"`
+	Options Options               `json:"options"`
+	State   string                `json:"state"`
+	Error   string                `json:"error,omitempty"`
+	Tracks  []structs.TrackResult `json:"tracks"`
+	Created time.Time             `json:"created"`
+	Updated time.Time             `json:"updated"`
+}
+
+// Runner downloads job, calling report after each track. It should stop
+// early once ctx is cancelled.
+type Runner func(ctx context.Context, job Job, report func(structs.TrackResult)) error
+
+// Queue runs jobs one at a time in the order they were added, and keeps
+// them in a file so they survive a restart.
+type Queue struct {
+	mu      sync.Mutex
+	path    string
+	run     Runner
+	next    int
+	jobs    []*Job
+	wake    chan struct{}
+	running string             // ID of the running job
+	cancel  context.CancelFunc // of the running job
+}
+
+// file is what the queue file holds.
+type file struct {
+	Next int    `json:"next"`
+	Jobs []*Job `json:"jobs"`
+}
+
+// Open loads the queue file at path, a missing file is an empty queue.
+// Jobs that were running when the queue was last stopped start over.
+func Open(path string, run Runner) (*Queue, error) {
+	q := &Queue{path: path, run: run, next: 1, wake: make(chan struct{}, 1)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	q.next, q.jobs = max(f.Next, 1), f.Jobs
+	for _, job := range q.jobs {
+		if job.State == Running {
+			job.State, job.Tracks = Queued, nil
+		}
+	}
+	return q, nil
+}
+
+// save writes the queue file through a temporary file. The caller holds mu.
+func (q *Queue) save() error {
+	return jsonfile.Write(q.path, file{Next: q.next, Jobs: q.jobs})
+}
+
+// saveOrLog saves the queue for Work, which has no caller to return the
+// error to. The caller holds mu.
+func (q *Queue) saveOrLog(job *Job) {
+	if err := q.save(); err != nil {
+		slog.Error("failed to save queue", "path", q.path, "job", job.ID, "state", job.State, "err", err)
+	}
+}
+
+// Add queues url with opts.
+func (q *Queue) Add(url string, opts Options) (Job, error) {
+	if url == "" {
+		return Job{}, errors.New("url is required")
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	job := &Job{ID: strconv.Itoa(q.next), URL: url, Options: opts, State: Queued, Created: now, Updated: now}
+	q.next++
+	q.jobs = append(q.jobs, job)
+	if err := q.save(); err != nil {
+		return Job{}, err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return copyJob(job), nil
+}
+
+// List returns every job, oldest first.
+func (q *Queue) List() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		jobs = append(jobs, copyJob(job))
+	}
+	return jobs
+}
+
+// Get returns the job with id.
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job := q.find(id)
+	if job == nil {
+		return Job{}, false
+	}
+	return copyJob(job), true
+}
+
+// ErrFinished is returned when cancelling a job that already ended.
+var ErrFinished = errors.New("job already finished")
+
+// Cancel stops a running job or drops a queued one.
+func (q *Queue) Cancel(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job := q.find(id)
+	if job == nil {
+		return Job{}, os.ErrNotExist
+	}
+	switch job.State {
+	case Queued:
+		job.State, job.Updated = Cancelled, time.Now()
+		if err := q.save(); err != nil {
+			return Job{}, err
+		}
+	case Running:
+		// the job turns cancelled once the runner returns
+		q.cancel()
+	default:
+		return Job{}, ErrFinished
+	}
+	return copyJob(job), nil
+}
+
+// Work runs queued jobs until ctx is cancelled. A job stopped that way
+// is saved as queued again.
+func (q *Queue) Work(ctx context.Context) {
+	for {
+		q.mu.Lock()
+		var job *Job
+		for _, j := range q.jobs {
+			if j.State == Queued {
+				job = j
+				break
+			}
+		}
+		if job == nil {
+			q.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wake:
+				continue
+			}
+		}
+		jobCtx, cancel := context.WithCancel(ctx)
+		job.State, job.Updated = Running, time.Now()
+		q.running, q.cancel = job.ID, cancel
+		q.saveOrLog(job)
+		snapshot := copyJob(job)
+		q.mu.Unlock()
+
+		err := q.run(jobCtx, snapshot, func(result structs.TrackResult) {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			job.Tracks = append(job.Tracks, result)
+			job.Updated = time.Now()
+			q.saveOrLog(job)
+		})
+
+		q.mu.Lock()
+		switch {
+		case ctx.Err() != nil:
+			// stopped with the queue, it starts over on the next Open
+			job.State, job.Tracks = Queued, nil
+		case jobCtx.Err() != nil:
+			job.State = Cancelled
+		case err != nil:
+			job.State, job.Error = Failed, err.Error()
+		default:
+			job.State = Done
+		}
+		job.Updated = time.Now()
+		q.running, q.cancel = "", nil
+		q.saveOrLog(job)
+		q.mu.Unlock()
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (q *Queue) find(id string) *Job {
+	for _, job := range q.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+func copyJob(job *Job) Job {
+	c := *job
+	c.Tracks = append([]structs.TrackResult(nil), job.Tracks...)
+	c.Options.Tracks = append([]int(nil), job.Options.Tracks...)
+	return c
+}
+
+// Handler serves the queue:
+//
+//	POST   /api/jobs       {"url": "...", "options": {"atmos": true, "tracks": [1, 3]}}
+//	GET    /api/jobs       every job
+//	GET    /api/jobs/{id}  one job with its track results
+//	DELETE /api/jobs/{id}  cancel a job
+//	GET    /               a page to add and follow jobs
+func (q *Queue) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			URL     string  `json:"url"`
+			Options Options `json:"options"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		job, err := q.Add(body.URL, body.Options)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, job)
+	})
+	mux.HandleFunc("GET /api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, q.List())
+	})
+	mux.HandleFunc("GET /api/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		job, ok := q.Get(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", r.PathValue("id")))
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	})
+	mux.HandleFunc("DELETE /api/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		job, err := q.Cancel(r.PathValue("id"))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", r.PathValue("id")))
+		case errors.Is(err, ErrFinished):
+			writeError(w, http.StatusConflict, err)
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err)
+		default:
+			writeJSON(w, http.StatusOK, job)
+		}
+	})
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
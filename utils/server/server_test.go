@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"main/utils/structs"
+)
+
+// call sends a request to the API of srv and decodes the JSON answer into
+// v, returning the status code.
+func call(t *testing.T, srv *httptest.Server, method, path, body string, v any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// waitState polls the job until it is in state.
+func waitState(t *testing.T, srv *httptest.Server, id, state string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var job Job
+		call(t, srv, "GET", "/api/jobs/"+id, "", &job)
+		if job.State == state {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, job.State, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// work runs q until the test ends or stop is called.
+func work(t *testing.T, q *Queue) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	worked := make(chan struct{})
+	go func() {
+		q.Work(ctx)
+		close(worked)
+	}()
+	stop = func() {
+		cancel()
+		<-worked
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func TestHandler(t *testing.T) {
+	started := make(chan Job, 1)
+	release := make(chan struct{})
+	q, err := Open(filepath.Join(t.TempDir(), "queue.json"), func(ctx context.Context, job Job, report func(structs.TrackResult)) error {
+		started <- job
+		<-release
+		report(structs.TrackResult{Number: 1, ID: "1713845540", Status: structs.TrackSuccess})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(q.Handler())
+	defer srv.Close()
+	work(t, q)
+
+	var job Job
+	if code := call(t, srv, "POST", "/api/jobs", `{"url": "https://music.apple.com/us/album/1989/1713845538", "options": {"atmos": true, "tracks": [1, 3]}}`, &job); code != http.StatusCreated {
+		t.Fatalf("POST /api/jobs = %d", code)
+	}
+	if job.ID != "1" || job.State != Queued || !job.Options.Atmos || len(job.Options.Tracks) != 2 {
+		t.Errorf("POST /api/jobs = %+v", job)
+	}
+	if got := <-started; got.URL != "https://music.apple.com/us/album/1989/1713845538" || got.State != Running {
+		t.Errorf("runner got %+v", got)
+	}
+	// a second job waits for the first
+	call(t, srv, "POST", "/api/jobs", `{"url": "https://music.apple.com/us/album/red/1440935467"}`, &job)
+	var jobs []Job
+	if code := call(t, srv, "GET", "/api/jobs", "", &jobs); code != http.StatusOK || len(jobs) != 2 {
+		t.Fatalf("GET /api/jobs = %d, %d jobs", code, len(jobs))
+	}
+	if jobs[0].State != Running || jobs[1].State != Queued {
+		t.Errorf("GET /api/jobs states = %s, %s", jobs[0].State, jobs[1].State)
+	}
+	if code := call(t, srv, "DELETE", "/api/jobs/2", "", &job); code != http.StatusOK || job.State != Cancelled {
+		t.Errorf("DELETE queued job = %d, %s", code, job.State)
+	}
+	close(release)
+	job = waitState(t, srv, "1", Done)
+	if len(job.Tracks) != 1 || job.Tracks[0].Status != structs.TrackSuccess {
+		t.Errorf("finished job tracks = %+v", job.Tracks)
+	}
+
+	var body map[string]string
+	if code := call(t, srv, "DELETE", "/api/jobs/1", "", &body); code != http.StatusConflict {
+		t.Errorf("DELETE finished job = %d, want %d", code, http.StatusConflict)
+	}
+	if code := call(t, srv, "GET", "/api/jobs/9", "", &body); code != http.StatusNotFound {
+		t.Errorf("GET missing job = %d, want %d", code, http.StatusNotFound)
+	}
+	if code := call(t, srv, "DELETE", "/api/jobs/9", "", &body); code != http.StatusNotFound {
+		t.Errorf("DELETE missing job = %d, want %d", code, http.StatusNotFound)
+	}
+	if code := call(t, srv, "POST", "/api/jobs", `{"options": {}}`, &body); code != http.StatusBadRequest || body["error"] == "" {
+		t.Errorf("POST without url = %d %v", code, body)
+	}
+	if code := call(t, srv, "POST", "/api/jobs", `not json`, &body); code != http.StatusBadRequest {
+		t.Errorf("POST bad JSON = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestCancelRunning(t *testing.T) {
+	started := make(chan struct{})
+	q, err := Open(filepath.Join(t.TempDir(), "queue.json"), func(ctx context.Context, job Job, report func(structs.TrackResult)) error {
+		report(structs.TrackResult{Number: 1, Status: structs.TrackSuccess})
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(q.Handler())
+	defer srv.Close()
+	work(t, q)
+
+	var job Job
+	call(t, srv, "POST", "/api/jobs", `{"url": "https://music.apple.com/us/album/1989/1713845538"}`, &job)
+	<-started
+	if code := call(t, srv, "DELETE", "/api/jobs/"+job.ID, "", &job); code != http.StatusOK {
+		t.Fatalf("DELETE running job = %d", code)
+	}
+	job = waitState(t, srv, job.ID, Cancelled)
+	if job.Error != "" || len(job.Tracks) != 1 {
+		t.Errorf("cancelled job = %+v, want its track and no error", job)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	started := make(chan struct{})
+	q, err := Open(path, func(ctx context.Context, job Job, report func(structs.TrackResult)) error {
+		report(structs.TrackResult{Number: 1, Status: structs.TrackSuccess})
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := work(t, q)
+	if _, err := q.Add("https://music.apple.com/us/album/1989/1713845538", Options{AAC: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Add("https://music.apple.com/us/album/red/1440935467", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	// stopping the queue mid-job puts the job back in line
+	stop()
+	q, err = Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := q.List()
+	if len(jobs) != 2 || jobs[0].State != Queued || jobs[0].Tracks != nil || !jobs[0].Options.AAC || jobs[1].State != Queued {
+		t.Errorf("jobs after restart = %+v", jobs)
+	}
+	if job, _ := q.Add("https://music.apple.com/us/song/style/1713845540", Options{}); job.ID != "3" {
+		t.Errorf("job added after restart has ID %s, want 3", job.ID)
+	}
+}
+
+func TestOpenRequeuesRunning(t *testing.T) {
+	// the process was killed while job 2 was running
+	path := filepath.Join(t.TempDir(), "queue.json")
+	data := `{"next": 4, "jobs": [
+		{"id": "1", "url": "u1", "state": "done", "tracks": [{"number": 1, "status": "success"}]},
+		{"id": "2", "url": "u2", "state": "running", "tracks": [{"number": 1, "status": "success"}]},
+		{"id": "3", "url": "u3", "state": "queued"}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	q, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := q.List()
+	want := []string{Done, Queued, Queued}
+	for i, job := range jobs {
+		if job.State != want[i] {
+			t.Errorf("job %s is %s, want %s", job.ID, job.State, want[i])
+		}
+	}
+	if len(jobs[0].Tracks) != 1 || len(jobs[1].Tracks) != 0 {
+		t.Errorf("track results after Open: %+v, %+v", jobs[0].Tracks, jobs[1].Tracks)
+	}
+}
+
+func TestWorkLogsSaveErrors(t *testing.T) {
+	var log bytes.Buffer
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&log, nil)))
+	t.Cleanup(func() { slog.SetDefault(logger) })
+
+	path := filepath.Join(t.TempDir(), "queue.json")
+	q, err := Open(path, func(ctx context.Context, job Job, report func(structs.TrackResult)) error {
+		report(structs.TrackResult{Number: 1, Status: structs.TrackSuccess})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := q.Add("https://music.apple.com/us/album/1989/1713845538", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a directory in the way of the temporary file fails every save
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	work(t, q)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if got, _ := q.Get(job.ID); got.State == Done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := strings.Count(log.String(), "failed to save queue"); n != 3 {
+		t.Errorf("logged %d save failures, want 3 (running, track, done):\n%s", n, log.String())
+	}
+}
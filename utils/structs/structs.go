@@ -1,41 +1,69 @@
 package structs
 
 type ConfigSet struct {
-	MediaUserToken          string `yaml:"media-user-token"`
-	AuthorizationToken      string `yaml:"authorization-token"`
-	Language                string `yaml:"language"`
-	SaveLrcFile             bool   `yaml:"save-lrc-file"`
-	LrcType                 string `yaml:"lrc-type"`
-	LrcFormat               string `yaml:"lrc-format"`
-	SaveAnimatedArtwork     bool   `yaml:"save-animated-artwork"`
-	EmbyAnimatedArtwork     bool   `yaml:"emby-animated-artwork"`
-	EmbedLrc                bool   `yaml:"embed-lrc"`
-	EmbedCover              bool   `yaml:"embed-cover"`
-	SaveArtistCover         bool   `yaml:"save-artist-cover"`
-	CoverSize               string `yaml:"cover-size"`
-	CoverFormat             string `yaml:"cover-format"`
-	AlacSaveFolder          string `yaml:"alac-save-folder"`
-	AtmosSaveFolder         string `yaml:"atmos-save-folder"`
-	AlbumFolderFormat       string `yaml:"album-folder-format"`
-	PlaylistFolderFormat    string `yaml:"playlist-folder-format"`
-	ArtistFolderFormat      string `yaml:"artist-folder-format"`
-	SongFileFormat          string `yaml:"song-file-format"`
-	ExplicitChoice          string `yaml:"explicit-choice"`
-	CleanChoice             string `yaml:"clean-choice"`
-	AppleMasterChoice       string `yaml:"apple-master-choice"`
-	MaxMemoryLimit          int    `yaml:"max-memory-limit"`
-	DecryptM3u8Port         string `yaml:"decrypt-m3u8-port"`
-	GetM3u8Port             string `yaml:"get-m3u8-port"`
-	GetM3u8Mode             string `yaml:"get-m3u8-mode"`
-	GetM3u8FromDevice       bool   `yaml:"get-m3u8-from-device"`
-	AacType                 string `yaml:"aac-type"`
-	AlacMax                 int    `yaml:"alac-max"`
-	AtmosMax                int    `yaml:"atmos-max"`
-	LimitMax                int    `yaml:"limit-max"`
-	UseSongInfoForPlaylist  bool   `yaml:"use-songinfo-for-playlist"`
-	DlAlbumcoverForPlaylist bool   `yaml:"dl-albumcover-for-playlist"`
-	MVAudioType             string `yaml:"mv-audio-type"`
-	MVMax                   int    `yaml:"mv-max"`
+	MediaUserToken          string   `yaml:"media-user-token"`
+	AuthorizationToken      string   `yaml:"authorization-token"`
+	Language                string   `yaml:"language"`
+	SaveLrcFile             bool     `yaml:"save-lrc-file"`
+	LrcType                 string   `yaml:"lrc-type"`
+	LrcFormat               string   `yaml:"lrc-format"`
+	SaveAnimatedArtwork     bool     `yaml:"save-animated-artwork"`
+	EmbyAnimatedArtwork     bool     `yaml:"emby-animated-artwork"`
+	EmbedLrc                bool     `yaml:"embed-lrc"`
+	EmbedCover              bool     `yaml:"embed-cover"`
+	SaveArtistCover         bool     `yaml:"save-artist-cover"`
+	CoverSize               string   `yaml:"cover-size"`
+	CoverFormat             string   `yaml:"cover-format"`
+	AlacSaveFolder          string   `yaml:"alac-save-folder"`
+	AtmosSaveFolder         string   `yaml:"atmos-save-folder"`
+	AacSaveFolder           string   `yaml:"aac-save-folder"`
+	AlbumFolderFormat       string   `yaml:"album-folder-format"`
+	PlaylistFolderFormat    string   `yaml:"playlist-folder-format"`
+	ArtistFolderFormat      string   `yaml:"artist-folder-format"`
+	SongFileFormat          string   `yaml:"song-file-format"`
+	ExplicitChoice          string   `yaml:"explicit-choice"`
+	CleanChoice             string   `yaml:"clean-choice"`
+	AppleMasterChoice       string   `yaml:"apple-master-choice"`
+	MaxMemoryLimit          int      `yaml:"max-memory-limit"`
+	DecryptM3u8Port         string   `yaml:"decrypt-m3u8-port"`
+	GetM3u8Port             string   `yaml:"get-m3u8-port"`
+	GetM3u8Mode             string   `yaml:"get-m3u8-mode"`
+	GetM3u8FromDevice       bool     `yaml:"get-m3u8-from-device"`
+	AacType                 string   `yaml:"aac-type"`
+	AlacMax                 int      `yaml:"alac-max"`
+	AtmosMax                int      `yaml:"atmos-max"`
+	QualityPreference       string   `yaml:"quality-preference"`
+	PerCodecFolders         bool     `yaml:"per-codec-folders"`
+	PreferRating            string   `yaml:"prefer-rating"`
+	WatchArtists            []string `yaml:"watch-artists"`
+	WatchStorefront         string   `yaml:"watch-storefront"`
+	WatchInterval           int      `yaml:"watch-interval"`
+	WatchStateFile          string   `yaml:"watch-state-file"`
+	WatchNotifyCommand      string   `yaml:"watch-notify-command"`
+	WatchNotifyWebhook      string   `yaml:"watch-notify-webhook"`
+	UpgradeOldCopy          string   `yaml:"upgrade-old-copy"`
+	UpgradeArchiveFolder    string   `yaml:"upgrade-archive-folder"`
+	SyncRemoved             string   `yaml:"sync-removed"`
+	SyncArchiveFolder       string   `yaml:"sync-archive-folder"`
+	PlaylistFile            string   `yaml:"playlist-file"`
+	ServeAddress            string   `yaml:"serve-address"`
+	ServeQueueFile          string   `yaml:"serve-queue-file"`
+	APIBaseURL              string   `yaml:"api-base-url"`
+	LogLevel                string   `yaml:"log-level"`
+	LogFile                 string   `yaml:"log-file"`
+	LogFormat               string   `yaml:"log-format"`
+	LimitMax                int      `yaml:"limit-max"`
+	UseSongInfoForPlaylist  bool     `yaml:"use-songinfo-for-playlist"`
+	DlAlbumcoverForPlaylist bool     `yaml:"dl-albumcover-for-playlist"`
+	MVAudioType             string   `yaml:"mv-audio-type"`
+	MVMax                   int      `yaml:"mv-max"`
+	MirrorEnable            bool     `yaml:"mirror-enable"`
+	MirrorCodec             string   `yaml:"mirror-codec"`
+	MirrorBitrate           string   `yaml:"mirror-bitrate"`
+	MirrorSaveFolder        string   `yaml:"mirror-save-folder"`
+	MirrorAlbumFolderFormat string   `yaml:"mirror-album-folder-format"`
+	MirrorPlaylistFormat    string   `yaml:"mirror-playlist-folder-format"`
+	MirrorSongFileFormat    string   `yaml:"mirror-song-file-format"`
 }
 
 type Counter struct {
@@ -46,6 +74,50 @@ type Counter struct {
 	Total       int
 }
 
+// Track statuses, one for each field of Counter.
+const (
+	TrackSuccess     = "success"
+	TrackUnavailable = "unavailable"
+	TrackNotSong     = "not-song"
+	TrackError       = "error"
+)
+
+// Add counts a track that ended with status.
+func (c *Counter) Add(status string) {
+	c.Total++
+	switch status {
+	case TrackSuccess:
+		c.Success++
+	case TrackUnavailable:
+		c.Unavailable++
+	case TrackNotSong:
+		c.NotSong++
+	case TrackError:
+		c.Error++
+	}
+}
+
+// TrackResult is how saving one track of an album or playlist ended.
+type TrackResult struct {
+	Number  int    `json:"number"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Quality string `json:"quality,omitempty"` // the tier it was saved in
+	Path    string `json:"path,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Err     error  `json:"-"` // Error before it was written out
+}
+
+// End returns r with status, and err as its error when not nil.
+func (r TrackResult) End(status string, err error) TrackResult {
+	r.Status = status
+	if err != nil {
+		r.Error, r.Err = err.Error(), err
+	}
+	return r
+}
+
 type ApiResult struct {
 	Data []SongData `json:"data"`
 }
@@ -459,9 +531,12 @@ type AutoGeneratedArtist struct {
 				ID   string `json:"id"`
 				Kind string `json:"kind"`
 			} `json:"playParams"`
-			TrackNumber  int    `json:"trackNumber"`
-			AudioLocale  string `json:"audioLocale"`
-			ComposerName string `json:"composerName"`
+			TrackNumber   int    `json:"trackNumber"`
+			AudioLocale   string `json:"audioLocale"`
+			ComposerName  string `json:"composerName"`
+			IsSingle      bool   `json:"isSingle"`
+			IsCompilation bool   `json:"isCompilation"`
+			TrackCount    int    `json:"trackCount"`
 		} `json:"attributes"`
 	} `json:"data"`
 }
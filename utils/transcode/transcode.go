@@ -0,0 +1,169 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/zhaarey/go-mp4tag"
+)
+
+// Profile describes the lossy copy written next to the master library.
+type Profile struct {
+	Codec   string // aac, opus or mp3
+	Bitrate string // passed to the encoder as-is, e.g. 256k
+}
+
+// Encoder turns a master file into a file of the given profile, with the
+// picture at cover, if not empty, as its cover art.
+// Implementations must not touch src.
+type Encoder interface {
+	Encode(ctx context.Context, src string, cover string, dst string, p Profile) error
+}
+
+// FFmpeg encodes with the ffmpeg binary found in PATH.
+type FFmpeg struct{}
+
+var formats = map[string]struct {
+	encoder string
+	muxer   string
+	ext     string
+}{
+	"aac":  {"aac", "ipod", "m4a"},
+	"opus": {"libopus", "opus", "opus"},
+	"mp3":  {"libmp3lame", "mp3", "mp3"},
+}
+
+// Extension returns the file extension used for codec, without the dot.
+func Extension(codec string) string {
+	if f, ok := formats[codec]; ok {
+		return f.ext
+	}
+	return codec
+}
+
+func (FFmpeg) Encode(ctx context.Context, src string, cover string, dst string, p Profile) error {
+	if _, ok := formats[p.Codec]; !ok {
+		return fmt.Errorf("unsupported mirror codec: %s", p.Codec)
+	}
+	// Ogg has no picture stream, Opus carries the cover in its comments
+	meta := ""
+	if p.Codec == "opus" && cover != "" {
+		data, err := coverMetadata(cover)
+		if err != nil {
+			return err
+		}
+		meta = dst + ".meta"
+		if err := os.WriteFile(meta, data, 0644); err != nil {
+			return err
+		}
+		defer os.Remove(meta)
+	}
+	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegArgs(src, meta, dst, p)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg: %v %s", err, out)
+	}
+	return nil
+}
+
+// ffmpegArgs returns the ffmpeg arguments encoding src to dst, adding the
+// tags of the ffmetadata file meta, if not empty, to those of src.
+func ffmpegArgs(src string, meta string, dst string, p Profile) []string {
+	f := formats[p.Codec]
+	args := []string{"-loglevel", "error", "-y", "-i", src}
+	if meta != "" {
+		args = append(args, "-f", "ffmetadata", "-i", meta)
+	}
+	args = append(args, "-map", "0:a", "-c:a", f.encoder)
+	if p.Bitrate != "" {
+		args = append(args, "-b:a", p.Bitrate)
+	}
+	// mp3 keeps the embedded cover through ffmpeg, m4a gets it back in copyTags
+	if p.Codec == "mp3" {
+		args = append(args, "-map", "0:v?", "-c:v", "copy", "-id3v2_version", "3")
+	}
+	// the tags of src win, meta only adds the cover
+	args = append(args, "-map_metadata", "0")
+	if meta != "" {
+		args = append(args, "-map_metadata", "1")
+	}
+	return append(args, "-f", f.muxer, dst)
+}
+
+// coverMetadata returns an ffmetadata file holding the picture at cover as
+// a METADATA_BLOCK_PICTURE comment, the FLAC picture block in base64.
+func coverMetadata(cover string) ([]byte, error) {
+	data, err := os.ReadFile(cover)
+	if err != nil {
+		return nil, err
+	}
+	var width, height uint32
+	if c, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		width, height = uint32(c.Width), uint32(c.Height)
+	}
+	mime := http.DetectContentType(data)
+	var block bytes.Buffer
+	// front cover, MIME type, no description, size, unknown depth and colors, data
+	for _, v := range []any{uint32(3), uint32(len(mime)), []byte(mime), uint32(0), width, height, uint32(0), uint32(0), uint32(len(data)), data} {
+		binary.Write(&block, binary.BigEndian, v)
+	}
+	value := strings.ReplaceAll(base64.StdEncoding.EncodeToString(block.Bytes()), "=", `\=`)
+	return []byte(";FFMETADATA1\nMETADATA_BLOCK_PICTURE=" + value + "\n"), nil
+}
+
+// Mirror writes the p copy of src to dst with enc, with the picture at
+// cover, if not empty, as its cover art. Existing copies are kept, so
+// re-running over a library only encodes what is missing.
+func Mirror(ctx context.Context, enc Encoder, src string, cover string, dst string, p Profile) error {
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := dst + ".part"
+	if err := enc.Encode(ctx, src, cover, tmp, p); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if Extension(p.Codec) == "m4a" {
+		if err := copyTags(src, tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	return os.Rename(tmp, dst)
+}
+
+// copyTags copies every iTunes tag, custom atoms and the cover from src to dst.
+func copyTags(src string, dst string) error {
+	in, err := mp4tag.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tags, err := in.Read()
+	if err != nil {
+		return err
+	}
+	if tags == nil {
+		return errors.New("no tags found in master file")
+	}
+	out, err := mp4tag.Open(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return out.Write(tags, []string{})
+}
@@ -0,0 +1,167 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeEncoder writes data to dst, or fails with err after writing part
+// of it, and counts its calls and the cover it was given.
+type fakeEncoder struct {
+	data  string
+	err   error
+	calls int
+	cover string
+}
+
+func (f *fakeEncoder) Encode(ctx context.Context, src string, cover string, dst string, p Profile) error {
+	f.calls++
+	f.cover = cover
+	if err := os.WriteFile(dst, []byte(f.data), 0644); err != nil {
+		return err
+	}
+	return f.err
+}
+
+func TestMirror(t *testing.T) {
+	opus := Profile{Codec: "opus", Bitrate: "128k"}
+	tests := []struct {
+		name     string
+		existing string // content of dst before Mirror, none when empty
+		cover    bool   // pass a cover to Mirror
+		enc      fakeEncoder
+		profile  Profile
+		wantErr  bool
+		want     string // content of dst after Mirror, none when empty
+		calls    int
+	}{
+		{name: "encodes a missing copy", enc: fakeEncoder{data: "opus"}, profile: opus, want: "opus", calls: 1},
+		{name: "passes the cover", cover: true, enc: fakeEncoder{data: "opus"}, profile: opus, want: "opus", calls: 1},
+		{name: "keeps an existing copy", existing: "old", cover: true, enc: fakeEncoder{data: "opus"}, profile: opus, want: "old", calls: 0},
+		{name: "removes the part file on failure", enc: fakeEncoder{data: "half", err: errors.New("killed")}, profile: opus, wantErr: true, calls: 1},
+		// the fake output is not an mp4, so copying the tags fails
+		{name: "removes the part file when tags fail", enc: fakeEncoder{data: "not mp4"}, profile: Profile{Codec: "aac"}, wantErr: true, calls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "master.m4a")
+			if err := os.WriteFile(src, []byte("master"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			dst := filepath.Join(dir, "mirror", "01. Song."+Extension(tt.profile.Codec))
+			if tt.existing != "" {
+				os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+				if err := os.WriteFile(dst, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cover := ""
+			if tt.cover {
+				cover = filepath.Join(dir, "cover.jpg")
+			}
+			err := Mirror(context.Background(), &tt.enc, src, cover, dst, tt.profile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Mirror() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.enc.calls != tt.calls {
+				t.Errorf("Encode called %d times, want %d", tt.enc.calls, tt.calls)
+			}
+			if tt.enc.cover != cover && tt.calls > 0 {
+				t.Errorf("Encode got cover %q, want %q", tt.enc.cover, cover)
+			}
+			if _, err := os.Stat(dst + ".part"); !os.IsNotExist(err) {
+				t.Errorf("part file left behind: %v", err)
+			}
+			got, err := os.ReadFile(dst)
+			if tt.want == "" {
+				if !os.IsNotExist(err) {
+					t.Errorf("dst exists after a failed Mirror: %q", got)
+				}
+				return
+			}
+			if string(got) != tt.want {
+				t.Errorf("dst = %q, want %q", got, tt.want)
+			}
+			if master, _ := os.ReadFile(src); string(master) != "master" {
+				t.Errorf("src changed to %q", master)
+			}
+		})
+	}
+}
+
+func TestFFmpegArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		meta string
+		p    Profile
+		want string
+	}{
+		{"aac", "", Profile{Codec: "aac", Bitrate: "256k"},
+			"-loglevel error -y -i in.m4a -map 0:a -c:a aac -b:a 256k -map_metadata 0 -f ipod out"},
+		{"mp3 maps the embedded cover", "", Profile{Codec: "mp3", Bitrate: "320k"},
+			"-loglevel error -y -i in.m4a -map 0:a -c:a libmp3lame -b:a 320k -map 0:v? -c:v copy -id3v2_version 3 -map_metadata 0 -f mp3 out"},
+		{"opus adds the cover comment", "out.meta", Profile{Codec: "opus", Bitrate: "128k"},
+			"-loglevel error -y -i in.m4a -f ffmetadata -i out.meta -map 0:a -c:a libopus -b:a 128k -map_metadata 0 -map_metadata 1 -f opus out"},
+		{"opus without a cover", "", Profile{Codec: "opus"},
+			"-loglevel error -y -i in.m4a -map 0:a -c:a libopus -map_metadata 0 -f opus out"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(ffmpegArgs("in.m4a", tt.meta, "out", tt.p), " "); got != tt.want {
+			t.Errorf("%s: ffmpegArgs() =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCoverMetadata(t *testing.T) {
+	var pic bytes.Buffer
+	if err := png.Encode(&pic, image.NewGray(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	cover := filepath.Join(t.TempDir(), "cover.png")
+	if err := os.WriteFile(cover, pic.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := coverMetadata(cover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, value, ok := strings.Cut(string(data), "METADATA_BLOCK_PICTURE=")
+	if header != ";FFMETADATA1\n" || !ok || !strings.HasSuffix(value, "\n") {
+		t.Fatalf("coverMetadata() = %q", data)
+	}
+	// ffmetadata needs the base64 padding escaped
+	value = strings.TrimSuffix(value, "\n")
+	if strings.Contains(strings.ReplaceAll(value, `\=`, ""), "=") {
+		t.Errorf("unescaped = in %q", value)
+	}
+	block, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(value, `\=`, "="))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var head [2]uint32
+	binary.Read(bytes.NewReader(block), binary.BigEndian, &head)
+	mime := string(block[8 : 8+head[1]])
+	var fields [6]uint32 // description length, width, height, depth, colors, data length
+	binary.Read(bytes.NewReader(block[8+head[1]:]), binary.BigEndian, &fields)
+	if head[0] != 3 || mime != "image/png" || fields != [6]uint32{0, 3, 2, 0, 0, uint32(pic.Len())} {
+		t.Errorf("picture block type %d, %s, fields %v", head[0], mime, fields)
+	}
+	if got := block[len(block)-pic.Len():]; !reflect.DeepEqual(got, pic.Bytes()) {
+		t.Error("picture block does not end with the cover")
+	}
+
+	if _, err := coverMetadata(filepath.Join(t.TempDir(), "missing.jpg")); err == nil {
+		t.Error("coverMetadata() of a missing cover succeeded")
+	}
+}
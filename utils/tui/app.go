@@ -0,0 +1,507 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Release is an album of the discography view.
+type Release struct {
+	ID     string
+	Name   string
+	Date   string
+	Rating string // explicit, clean or ""
+}
+
+// Track is a row of the album view.
+type Track struct {
+	Number int // position in the album, from 1
+	Name   string
+	Artist string
+	Rating string // explicit, clean or ""
+	Type   string // songs or music-videos
+	Traits string // available qualities, e.g. "atmos, hi-res"
+}
+
+// Job is an album queued with the tracks chosen from it.
+type Job struct {
+	AlbumID string
+	Name    string
+	Tracks  []Track
+}
+
+// Update is what Download reports about one track of a job.
+type Update struct {
+	Track int    // the Number of the track
+	Text  string // latest output while the track runs
+	Done  bool   // the track is finished, OK tells whether it was saved
+	OK    bool
+}
+
+// App browses a discography, queues tracks and downloads them one job
+// at a time.
+type App struct {
+	Title    string
+	Releases []Release
+	// Tracks lists the tracks of a release when it is opened or queued.
+	Tracks func(release Release) ([]Track, error)
+	// Download saves a job, calling update as its tracks make progress.
+	Download func(job Job, update func(Update)) error
+
+	screen    *Screen
+	view      view
+	back      view // the view tab returns to from the queue
+	releases  list
+	release   Release
+	tracks    []Track
+	trackList list
+	queue     []*queued
+	queueList list
+	next      int // index in queue of the next job to start
+	running   bool
+	status    string
+	quitting  bool
+	updates   chan jobUpdate
+	finished  chan finished
+}
+
+type view int
+
+const (
+	releasesView view = iota
+	tracksView
+	queueView
+)
+
+type queued struct {
+	job    Job
+	text   map[int]string // latest output by track number
+	result map[int]string // done, failed or skipped by track number
+	err    error
+	over   bool
+}
+
+type jobUpdate struct {
+	job    int
+	update Update
+}
+
+type finished struct {
+	job int
+	err error
+}
+
+// list is the cursor, scroll position and picked rows of a view.
+type list struct {
+	cursor int
+	offset int
+	picked map[int]bool
+}
+
+func (l *list) move(delta, count int) {
+	l.cursor = max(0, min(l.cursor+delta, count-1))
+}
+
+func (l *list) toggle(i int) {
+	if l.picked == nil {
+		l.picked = make(map[int]bool)
+	}
+	if l.picked[i] {
+		delete(l.picked, i)
+	} else {
+		l.picked[i] = true
+	}
+}
+
+func (l *list) toggleAll(count int) {
+	if len(l.picked) == count {
+		l.picked = nil
+		return
+	}
+	l.picked = make(map[int]bool)
+	for i := 0; i < count; i++ {
+		l.picked[i] = true
+	}
+}
+
+// chosen is the picked rows in order, or the row under the cursor when
+// none are picked.
+func (l *list) chosen(count int) []int {
+	var rows []int
+	for i := range l.picked {
+		rows = append(rows, i)
+	}
+	sort.Ints(rows)
+	if len(rows) == 0 && count > 0 {
+		rows = []int{l.cursor}
+	}
+	return rows
+}
+
+// window scrolls so the cursor shows in height rows and returns the rows
+// to draw.
+func (l *list) window(count, height int) (int, int) {
+	if l.cursor < l.offset {
+		l.offset = l.cursor
+	}
+	if l.cursor >= l.offset+height {
+		l.offset = l.cursor - height + 1
+	}
+	l.offset = max(0, min(l.offset, count-height))
+	return l.offset, min(count, l.offset+height)
+}
+
+// Run shows the app on screen until the user quits.
+func (a *App) Run(screen *Screen) error {
+	a.screen = screen
+	a.updates = make(chan jobUpdate, 64)
+	a.finished = make(chan finished)
+	keys := make(chan Event)
+	go screen.Keys(keys)
+	for {
+		a.draw()
+		select {
+		case event, ok := <-keys:
+			if !ok || a.key(event) {
+				return nil
+			}
+		case p := <-a.updates:
+			a.progress(p)
+		case f := <-a.finished:
+			a.finish(f)
+		}
+	}
+}
+
+// key handles a key press and reports whether to quit.
+func (a *App) key(event Event) bool {
+	if event.Key == KeyQuit || (event.Key == KeyRune && event.Rune == 'q') {
+		if a.running && !a.quitting {
+			a.quitting = true
+			a.status = "Downloads are running, press q again to quit"
+			return false
+		}
+		return true
+	}
+	a.quitting = false
+	a.status = ""
+	if event.Key == KeyTab {
+		if a.view == queueView {
+			a.view = a.back
+		} else {
+			a.back, a.view = a.view, queueView
+		}
+		return false
+	}
+	switch a.view {
+	case releasesView:
+		if a.moveKey(event, &a.releases, len(a.Releases)) {
+			return false
+		}
+		switch {
+		case event.Key == KeySpace && len(a.Releases) > 0:
+			a.releases.toggle(a.releases.cursor)
+			a.releases.move(1, len(a.Releases))
+		case event.Key == KeyRune && event.Rune == 'a':
+			a.releases.toggleAll(len(a.Releases))
+		case event.Key == KeyEnter && len(a.Releases) > 0:
+			a.open(a.Releases[a.releases.cursor])
+		case event.Key == KeyRune && event.Rune == 'd':
+			a.queueReleases()
+		}
+	case tracksView:
+		if a.moveKey(event, &a.trackList, len(a.tracks)) {
+			return false
+		}
+		switch {
+		case event.Key == KeySpace && len(a.tracks) > 0:
+			a.trackList.toggle(a.trackList.cursor)
+			a.trackList.move(1, len(a.tracks))
+		case event.Key == KeyRune && event.Rune == 'a':
+			a.trackList.toggleAll(len(a.tracks))
+		case event.Key == KeyRune && event.Rune == 'd', event.Key == KeyEnter:
+			var tracks []Track
+			for _, i := range a.trackList.chosen(len(a.tracks)) {
+				tracks = append(tracks, a.tracks[i])
+			}
+			a.add(Job{AlbumID: a.release.ID, Name: a.release.Name, Tracks: tracks})
+			a.trackList.picked = nil
+		case event.Key == KeyBack:
+			a.view = releasesView
+		}
+	case queueView:
+		if a.moveKey(event, &a.queueList, len(a.queueLines())) {
+			return false
+		}
+		if event.Key == KeyBack {
+			a.view = a.back
+		}
+	}
+	return false
+}
+
+// moveKey moves the cursor of l for movement keys and reports whether
+// event was one.
+func (a *App) moveKey(event Event, l *list, count int) bool {
+	page := a.bodyHeight()
+	switch {
+	case event.Key == KeyUp || (event.Key == KeyRune && event.Rune == 'k'):
+		l.move(-1, count)
+	case event.Key == KeyDown || (event.Key == KeyRune && event.Rune == 'j'):
+		l.move(1, count)
+	case event.Key == KeyPageUp:
+		l.move(-page, count)
+	case event.Key == KeyPageDown:
+		l.move(page, count)
+	case event.Key == KeyHome || (event.Key == KeyRune && event.Rune == 'g'):
+		l.move(-count, count)
+	case event.Key == KeyEnd || (event.Key == KeyRune && event.Rune == 'G'):
+		l.move(count, count)
+	default:
+		return false
+	}
+	return true
+}
+
+// open shows the tracks of release.
+func (a *App) open(release Release) {
+	tracks, err := a.loadTracks(release)
+	if err != nil {
+		a.status = fmt.Sprintf("Failed to get %s: %v", release.Name, err)
+		return
+	}
+	a.release, a.tracks = release, tracks
+	a.trackList = list{}
+	a.view = tracksView
+}
+
+func (a *App) loadTracks(release Release) ([]Track, error) {
+	a.status = fmt.Sprintf("Loading %s...", release.Name)
+	a.draw()
+	a.status = ""
+	return a.Tracks(release)
+}
+
+// queueReleases queues every track of the picked releases.
+func (a *App) queueReleases() {
+	for _, i := range a.releases.chosen(len(a.Releases)) {
+		release := a.Releases[i]
+		tracks, err := a.loadTracks(release)
+		if err != nil {
+			a.status = fmt.Sprintf("Failed to get %s: %v", release.Name, err)
+			return
+		}
+		a.add(Job{AlbumID: release.ID, Name: release.Name, Tracks: tracks})
+		delete(a.releases.picked, i)
+	}
+}
+
+// add queues job and starts it when nothing is downloading.
+func (a *App) add(job Job) {
+	if len(job.Tracks) == 0 {
+		return
+	}
+	a.queue = append(a.queue, &queued{job: job, text: make(map[int]string), result: make(map[int]string)})
+	a.status = fmt.Sprintf("Queued %d tracks of %s", len(job.Tracks), job.Name)
+	a.start()
+}
+
+func (a *App) start() {
+	if a.running || a.next >= len(a.queue) {
+		return
+	}
+	index, job := a.next, a.queue[a.next].job
+	a.next++
+	a.running = true
+	go func() {
+		err := a.Download(job, func(u Update) { a.updates <- jobUpdate{job: index, update: u} })
+		a.finished <- finished{job: index, err: err}
+	}()
+}
+
+func (a *App) progress(p jobUpdate) {
+	q, u := a.queue[p.job], p.update
+	if q.result[u.Track] != "" {
+		return
+	}
+	if u.Text != "" {
+		q.text[u.Track] = u.Text
+	}
+	if u.Done {
+		q.result[u.Track] = "failed"
+		if u.OK {
+			q.result[u.Track] = "done"
+		}
+	}
+}
+
+func (a *App) finish(f finished) {
+	// every update is sent before the job finishes
+	for drained := false; !drained; {
+		select {
+		case p := <-a.updates:
+			a.progress(p)
+		default:
+			drained = true
+		}
+	}
+	q := a.queue[f.job]
+	q.err, q.over = f.err, true
+	for _, track := range q.job.Tracks {
+		if q.result[track.Number] == "" {
+			q.result[track.Number] = "skipped"
+			if f.err != nil {
+				q.result[track.Number] = "failed"
+			}
+		}
+	}
+	a.running = false
+	if a.quitting {
+		a.quitting = false
+		a.status = ""
+	}
+	a.start()
+}
+
+// bodyHeight is the rows left for a list between the header and footer.
+func (a *App) bodyHeight() int {
+	_, height := a.screen.Size()
+	return max(1, height-4)
+}
+
+func (a *App) draw() {
+	var title, header, hints string
+	var rows []string
+	var l *list
+	picks := func(l *list, i int) string {
+		if l.picked[i] {
+			return "[x] "
+		}
+		return "[ ] "
+	}
+	switch a.view {
+	case releasesView:
+		title = fmt.Sprintf("%s: %d albums", a.Title, len(a.Releases))
+		header = fmt.Sprintf("    %4s  %-10s  %-2s  %s", "#", "Date", "", "Album")
+		hints = "↑↓ move  space pick  a all  enter open  d queue  tab queue  q quit"
+		l = &a.releases
+		for i, r := range a.Releases {
+			rows = append(rows, picks(l, i)+fmt.Sprintf("%4d  %-10s  %-2s  %s", i+1, r.Date, ratingMark(r.Rating), r.Name))
+		}
+	case tracksView:
+		title = fmt.Sprintf("%s: %d tracks", a.release.Name, len(a.tracks))
+		header = fmt.Sprintf("    %3s  %-2s  %-4s  %-26s  %s", "#", "", "Type", "Quality", "Track")
+		hints = "↑↓ move  space pick  a all  enter/d queue  esc back  tab queue  q quit"
+		l = &a.trackList
+		for i, t := range a.tracks {
+			name := t.Name
+			if t.Artist != "" {
+				name += " - " + t.Artist
+			}
+			rows = append(rows, picks(l, i)+fmt.Sprintf("%3d  %-2s  %-4s  %-26s  %s", t.Number, ratingMark(t.Rating), typeMark(t.Type), t.Traits, name))
+		}
+	case queueView:
+		title = fmt.Sprintf("Queue: %d albums", len(a.queue))
+		header = fmt.Sprintf("  %-26s  %s", "Status", "Track")
+		hints = "↑↓ scroll  esc/tab back  q quit"
+		l = &a.queueList
+		rows = a.queueLines()
+	}
+	lines := []Line{{Text: title, Style: "1"}, {Text: header, Style: "4"}}
+	start, end := l.window(len(rows), a.bodyHeight())
+	for i := start; i < end; i++ {
+		style := ""
+		if i == l.cursor {
+			style = "7"
+		}
+		lines = append(lines, Line{Text: rows[i], Style: style})
+	}
+	for len(lines) < a.bodyHeight()+2 {
+		lines = append(lines, Line{})
+	}
+	status := a.status
+	if status == "" && a.running {
+		status = "Downloading, tab shows the queue"
+	}
+	lines = append(lines, Line{Text: status, Style: "1"}, Line{Text: hints, Style: "2"})
+	a.screen.Draw(lines)
+}
+
+// queueLines is the queue view: a line per job and one per track.
+func (a *App) queueLines() []string {
+	var rows []string
+	for i, q := range a.queue {
+		state := "waiting"
+		switch {
+		case q.err != nil:
+			state = "failed: " + q.err.Error()
+		case q.over:
+			state = "finished"
+		case i == a.next-1 && a.running:
+			state = "downloading"
+		}
+		saved := 0
+		for _, result := range q.result {
+			if result == "done" {
+				saved++
+			}
+		}
+		rows = append(rows, fmt.Sprintf("%s (%d/%d) %s", q.job.Name, saved, len(q.job.Tracks), state))
+		for _, t := range q.job.Tracks {
+			status := q.result[t.Number]
+			switch {
+			case status == "failed" && q.text[t.Number] != "":
+				status = "failed: " + q.text[t.Number]
+			case status == "" && q.text[t.Number] != "":
+				status = q.text[t.Number]
+			case status == "":
+				status = "queued"
+			}
+			rows = append(rows, fmt.Sprintf("  %-26s  %02d. %s", fit(status, 26), t.Number, t.Name))
+		}
+	}
+	return rows
+}
+
+func ratingMark(rating string) string {
+	switch rating {
+	case "explicit":
+		return "E"
+	case "clean":
+		return "C"
+	}
+	return ""
+}
+
+func typeMark(kind string) string {
+	switch kind {
+	case "music-videos":
+		return "MV"
+	case "songs":
+		return "SONG"
+	}
+	return kind
+}
+
+// traitNames are the audio traits shown in the album view, best first.
+var traitNames = []struct{ trait, name string }{
+	{"atmos", "atmos"},
+	{"spatial", "spatial"},
+	{"hi-res-lossless", "hi-res"},
+	{"lossless", "lossless"},
+}
+
+// Traits turns the audio traits of a song into the qualities shown in
+// the album view. Every song has AAC.
+func Traits(traits []string) string {
+	var out []string
+	for _, t := range traitNames {
+		for _, have := range traits {
+			if have == t.trait {
+				out = append(out, t.name)
+			}
+		}
+	}
+	return strings.Join(append(out, "aac"), ", ")
+}
@@ -0,0 +1,263 @@
+package tui
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var albums = map[string][]Track{
+	"1713845538": {{Number: 1, Name: "Welcome To New York"}, {Number: 2, Name: "Blank Space"}, {Number: 3, Name: "Style"}},
+	"1440935467": {{Number: 1, Name: "State Of Grace"}, {Number: 2, Name: "Red"}},
+}
+
+// testApp is an app on a screen drawn to /dev/null. download runs in
+// place of Download, its jobs are sent to the returned channel first.
+func testApp(t *testing.T, download func(job Job, update func(Update)) error) (*App, chan Job) {
+	t.Helper()
+	out, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { out.Close() })
+	jobs := make(chan Job, 8)
+	a := &App{
+		Title: "Taylor Swift",
+		Releases: []Release{
+			{ID: "1713845538", Name: "1989 (Taylor's Version)", Date: "2023-10-27"},
+			{ID: "1440935467", Name: "Red", Date: "2012-10-22"},
+			{ID: "missing", Name: "Missing"},
+		},
+		Tracks: func(release Release) ([]Track, error) {
+			tracks, ok := albums[release.ID]
+			if !ok {
+				return nil, errors.New("404 Not Found")
+			}
+			return tracks, nil
+		},
+		Download: func(job Job, update func(Update)) error {
+			jobs <- job
+			return download(job, update)
+		},
+		screen:   &Screen{out: out},
+		updates:  make(chan jobUpdate, 64),
+		finished: make(chan finished),
+	}
+	return a, jobs
+}
+
+// press feeds keys typed as on the terminal and reports whether the app
+// quit.
+func press(a *App, keys string) bool {
+	for _, event := range parseKeys([]byte(keys)) {
+		if a.key(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// wait handles updates until the running job finishes, as Run does.
+func wait(a *App) {
+	for {
+		select {
+		case p := <-a.updates:
+			a.progress(p)
+		case f := <-a.finished:
+			a.finish(f)
+			return
+		}
+	}
+}
+
+func numbers(tracks []Track) []int {
+	var out []int
+	for _, track := range tracks {
+		out = append(out, track.Number)
+	}
+	return out
+}
+
+func TestQueueTracks(t *testing.T) {
+	release := make(chan struct{})
+	a, jobs := testApp(t, func(job Job, update func(Update)) error {
+		<-release
+		if job.AlbumID == "1713845538" {
+			update(Update{Track: 1, Text: "downloading 50%"})
+			update(Update{Track: 1, Done: true, OK: true})
+			update(Update{Track: 3, Text: "no lossless stream", Done: true})
+		}
+		return nil
+	})
+	// open 1989, pick tracks 1 and 3 and queue them
+	press(a, "\r j\x1b[B d")
+	job := <-jobs
+	if job.AlbumID != "1713845538" || !reflect.DeepEqual(numbers(job.Tracks), []int{1, 3}) {
+		t.Fatalf("queued %s tracks %v, want 1713845538 tracks [1 3]", job.AlbumID, numbers(job.Tracks))
+	}
+	if a.view != tracksView || len(a.trackList.picked) != 0 {
+		t.Errorf("after queueing: view %d, picks %v", a.view, a.trackList.picked)
+	}
+	// back to the albums and queue all of Red behind it
+	press(a, "\x1b")
+	press(a, "jd")
+	if len(a.queue) != 2 || a.next != 1 || !a.running {
+		t.Fatalf("queue has %d jobs, next %d, running %v", len(a.queue), a.next, a.running)
+	}
+	if got := numbers(a.queue[1].job.Tracks); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("queued Red tracks %v, want [1 2]", got)
+	}
+
+	close(release)
+	wait(a)
+	first := a.queue[0]
+	want := map[int]string{1: "done", 3: "failed"}
+	if !first.over || !reflect.DeepEqual(first.result, want) {
+		t.Errorf("first job over %v, results %v, want %v", first.over, first.result, want)
+	}
+	// the second job starts once the first is over
+	if job := <-jobs; job.AlbumID != "1440935467" {
+		t.Errorf("second job is %s", job.AlbumID)
+	}
+	wait(a)
+	if got := a.queue[1].result; !reflect.DeepEqual(got, map[int]string{1: "skipped", 2: "skipped"}) {
+		t.Errorf("unreported tracks = %v, want skipped", got)
+	}
+	lines := a.queueLines()
+	for _, line := range []string{
+		"1989 (Taylor's Version) (1/2) finished",
+		"failed: no lossless stream",
+		"Red (0/2) finished",
+	} {
+		if !strings.Contains(strings.Join(lines, "\n"), line) {
+			t.Errorf("queue view has no %q:\n%s", line, strings.Join(lines, "\n"))
+		}
+	}
+}
+
+func TestFailedJob(t *testing.T) {
+	a, jobs := testApp(t, func(job Job, update func(Update)) error {
+		update(Update{Track: 2, Done: true, OK: true})
+		return errors.New("401 Unauthorized")
+	})
+	press(a, "\rad")
+	<-jobs
+	wait(a)
+	q := a.queue[0]
+	if !reflect.DeepEqual(q.result, map[int]string{1: "failed", 2: "done", 3: "failed"}) {
+		t.Errorf("results = %v", q.result)
+	}
+	if lines := a.queueLines(); !strings.Contains(lines[0], "failed: 401 Unauthorized") {
+		t.Errorf("job line = %q", lines[0])
+	}
+}
+
+func TestPicks(t *testing.T) {
+	a, _ := testApp(t, nil)
+	// space picks and moves down, a picks all and then none
+	press(a, "  ")
+	if !reflect.DeepEqual(a.releases.chosen(3), []int{0, 1}) || a.releases.cursor != 2 {
+		t.Errorf("after two spaces: chosen %v, cursor %d", a.releases.chosen(3), a.releases.cursor)
+	}
+	press(a, "a")
+	if got := a.releases.chosen(3); !reflect.DeepEqual(got, []int{0, 1, 2}) {
+		t.Errorf("after a: chosen %v", got)
+	}
+	press(a, "a")
+	// nothing picked chooses the row under the cursor
+	if got := a.releases.chosen(3); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("after a twice: chosen %v, want the cursor row", got)
+	}
+	// the cursor stays on the list
+	press(a, "jjj")
+	if a.releases.cursor != 2 {
+		t.Errorf("cursor moved past the end to %d", a.releases.cursor)
+	}
+	press(a, "g")
+	if a.releases.cursor != 0 {
+		t.Errorf("g moved to %d", a.releases.cursor)
+	}
+	press(a, "kG")
+	if a.releases.cursor != 2 {
+		t.Errorf("G moved to %d", a.releases.cursor)
+	}
+	// an album that fails to load stays on the album list
+	press(a, "\r")
+	if a.view != releasesView || !strings.Contains(a.status, "Failed to get Missing") {
+		t.Errorf("opening a missing album: view %d, status %q", a.view, a.status)
+	}
+}
+
+func TestViews(t *testing.T) {
+	a, _ := testApp(t, nil)
+	press(a, "\r")
+	if a.view != tracksView || a.release.Name != "1989 (Taylor's Version)" || len(a.tracks) != 3 {
+		t.Fatalf("enter opened view %d, %s", a.view, a.release.Name)
+	}
+	// tab shows the queue and returns to the view it left
+	press(a, "\t")
+	if a.view != queueView {
+		t.Errorf("tab went to view %d", a.view)
+	}
+	press(a, "\t")
+	if a.view != tracksView {
+		t.Errorf("second tab went to view %d, want the tracks", a.view)
+	}
+	press(a, "\t")
+	press(a, "\x1b")
+	if a.view != tracksView {
+		t.Errorf("escape in the queue went to view %d, want the tracks", a.view)
+	}
+	press(a, "\x1b")
+	if a.view != releasesView {
+		t.Errorf("escape in the tracks went to view %d", a.view)
+	}
+}
+
+func TestQuit(t *testing.T) {
+	a, _ := testApp(t, nil)
+	if !press(a, "q") {
+		t.Error("q did not quit")
+	}
+	a.running = true
+	if press(a, "q") || !strings.Contains(a.status, "press q again") {
+		t.Errorf("q while downloading quit at once, status %q", a.status)
+	}
+	// any other key asks again
+	press(a, "j")
+	if press(a, "q") {
+		t.Error("q after another key quit without asking")
+	}
+	if !press(a, "\x03") {
+		t.Error("second ctrl-c did not quit")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		read string
+		want []Event
+	}{
+		{"\x1b[Aj \r\t\x1b[6~é\x7f", []Event{
+			{Key: KeyUp},
+			{Key: KeyRune, Rune: 'j'},
+			{Key: KeySpace},
+			{Key: KeyEnter},
+			{Key: KeyTab},
+			{Key: KeyPageDown},
+			{Key: KeyRune, Rune: 'é'},
+			{Key: KeyBack},
+		}},
+		// a lone escape is the key, an unknown sequence is skipped whole
+		{"\x1b", []Event{{Key: KeyBack}}},
+		{"\x1b[99xq", []Event{{Key: KeyRune, Rune: 'q'}}},
+		{"\x03", []Event{{Key: KeyQuit}}},
+	}
+	for _, tt := range tests {
+		if got := parseKeys([]byte(tt.read)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseKeys(%q) = %v, want %v", tt.read, got, tt.want)
+		}
+	}
+}
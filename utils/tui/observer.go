@@ -0,0 +1,76 @@
+package tui
+
+import (
+	"fmt"
+	"sync"
+
+	"main/utils/progress"
+	"main/utils/structs"
+)
+
+// Observer turns the progress of a download into the Updates of the
+// queue view.
+type Observer struct {
+	update func(Update)
+
+	mu      sync.Mutex
+	track   int
+	stage   progress.Stage
+	percent int
+}
+
+// NewObserver returns an observer passing the progress of each track to
+// update, like the update func a Download gets.
+func NewObserver(update func(Update)) *Observer {
+	return &Observer{update: update}
+}
+
+var stageText = map[progress.Stage]string{
+	progress.Download: "downloading",
+	progress.Decrypt:  "decrypting",
+	progress.Tag:      "tagging",
+}
+
+func (o *Observer) TrackStarted(track progress.Track) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.track, o.stage, o.percent = track.Number, "", -1
+	o.update(Update{Track: track.Number, Text: "starting"})
+}
+
+// BytesDownloaded reports whole percents, or the bytes when the total is
+// not known, so a fast download does not flood the screen.
+func (o *Observer) BytesDownloaded(done, total int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if total <= 0 {
+		o.update(Update{Track: o.track, Text: fmt.Sprintf("%s %d kB", stageText[o.stage], done/1024)})
+		return
+	}
+	percent := int(done * 100 / total)
+	if percent == o.percent {
+		return
+	}
+	o.percent = percent
+	o.update(Update{Track: o.track, Text: fmt.Sprintf("%s %d%%", stageText[o.stage], percent)})
+}
+
+func (o *Observer) StageChanged(stage progress.Stage) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.stage, o.percent = stage, -1
+	o.update(Update{Track: o.track, Text: stageText[stage]})
+}
+
+func (o *Observer) TrackFinished(result structs.TrackResult) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.update(Update{Track: result.Number, Text: result.Error, Done: true, OK: result.Status == structs.TrackSuccess})
+}
+
+// Warning shows message on the track until the next update.
+func (o *Observer) Warning(message string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.update(Update{Track: o.track, Text: message})
+}
@@ -0,0 +1,195 @@
+package tui
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// Key is a key press read from the terminal.
+type Key int
+
+const (
+	KeyNone Key = iota
+	KeyUp
+	KeyDown
+	KeyPageUp
+	KeyPageDown
+	KeyHome
+	KeyEnd
+	KeyEnter
+	KeySpace
+	KeyBack // escape, backspace or left
+	KeyTab
+	KeyQuit // ctrl-c
+	KeyRune
+)
+
+// Event is a key press, with the character for KeyRune.
+type Event struct {
+	Key  Key
+	Rune rune
+}
+
+// Line is one row of the screen. Style is an SGR parameter such as "7"
+// for reverse video, "" for plain text.
+type Line struct {
+	Text  string
+	Style string
+}
+
+// Screen is the terminal in raw mode on its alternate screen.
+type Screen struct {
+	in    *os.File
+	out   *os.File
+	state *term.State
+}
+
+// Open switches the terminal to raw mode and the alternate screen. Close
+// restores it.
+func Open(in, out *os.File) (*Screen, error) {
+	if !term.IsTerminal(int(in.Fd())) || !term.IsTerminal(int(out.Fd())) {
+		return nil, errors.New("not a terminal")
+	}
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	return &Screen{in: in, out: out, state: state}, nil
+}
+
+// Close leaves the alternate screen and restores the terminal.
+func (s *Screen) Close() error {
+	fmt.Fprint(s.out, "\x1b[?25h\x1b[?1049l")
+	return term.Restore(int(s.in.Fd()), s.state)
+}
+
+// Size is the width and height of the terminal, 80x24 when unknown.
+func (s *Screen) Size() (int, int) {
+	width, height, err := term.GetSize(int(s.out.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// Draw replaces the screen with lines, cut to its width and height.
+func (s *Screen) Draw(lines []Line) {
+	width, height := s.Size()
+	var b bytes.Buffer
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i == height {
+			break
+		}
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		text := fit(line.Text, width)
+		if line.Style != "" {
+			text = "\x1b[" + line.Style + "m" + text + "\x1b[0m"
+		}
+		b.WriteString(text)
+		b.WriteString("\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+	s.out.Write(b.Bytes())
+}
+
+// Keys sends key presses to events until the terminal input ends.
+func (s *Screen) Keys(events chan<- Event) {
+	buf := make([]byte, 64)
+	for {
+		n, err := s.in.Read(buf)
+		if err != nil {
+			close(events)
+			return
+		}
+		for _, event := range parseKeys(buf[:n]) {
+			events <- event
+		}
+	}
+}
+
+// sequences are the escape sequences of the keys Keys knows.
+var sequences = map[string]Key{
+	"\x1b[A":  KeyUp,
+	"\x1bOA":  KeyUp,
+	"\x1b[B":  KeyDown,
+	"\x1bOB":  KeyDown,
+	"\x1b[D":  KeyBack,
+	"\x1bOD":  KeyBack,
+	"\x1b[C":  KeyEnter,
+	"\x1bOC":  KeyEnter,
+	"\x1b[5~": KeyPageUp,
+	"\x1b[6~": KeyPageDown,
+	"\x1b[H":  KeyHome,
+	"\x1b[1~": KeyHome,
+	"\x1b[F":  KeyEnd,
+	"\x1b[4~": KeyEnd,
+}
+
+// parseKeys splits one read from the terminal into key presses. A lone
+// escape byte is the escape key.
+func parseKeys(b []byte) []Event {
+	var events []Event
+	for len(b) > 0 {
+		if b[0] == 0x1b && len(b) > 1 {
+			matched := false
+			for seq, key := range sequences {
+				if bytes.HasPrefix(b, []byte(seq)) {
+					events = append(events, Event{Key: key})
+					b = b[len(seq):]
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				// an unknown sequence: skip to its final byte
+				end := 2
+				for end < len(b) && (b[end] < 0x40 || b[end] > 0x7e) {
+					end++
+				}
+				b = b[min(end+1, len(b)):]
+			}
+			continue
+		}
+		switch b[0] {
+		case 0x1b, 0x7f, 0x08:
+			events = append(events, Event{Key: KeyBack})
+		case '\r', '\n':
+			events = append(events, Event{Key: KeyEnter})
+		case ' ':
+			events = append(events, Event{Key: KeySpace})
+		case '\t':
+			events = append(events, Event{Key: KeyTab})
+		case 0x03:
+			events = append(events, Event{Key: KeyQuit})
+		default:
+			r, size := utf8.DecodeRune(b)
+			events = append(events, Event{Key: KeyRune, Rune: r})
+			b = b[size:]
+			continue
+		}
+		b = b[1:]
+	}
+	return events
+}
+
+// fit cuts or pads text to width columns.
+func fit(text string, width int) string {
+	if n := utf8.RuneCountInString(text); n <= width {
+		return text + strings.Repeat(" ", width-n)
+	}
+	runes := []rune(text)
+	if width < 1 {
+		return ""
+	}
+	return string(runes[:width-1]) + "…"
+}
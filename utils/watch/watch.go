@@ -0,0 +1,138 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"time"
+
+	"main/utils/jsonfile"
+)
+
+// State is the set of album IDs already seen for each watched artist.
+type State struct {
+	Artists map[string][]string `json:"artists"`
+}
+
+// Load reads the state file at path. A missing file is an empty state.
+func Load(path string) (*State, error) {
+	s := &State{Artists: make(map[string][]string)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if s.Artists == nil {
+		s.Artists = make(map[string][]string)
+	}
+	return s, nil
+}
+
+// Save writes the state to path, through a temporary file so an
+// interrupted run never leaves a truncated state behind.
+func (s *State) Save(path string) error {
+	for _, ids := range s.Artists {
+		sort.Strings(ids)
+	}
+	return jsonfile.Write(path, s)
+}
+
+// Watched reports whether artistId has been checked before.
+func (s *State) Watched(artistId string) bool {
+	_, ok := s.Artists[artistId]
+	return ok
+}
+
+// Known reports whether albumId was already seen for artistId.
+func (s *State) Known(artistId, albumId string) bool {
+	for _, id := range s.Artists[artistId] {
+		if id == albumId {
+			return true
+		}
+	}
+	return false
+}
+
+// Add records albumIds as seen for artistId.
+func (s *State) Add(artistId string, albumIds ...string) {
+	if _, ok := s.Artists[artistId]; !ok {
+		s.Artists[artistId] = []string{}
+	}
+	for _, id := range albumIds {
+		if !s.Known(artistId, id) {
+			s.Artists[artistId] = append(s.Artists[artistId], id)
+		}
+	}
+}
+
+// Release describes a new album for notifications.
+type Release struct {
+	ArtistId    string `json:"artist_id"`
+	ArtistName  string `json:"artist_name"`
+	AlbumId     string `json:"album_id"`
+	AlbumName   string `json:"album_name"`
+	ReleaseDate string `json:"release_date"`
+	URL         string `json:"url"`
+}
+
+// webhookClient posts to the notify webhook, a hung endpoint must not stall
+// the watch loop.
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// Notify runs command through the shell with the release in AM_* variables
+// and posts it as JSON to webhook. Either may be empty.
+func Notify(ctx context.Context, command string, webhook string, r Release) error {
+	var errs []error
+	if command != "" {
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", command)
+		}
+		cmd.Env = append(os.Environ(),
+			"AM_ARTIST_ID="+r.ArtistId,
+			"AM_ARTIST_NAME="+r.ArtistName,
+			"AM_ALBUM_ID="+r.AlbumId,
+			"AM_ALBUM_NAME="+r.AlbumName,
+			"AM_RELEASE_DATE="+r.ReleaseDate,
+			"AM_URL="+r.URL,
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("notify command: %v %s", err, out))
+		}
+	}
+	if webhook != "" {
+		body, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", webhook, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("notify webhook: %v", err))
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				errs = append(errs, fmt.Errorf("notify webhook: %s", resp.Status))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
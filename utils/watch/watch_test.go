@@ -0,0 +1,136 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var release = Release{
+	ArtistId:    "159260351",
+	ArtistName:  "Taylor Swift",
+	AlbumId:     "1750307020",
+	AlbumName:   "THE TORTURED POETS DEPARTMENT",
+	ReleaseDate: "2024-04-19",
+	URL:         "https://music.apple.com/us/album/the-tortured-poets-department/1750307020",
+}
+
+func TestState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watch", "state.json")
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() of a missing file: %v", err)
+	}
+	if s.Watched("159260351") {
+		t.Error("empty state watches an artist")
+	}
+	s.Add("159260351", "1713845538", "1440935467", "1713845538")
+	// an artist with nothing out yet is still watched
+	s.Add("1445883001")
+	if !s.Watched("159260351") || !s.Watched("1445883001") || s.Watched("1") {
+		t.Errorf("Watched() after Add = %v", s.Artists)
+	}
+	if !s.Known("159260351", "1440935467") || s.Known("159260351", "1750307020") || s.Known("1445883001", "1440935467") {
+		t.Errorf("Known() after Add = %v", s.Artists)
+	}
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"159260351": {"1440935467", "1713845538"}, "1445883001": {}}
+	if !reflect.DeepEqual(loaded.Artists, want) {
+		t.Errorf("Load() after Save() = %v, want %v", loaded.Artists, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(bad); err == nil {
+		t.Error("Load() of broken JSON succeeded")
+	}
+	empty := filepath.Join(dir, "empty.json")
+	if err := os.WriteFile(empty, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Add must not write to a nil map
+	s.Add("159260351", "1713845538")
+}
+
+func TestNotifyCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the notify command runs through sh")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	command := `printf '%s\n' "$AM_ARTIST_ID" "$AM_ARTIST_NAME" "$AM_ALBUM_ID" "$AM_ALBUM_NAME" "$AM_RELEASE_DATE" "$AM_URL" > ` + out
+	if err := Notify(context.Background(), command, "", release); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{release.ArtistId, release.ArtistName, release.AlbumId, release.AlbumName, release.ReleaseDate, release.URL}, "\n") + "\n"
+	if string(data) != want {
+		t.Errorf("command saw %q, want %q", data, want)
+	}
+
+	err = Notify(context.Background(), "echo oops; exit 3", "", release)
+	if err == nil || !strings.Contains(err.Error(), "notify command") || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("Notify() of a failing command = %v", err)
+	}
+}
+
+func TestNotifyWebhook(t *testing.T) {
+	var got Release
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("webhook got %s with %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer srv.Close()
+
+	if err := Notify(context.Background(), "", srv.URL+"/hook", release); err != nil {
+		t.Fatal(err)
+	}
+	if got != release {
+		t.Errorf("webhook got %+v, want %+v", got, release)
+	}
+	if err := Notify(context.Background(), "", srv.URL+"/gone", release); err == nil || !strings.Contains(err.Error(), "410 Gone") {
+		t.Errorf("Notify() of a 410 webhook = %v", err)
+	}
+	if err := Notify(context.Background(), "", "", release); err != nil {
+		t.Errorf("Notify() with nothing to notify = %v", err)
+	}
+	// a cancelled run does not post
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got = Release{}
+	if err := Notify(ctx, "", srv.URL+"/hook", release); err == nil || got != (Release{}) {
+		t.Errorf("Notify() with a cancelled context = %v, webhook got %+v", err, got)
+	}
+}